@@ -2,122 +2,75 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"net"
-	"time"
+	"os"
 
+	"github.com/ArtyomArtamonov/msg/internal/app"
+	"github.com/ArtyomArtamonov/msg/internal/config"
 	"github.com/ArtyomArtamonov/msg/internal/model"
 	"github.com/ArtyomArtamonov/msg/internal/repository"
 	"github.com/ArtyomArtamonov/msg/internal/server"
-	"github.com/ArtyomArtamonov/msg/internal/service"
 	"github.com/jmoiron/sqlx"
-	"github.com/streadway/amqp"
 
-	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
-
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
 )
 
 func main() {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetLevel(logrus.TraceLevel)
 
-	err := godotenv.Load("../../.env")
-	failOnError(err, "Error loading .env file")
-
-	env := server.NewEnv()
+	cfg, err := config.Load(config.ApiService, os.Args[1:])
+	app.FailOnError(err, "could not load config")
 
-	host := env.API_HOST
+	host := cfg.ApiHost
 	lis, err := net.Listen("tcp", host)
-	failOnError(err, "could not create tcp connection")
-
-	connectionString := fmt.Sprintf(
-		"host=database port=5432 sslmode=disable dbname=%s user=%s password=%s",
-		env.POSTGRES_DB,
-		env.POSTGRES_USER,
-		env.POSTGRES_PASSWORD,
-	)
-	db := sqlx.MustOpen("postgres", connectionString)
-
-	err = db.Ping()
-	failOnError(err, "could not ping database")
+	app.FailOnError(err, "could not create tcp connection")
+
+	db, err := repository.OpenPostgres(cfg.Database, cfg.Timeouts.Database)
+	app.FailOnError(err, "could not connect to database")
 	defer db.Close()
 
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@message-broker:5672/",
-		env.RABBITMQ_DEFAULT_USER,
-		env.RABBITMQ_DEFAULT_PASS))
-	failOnError(err, "could not connect to message-broker")
+	// Api service only publishes, so bus is created without dispatcher
+	bus, err := app.NewEventBus(cfg, nil)
+	app.FailOnError(err, "could not create event bus")
+	defer bus.Close()
+
+	createDebugUsers(db)
+
+	jwtManager, err := app.NewJWTManager(cfg, db, true)
+	app.FailOnError(err, "could not load jwt keys")
 
-	ch, err := conn.Channel()
-	failOnError(err, "could not create channel (message-broker)")
-	defer ch.Close()
+	grpcServer, err := app.NewGRPCServer(cfg, jwtManager)
+	app.FailOnError(err, "could not create grpc server")
 
-	grpcServer := createAndPrepareGRPCServer(db, ch, env)
+	app.RegisterAuthService(grpcServer, db, jwtManager)
+	err = app.RegisterApiService(grpcServer, db, cfg, jwtManager, bus)
+	app.FailOnError(err, "could not create api service")
+	app.RegisterHealthServer(grpcServer, bus)
 
 	logrus.Info("Starting grpc server on ", host)
-	if err := grpcServer.Serve(lis); err != nil {
+	if err := server.Serve(grpcServer, lis, cfg.Timeouts.Shutdown); err != nil {
 		logrus.Fatal(err.Error())
 	}
 }
 
-func createAndPrepareGRPCServer(db *sqlx.DB, ch *amqp.Channel, env *server.Env) *grpc.Server {
-	endpoints := server.NewEndpoints()
-	endpointRoles := server.NewEndpointRoles(endpoints)
-
-	// AUTH
+// DEBUG PURPOSE BLOCK
+func createDebugUsers(db *sqlx.DB) {
 	userStore := repository.NewPostgresUserStore(db)
-	refreshTokenStore := repository.NewRefreshTokenPostgresStore(db)
-	// DEBUG PURPOSE BLOCK
-	{
-		user, err := model.NewUser("user", "user", model.USER_ROLE)
-		if err != nil {
-			logrus.Error(err)
-		}
-		if err := userStore.Save(context.TODO(), user); err != nil {
-			logrus.Error(err)
-		}
-
-		admin, err := model.NewUser("admin", "admin", model.ADMIN_ROLE)
-		if err != nil {
-			logrus.Error(err)
-		}
-		if err := userStore.Save(context.TODO(), admin); err != nil {
-			logrus.Error(err)
-		}
-	}
-	jwtManager := service.NewJWTManager(
-		env.JWT_SECRET,
-		time.Minute*time.Duration(env.JWT_DURATION_MIN),
-		time.Hour*24*time.Duration(env.REFRESH_DURATION_DAYS),
-	)
-
-	authServer := server.NewAuthServer(userStore, refreshTokenStore, jwtManager)
-	authInterceptor := server.NewAuthInterceptor(jwtManager, endpointRoles)
-
-	// API
-	amqpManager := service.NewRabbitMQManager(ch)
-	roomStore := repository.NewPostgresRoomStore(db)
-	messageStore := repository.NewPostgresMessageStore(db)
-	apiServer := server.NewApiServer(jwtManager, roomStore, messageStore, amqpManager)
 
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(authInterceptor.Unary()),
-		grpc.StreamInterceptor(authInterceptor.Stream()),
-	)
-
-	pb.RegisterAuthServiceServer(grpcServer, authServer)
-	pb.RegisterApiServiceServer(grpcServer, apiServer)
-
-	reflection.Register(grpcServer)
-
-	return grpcServer
-}
+	user, err := model.NewUser("user", "user", model.USER_ROLE)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if err := userStore.Save(context.TODO(), user); err != nil {
+		logrus.Error(err)
+	}
 
-func failOnError(err error, text string) {
+	admin, err := model.NewUser("admin", "admin", model.ADMIN_ROLE)
 	if err != nil {
-		logrus.Fatalf("%s: %v", text, err)
+		logrus.Error(err)
+	}
+	if err := userStore.Save(context.TODO(), admin); err != nil {
+		logrus.Error(err)
 	}
 }
@@ -1,81 +1,49 @@
 package main
 
 import (
-	"fmt"
 	"net"
-	"time"
+	"os"
 
+	"github.com/ArtyomArtamonov/msg/internal/app"
+	"github.com/ArtyomArtamonov/msg/internal/config"
 	"github.com/ArtyomArtamonov/msg/internal/repository"
 	"github.com/ArtyomArtamonov/msg/internal/server"
-	proto "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
 	"github.com/ArtyomArtamonov/msg/internal/service"
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
-	"github.com/streadway/amqp"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
 )
 
 func main() {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetLevel(logrus.TraceLevel)
 
-	err := godotenv.Load("../../.env")
-	failOnError(err, "Error loading .env file")
+	cfg, err := config.Load(config.MessageService, os.Args[1:])
+	app.FailOnError(err, "could not load config")
 
-	env := server.NewEnv()
-
-	host := env.MESSAGE_HOST
+	host := cfg.MessageHost
 	lis, err := net.Listen("tcp", host)
-	failOnError(err, "could not create tcp connection")
-
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@message-broker:5672/",
-		env.RABBITMQ_DEFAULT_USER,
-		env.RABBITMQ_DEFAULT_PASS))
-	failOnError(err, "could not connect to message-broker")
+	app.FailOnError(err, "could not create tcp connection")
 
-	ch, err := conn.Channel()
-	failOnError(err, "could not create channel (message-broker)")
-	defer ch.Close()
-
-	grpcServer := createAndPrepareGRPCServer(ch, env)
-	
-	logrus.Info("Starting grpc server on ", host)
-	if err := grpcServer.Serve(lis); err != nil {
-		logrus.Fatal(err.Error())
-	}
-}
+	db, err := repository.OpenPostgres(cfg.Database, cfg.Timeouts.Database)
+	app.FailOnError(err, "could not connect to database")
+	defer db.Close()
 
-func createAndPrepareGRPCServer(ch *amqp.Channel, env *server.Env) *grpc.Server {
-	endpoints := server.NewEndpoints()
-	endpointRoles := server.NewEndpointRoles(endpoints)
-
-	jwtManager := service.NewJWTManager(
-		env.JWT_SECRET,
-		time.Minute*time.Duration(env.JWT_DURATION_MIN),
-		time.Hour*24*time.Duration(env.REFRESH_DURATION_DAYS),
-	)
 	sessionStore := repository.NewInMemorySessionStore()
-	messageServer := server.NewMessageServer(jwtManager, sessionStore)
-
-	authInterceptor := server.NewAuthInterceptor(jwtManager, endpointRoles)
-
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(authInterceptor.Unary()),
-		grpc.StreamInterceptor(authInterceptor.Stream()),
-	)
+	bus, err := app.NewEventBus(cfg, service.NewEventDispatcher(sessionStore))
+	app.FailOnError(err, "could not create event bus")
+	defer bus.Close()
 
-	proto.RegisterMessageServiceServer(grpcServer, messageServer)
-	reflection.Register(grpcServer)
+	// Message service never issues tokens, so it does not need private key
+	jwtManager, err := app.NewJWTManager(cfg, db, false)
+	app.FailOnError(err, "could not load jwt keys")
 
-	amqpConsumer := service.NewRabbitMQConsumer(ch, sessionStore)
-	go amqpConsumer.Consume()
+	grpcServer, err := app.NewGRPCServer(cfg, jwtManager)
+	app.FailOnError(err, "could not create grpc server")
 
-	return grpcServer
-}
+	app.RegisterMessageService(grpcServer, db, jwtManager, bus, sessionStore)
+	app.RegisterHealthServer(grpcServer, bus)
 
-func failOnError(err error, text string) {
-	if err != nil {
-		logrus.Fatalf("%s: %v", text, err)
+	logrus.Info("Starting grpc server on ", host)
+	if err := server.Serve(grpcServer, lis, cfg.Timeouts.Shutdown); err != nil {
+		logrus.Fatal(err.Error())
 	}
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"os"
+
+	"github.com/ArtyomArtamonov/msg/internal/app"
+	"github.com/ArtyomArtamonov/msg/internal/config"
+	"github.com/ArtyomArtamonov/msg/internal/repository"
+	"github.com/ArtyomArtamonov/msg/internal/server"
+	"github.com/ArtyomArtamonov/msg/internal/service"
+	"github.com/sirupsen/logrus"
+)
+
+// msg hosts AuthService, ApiService and MessageService on one grpc server.
+// Deliveries go from api to sessions through in-process event bus, so no
+// message broker is needed. Outbox relay is woken up by every sent message,
+// so deliveries do not wait for outbox to be polled.
+func main() {
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	logrus.SetLevel(logrus.TraceLevel)
+
+	cfg, err := config.Load(config.AllInOne, os.Args[1:])
+	app.FailOnError(err, "could not load config")
+
+	host := cfg.ApiHost
+	lis, err := net.Listen("tcp", host)
+	app.FailOnError(err, "could not create tcp connection")
+
+	db, err := repository.OpenPostgres(cfg.Database, cfg.Timeouts.Database)
+	app.FailOnError(err, "could not connect to database")
+	defer db.Close()
+
+	sessionStore := repository.NewInMemorySessionStore()
+	bus := service.NewInProcessEventBus(service.NewEventDispatcher(sessionStore))
+	defer bus.Close()
+
+	jwtManager, err := app.NewJWTManager(cfg, db, true)
+	app.FailOnError(err, "could not load jwt keys")
+
+	grpcServer, err := app.NewGRPCServer(cfg, jwtManager)
+	app.FailOnError(err, "could not create grpc server")
+
+	app.RegisterAuthService(grpcServer, db, jwtManager)
+	err = app.RegisterApiService(grpcServer, db, cfg, jwtManager, bus)
+	app.FailOnError(err, "could not create api service")
+	app.RegisterMessageService(grpcServer, db, jwtManager, bus, sessionStore)
+	app.RegisterHealthServer(grpcServer, bus)
+
+	logrus.Info("Starting grpc server on ", host)
+	if err := server.Serve(grpcServer, lis, cfg.Timeouts.Shutdown); err != nil {
+		logrus.Fatal(err.Error())
+	}
+}
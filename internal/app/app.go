@@ -0,0 +1,167 @@
+// Package app wires stores and services into grpc services, so api_service,
+// message_service and all-in-one msg are assembled the same way.
+package app
+
+import (
+	"fmt"
+
+	"github.com/ArtyomArtamonov/msg/internal/config"
+	"github.com/ArtyomArtamonov/msg/internal/repository"
+	"github.com/ArtyomArtamonov/msg/internal/server"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/service"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// NewJWTManager
+//
+// Signs tokens with asymmetric key if one is configured and falls back to
+// shared JWT_SECRET otherwise. Manager of service which does not [sign]
+// tokens only gets public keys, so it has no access to private key.
+func NewJWTManager(cfg *config.Config, db *sqlx.DB, sign bool) (*service.JWTManager, error) {
+	tokenDenylist := repository.NewPostgresTokenDenylist(db)
+
+	privateKeyPath, keyId := cfg.JWT.PrivateKeyPath, cfg.JWT.KeyId
+	if !sign {
+		privateKeyPath, keyId = "", ""
+	}
+	if privateKeyPath == "" && cfg.JWT.PublicKeysPath == "" {
+		return service.NewJWTManager(cfg.JWT.Secret, cfg.JWT.TokenDuration, cfg.JWT.RefreshDuration, tokenDenylist), nil
+	}
+
+	keys, err := service.LoadJWTKeySet(privateKeyPath, keyId, cfg.JWT.PublicKeysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.NewJWTManagerWithKeys(keys, cfg.JWT.TokenDuration, cfg.JWT.RefreshDuration, tokenDenylist), nil
+}
+
+// NewGRPCServer creates grpc server of [cfg] which authorizes calls with
+// [jwtManager] and supports reflection.
+func NewGRPCServer(cfg *config.Config, jwtManager service.JWTManagerProtol) (*grpc.Server, error) {
+	authInterceptor := server.NewAuthInterceptor(jwtManager, server.NewEndpointRoles(server.NewEndpoints()))
+
+	grpcServer, err := server.NewGRPCServer(cfg,
+		grpc.UnaryInterceptor(authInterceptor.Unary()),
+		grpc.StreamInterceptor(authInterceptor.Stream()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	reflection.Register(grpcServer)
+
+	return grpcServer, nil
+}
+
+func RegisterAuthService(grpcServer *grpc.Server, db *sqlx.DB, jwtManager *service.JWTManager) {
+	userStore := repository.NewPostgresUserStore(db)
+	refreshTokenStore := repository.NewRefreshTokenPostgresStore(db)
+
+	pb.RegisterAuthServiceServer(grpcServer, server.NewAuthServer(userStore, refreshTokenStore, jwtManager))
+}
+
+// RegisterApiService
+//
+// Registers ApiService publishing events to [producer] and starts outbox
+// relay, which delivers sent messages to [producer] as well, and janitor
+// deleting stale and deleted attachments.
+func RegisterApiService(grpcServer *grpc.Server, db *sqlx.DB, cfg *config.Config, jwtManager *service.JWTManager, producer service.EventProducer) error {
+	blobStore, err := repository.NewLocalBlobStore(cfg.AttachmentsPath)
+	if err != nil {
+		return fmt.Errorf("could not create attachments directory: %w", err)
+	}
+
+	attachmentStore := repository.NewPostgresAttachmentStore(db)
+	go service.NewAttachmentJanitor(attachmentStore, blobStore).Run()
+
+	outboxRelay := service.NewOutboxRelay(repository.NewPostgresOutboxStore(db), producer)
+	go outboxRelay.Run()
+
+	apiServer := server.NewApiServer(
+		jwtManager,
+		repository.NewPostgresRoomStore(db),
+		repository.NewPostgresMessageStore(db),
+		repository.NewPostgresInviteStore(db),
+		attachmentStore,
+		blobStore,
+		service.NewCursorCodec(cfg.CursorSecret),
+		producer,
+		outboxRelay,
+	)
+	pb.RegisterApiServiceServer(grpcServer, apiServer)
+
+	return nil
+}
+
+// RegisterMessageService
+//
+// Registers MessageService streaming events of [bus] to sessions kept in
+// [sessionStore], which [bus] dispatches deliveries to, and starts
+// consuming [bus].
+func RegisterMessageService(grpcServer *grpc.Server, db *sqlx.DB, jwtManager *service.JWTManager, bus service.EventBus, sessionStore repository.SessionStore) {
+	roomStore := repository.NewPostgresRoomStore(db)
+	presenceStore := repository.NewPostgresPresenceStore(db)
+	presenceTracker := service.NewBusPresenceTracker(bus, presenceStore, roomStore)
+
+	messageServer := server.NewMessageServer(
+		jwtManager,
+		service.NewPresenceSessionStore(service.NewSubscribingSessionStore(sessionStore, bus), presenceTracker),
+		repository.NewPostgresMessageStore(db),
+		roomStore,
+		presenceStore,
+		presenceTracker,
+	)
+	pb.RegisterMessageServiceServer(grpcServer, messageServer)
+
+	go bus.Consume()
+	go presenceTracker.Run()
+}
+
+// NewEventBus connects to broker selected by configuration. Deliveries of
+// subscribed users are handed to [dispatcher], bus without dispatcher only
+// publishes.
+func NewEventBus(cfg *config.Config, dispatcher *service.EventDispatcher) (service.EventBus, error) {
+	switch cfg.Broker.Kind {
+	case config.BrokerRabbitMQ:
+		connection := service.NewAMQPConnection(cfg.Broker.BrokerURL())
+		bus := service.NewRabbitMQEventBus(connection, dispatcher)
+		connection.Start()
+		return bus, nil
+	case config.BrokerNATS:
+		bus, err := service.NewNATSEventBus(cfg.Broker.BrokerURL(), dispatcher)
+		if err != nil {
+			return nil, err
+		}
+		return bus, nil
+	default:
+		return nil, fmt.Errorf("unsupported broker kind %q (should be %q or %q)", cfg.Broker.Kind, config.BrokerRabbitMQ, config.BrokerNATS)
+	}
+}
+
+// RegisterHealthServer reports services as serving only while connection
+// of [bus] to message broker is up.
+func RegisterHealthServer(grpcServer *grpc.Server, bus service.EventBus) {
+	healthServer := health.NewServer()
+	bus.OnStateChange(func(connected bool) {
+		status := healthpb.HealthCheckResponse_NOT_SERVING
+		if connected {
+			status = healthpb.HealthCheckResponse_SERVING
+		}
+		healthServer.SetServingStatus("", status)
+	})
+
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+}
+
+func FailOnError(err error, text string) {
+	if err != nil {
+		logrus.Fatalf("%s: %v", text, err)
+	}
+}
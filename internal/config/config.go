@@ -0,0 +1,284 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of message broker services exchange events through.
+const (
+	BrokerRabbitMQ = "rabbitmq"
+	BrokerNATS     = "nats"
+)
+
+// Service is kind of process configuration is loaded for. Services differ
+// in settings they require.
+type Service string
+
+const (
+	ApiService     Service = "api_service"
+	MessageService Service = "message_service"
+	// AllInOne is msg command hosting every service.
+	AllInOne Service = "msg"
+)
+
+// signsTokens reports whether service issues access tokens rather than only
+// verifies them.
+func (s Service) signsTokens() bool {
+	return s != MessageService
+}
+
+// servesApi reports whether service hosts ApiService, which signs page
+// tokens.
+func (s Service) servesApi() bool {
+	return s != MessageService
+}
+
+// Config
+//
+// Settings of every service. Values are merged from defaults, config file,
+// environment variables and command-line flags, later sources win. Field is
+// read from file by its yaml path, from env variable named in env tag and
+// from flag named after yaml path, e.g. -database.host.
+type Config struct {
+	ApiHost         string `yaml:"api_host" env:"API_HOST" usage:"address api_service (or all-in-one msg) listens on"`
+	MessageHost     string `yaml:"message_host" env:"MESSAGE_HOST" usage:"address message_service listens on"`
+	AttachmentsPath string `yaml:"attachments_path" env:"ATTACHMENTS_PATH" usage:"directory uploaded attachments are kept in"`
+	CursorSecret    string `yaml:"cursor_secret" env:"CURSOR_SECRET" usage:"secret page tokens are signed with, defaults to jwt secret"`
+
+	Database DatabaseConfig `yaml:"database"`
+	Broker   BrokerConfig   `yaml:"broker"`
+	JWT      JWTConfig      `yaml:"jwt"`
+	TLS      TLSConfig      `yaml:"tls"`
+	Limits   LimitsConfig   `yaml:"limits"`
+	Timeouts TimeoutsConfig `yaml:"timeouts"`
+}
+
+// DatabaseConfig
+//
+// Connection to PostgreSQL. DSN is used as is if set, otherwise it is made
+// of separate fields.
+type DatabaseConfig struct {
+	DSN             string        `yaml:"dsn" env:"DATABASE_URL" usage:"postgres connection string, overrides other database settings"`
+	Host            string        `yaml:"host" env:"POSTGRES_HOST" usage:"postgres host"`
+	Port            int           `yaml:"port" env:"POSTGRES_PORT" usage:"postgres port"`
+	Name            string        `yaml:"name" env:"POSTGRES_DB" usage:"postgres database"`
+	User            string        `yaml:"user" env:"POSTGRES_USER" usage:"postgres user"`
+	Password        string        `yaml:"password" env:"POSTGRES_PASSWORD" usage:"postgres password"`
+	SSLMode         string        `yaml:"sslmode" env:"POSTGRES_SSLMODE" usage:"postgres sslmode"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"POSTGRES_MAX_OPEN_CONNS" usage:"maximum number of open connections, 0 is unlimited"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"POSTGRES_MAX_IDLE_CONNS" usage:"maximum number of idle connections"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"POSTGRES_CONN_MAX_LIFETIME" usage:"maximum time connection is reused for, 0 is forever"`
+}
+
+// BrokerConfig
+//
+// Connection to message broker. URL is used as is if set, otherwise it is
+// made of separate fields.
+type BrokerConfig struct {
+	Kind     string `yaml:"kind" env:"EVENT_BUS" usage:"message broker, rabbitmq or nats"`
+	URL      string `yaml:"url" env:"BROKER_URL" usage:"message broker url, overrides other broker settings"`
+	Host     string `yaml:"host" env:"BROKER_HOST" usage:"message broker host"`
+	Port     int    `yaml:"port" env:"BROKER_PORT" usage:"message broker port, defaults to 5672 for rabbitmq and 4222 for nats"`
+	User     string `yaml:"user" env:"RABBITMQ_DEFAULT_USER" usage:"message broker user"`
+	Password string `yaml:"password" env:"RABBITMQ_DEFAULT_PASS" usage:"message broker password"`
+}
+
+type JWTConfig struct {
+	Secret          string        `yaml:"secret" env:"JWT_SECRET" usage:"secret access tokens are signed with (HS256)"`
+	PrivateKeyPath  string        `yaml:"private_key_path" env:"JWT_PRIVATE_KEY_PATH" usage:"PEM encoded private key access tokens are signed with"`
+	KeyId           string        `yaml:"key_id" env:"JWT_KEY_ID" usage:"id of private key"`
+	PublicKeysPath  string        `yaml:"public_keys_path" env:"JWT_PUBLIC_KEYS_PATH" usage:"directory with PEM encoded public keys named <key id>.pem"`
+	TokenDuration   time.Duration `yaml:"token_duration" env:"JWT_DURATION" usage:"access token lifetime"`
+	RefreshDuration time.Duration `yaml:"refresh_duration" env:"REFRESH_DURATION" usage:"refresh token lifetime"`
+}
+
+// TLSConfig
+//
+// Certificate grpc servers are served with. Servers accept plaintext
+// connections if it is not set.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" env:"TLS_CERT_FILE" usage:"PEM encoded server certificate"`
+	KeyFile  string `yaml:"key_file" env:"TLS_KEY_FILE" usage:"PEM encoded private key of server certificate"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+type LimitsConfig struct {
+	MaxRecvMsgSize       int `yaml:"max_recv_msg_size" env:"MAX_RECV_MSG_SIZE" usage:"maximum size of grpc message server receives, in bytes"`
+	MaxSendMsgSize       int `yaml:"max_send_msg_size" env:"MAX_SEND_MSG_SIZE" usage:"maximum size of grpc message server sends, in bytes"`
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams" env:"MAX_CONCURRENT_STREAMS" usage:"maximum number of concurrent streams per client connection"`
+}
+
+type TimeoutsConfig struct {
+	Database time.Duration `yaml:"database" env:"DATABASE_TIMEOUT" usage:"how long to wait for database on startup"`
+	Shutdown time.Duration `yaml:"shutdown" env:"SHUTDOWN_TIMEOUT" usage:"how long to wait for running calls on shutdown before they are cancelled"`
+}
+
+// Default returns configuration matching docker-compose setup.
+func Default() *Config {
+	return &Config{
+		ApiHost:         ":50051",
+		MessageHost:     ":50052",
+		AttachmentsPath: "attachments",
+		Database: DatabaseConfig{
+			Host:            "database",
+			Port:            5432,
+			SSLMode:         "disable",
+			MaxOpenConns:    20,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+		},
+		Broker: BrokerConfig{
+			Kind: BrokerRabbitMQ,
+			Host: "message-broker",
+		},
+		JWT: JWTConfig{
+			TokenDuration:   15 * time.Minute,
+			RefreshDuration: 90 * 24 * time.Hour,
+		},
+		Limits: LimitsConfig{
+			MaxRecvMsgSize:       4 << 20,
+			MaxSendMsgSize:       4 << 20,
+			MaxConcurrentStreams: 1000,
+		},
+		Timeouts: TimeoutsConfig{
+			Database: 10 * time.Second,
+			Shutdown: 10 * time.Second,
+		},
+	}
+}
+
+// DataSourceName returns DSN if it is set or builds postgres url of
+// separate fields otherwise.
+func (c DatabaseConfig) DataSourceName() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Name,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+
+	return dsn.String()
+}
+
+// BrokerURL returns URL if it is set or builds url of separate fields for
+// broker of Kind otherwise.
+func (c BrokerConfig) BrokerURL() string {
+	if c.URL != "" {
+		return c.URL
+	}
+
+	scheme, port := "amqp", 5672
+	if c.Kind == BrokerNATS {
+		scheme, port = "nats", 4222
+	}
+	if c.Port != 0 {
+		port = c.Port
+	}
+
+	brokerURL := url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(c.Host, strconv.Itoa(port)),
+		Path:   "/",
+	}
+	if c.User != "" {
+		brokerURL.User = url.UserPassword(c.User, c.Password)
+	}
+	if c.Kind == BrokerNATS {
+		brokerURL.Path = ""
+	}
+
+	return brokerURL.String()
+}
+
+// ValidationError lists every problem found in configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks configuration of [service] as a whole, so all problems are
+// reported at once.
+func (c *Config) Validate(service Service) error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.ApiHost != "", "api_host (API_HOST) is required")
+	check(c.MessageHost != "", "message_host (MESSAGE_HOST) is required")
+	check(c.AttachmentsPath != "", "attachments_path (ATTACHMENTS_PATH) is required")
+
+	if c.Database.DSN == "" {
+		check(c.Database.Host != "", "database.host (POSTGRES_HOST) is required unless database.dsn (DATABASE_URL) is set")
+		check(c.Database.Name != "", "database.name (POSTGRES_DB) is required unless database.dsn (DATABASE_URL) is set")
+		check(c.Database.User != "", "database.user (POSTGRES_USER) is required unless database.dsn (DATABASE_URL) is set")
+		check(c.Database.Port > 0 && c.Database.Port < 65536, "database.port (POSTGRES_PORT) should be between 1 and 65535, got %d", c.Database.Port)
+	}
+	check(c.Database.MaxOpenConns >= 0, "database.max_open_conns (POSTGRES_MAX_OPEN_CONNS) should not be negative")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns (POSTGRES_MAX_IDLE_CONNS) should not be negative")
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime (POSTGRES_CONN_MAX_LIFETIME) should not be negative")
+
+	check(c.Broker.Kind == BrokerRabbitMQ || c.Broker.Kind == BrokerNATS, "broker.kind (EVENT_BUS) should be %q or %q, got %q", BrokerRabbitMQ, BrokerNATS, c.Broker.Kind)
+	if c.Broker.URL == "" {
+		check(c.Broker.Host != "", "broker.host (BROKER_HOST) is required unless broker.url (BROKER_URL) is set")
+		check(c.Broker.Port >= 0 && c.Broker.Port < 65536, "broker.port (BROKER_PORT) should be between 1 and 65535 or 0 for default, got %d", c.Broker.Port)
+	} else {
+		_, err := url.Parse(c.Broker.URL)
+		check(err == nil, "broker.url (BROKER_URL) is not valid url: %v", err)
+	}
+
+	if service.signsTokens() {
+		check(c.JWT.Secret != "" || c.JWT.PrivateKeyPath != "", "jwt.secret (JWT_SECRET) or jwt.private_key_path (JWT_PRIVATE_KEY_PATH) is required to sign tokens with")
+	} else {
+		check(c.JWT.Secret != "" || c.JWT.PublicKeysPath != "", "jwt.secret (JWT_SECRET) or jwt.public_keys_path (JWT_PUBLIC_KEYS_PATH) is required to verify tokens with")
+	}
+	check(c.JWT.PrivateKeyPath == "" || c.JWT.KeyId != "", "jwt.key_id (JWT_KEY_ID) is required with jwt.private_key_path (JWT_PRIVATE_KEY_PATH)")
+	check(c.JWT.TokenDuration > 0, "jwt.token_duration (JWT_DURATION) should be positive")
+	check(c.JWT.RefreshDuration > 0, "jwt.refresh_duration (REFRESH_DURATION) should be positive")
+	if service.servesApi() {
+		check(c.CursorSecret != "", "cursor_secret (CURSOR_SECRET) or jwt.secret (JWT_SECRET) it falls back to is required to sign page tokens with")
+	}
+
+	if c.TLS.Enabled() {
+		check(c.TLS.CertFile != "" && c.TLS.KeyFile != "", "tls.cert_file (TLS_CERT_FILE) and tls.key_file (TLS_KEY_FILE) should be set together")
+		for _, path := range []string{c.TLS.CertFile, c.TLS.KeyFile} {
+			if path == "" {
+				continue
+			}
+			_, err := os.Stat(path)
+			check(err == nil, "tls file %s is not readable: %v", path, err)
+		}
+	}
+
+	check(c.Limits.MaxRecvMsgSize > 0, "limits.max_recv_msg_size (MAX_RECV_MSG_SIZE) should be positive")
+	check(c.Limits.MaxSendMsgSize > 0, "limits.max_send_msg_size (MAX_SEND_MSG_SIZE) should be positive")
+	check(c.Limits.MaxConcurrentStreams > 0, "limits.max_concurrent_streams (MAX_CONCURRENT_STREAMS) should be positive")
+
+	check(c.Timeouts.Database > 0, "timeouts.database (DATABASE_TIMEOUT) should be positive")
+	check(c.Timeouts.Shutdown > 0, "timeouts.shutdown (SHUTDOWN_TIMEOUT) should be positive")
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
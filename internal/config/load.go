@@ -0,0 +1,235 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv is env variable config file path is taken from unless
+// -config flag is given.
+const ConfigFileEnv = "MSG_CONFIG"
+
+// DefaultEnvFile is loaded into environment if it exists, variables which
+// are set already are not overridden.
+const DefaultEnvFile = ".env"
+
+// field is single setting of Config together with names it is looked up by.
+type field struct {
+	path  string
+	env   string
+	usage string
+	value reflect.Value
+}
+
+// Load
+//
+// Builds configuration of defaults, config file, environment and
+// command-line [args], in that order, and validates it for [service]. Config
+// file is given with -config flag or MSG_CONFIG env variable, env file is
+// given with -env-file flag and defaults to .env in working directory.
+func Load(service Service, args []string) (*Config, error) {
+	config := Default()
+	fields := collectFields(reflect.ValueOf(config).Elem(), "")
+
+	flags := flag.NewFlagSet(string(service), flag.ContinueOnError)
+	configFile := flags.String("config", "", "yaml config file, defaults to "+ConfigFileEnv+" env variable")
+	envFile := flags.String("env-file", "", "file with env variables, defaults to "+DefaultEnvFile+" if it exists")
+	flagValues := make(map[string]string)
+	for _, f := range fields {
+		flags.Func(f.path, fmt.Sprintf("%s (env %s)", f.usage, f.env), func(path string) func(string) error {
+			return func(raw string) error {
+				flagValues[path] = raw
+				return nil
+			}
+		}(f.path))
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadEnvFile(*envFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if *configFile == "" {
+		*configFile = os.Getenv(ConfigFileEnv)
+	}
+	if *configFile != "" {
+		err = loadFile(config, *configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = applyLegacyEnv(config)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		raw, ok := os.LookupEnv(f.env)
+		if !ok || raw == "" {
+			continue
+		}
+		err = setValue(f.value, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q of env variable %s: %w", raw, f.env, err)
+		}
+	}
+
+	for _, f := range fields {
+		raw, ok := flagValues[f.path]
+		if !ok {
+			continue
+		}
+		err = setValue(f.value, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q of flag -%s: %w", raw, f.path, err)
+		}
+	}
+
+	// Page tokens are signed with jwt secret unless dedicated secret is set
+	if config.CursorSecret == "" {
+		config.CursorSecret = config.JWT.Secret
+	}
+
+	err = config.Validate(service)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func collectFields(value reflect.Value, prefix string) []field {
+	var fields []field
+	for i := 0; i < value.NumField(); i++ {
+		structField := value.Type().Field(i)
+		path := prefix + structField.Tag.Get("yaml")
+
+		if structField.Type.Kind() == reflect.Struct && structField.Type != reflect.TypeOf(time.Duration(0)) {
+			fields = append(fields, collectFields(value.Field(i), path+".")...)
+			continue
+		}
+
+		fields = append(fields, field{
+			path:  path,
+			env:   structField.Tag.Get("env"),
+			usage: structField.Tag.Get("usage"),
+			value: value.Field(i),
+		})
+	}
+
+	return fields
+}
+
+func setValue(value reflect.Value, raw string) error {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int:
+		number, err := strconv.Atoi(raw)
+		if err != nil {
+			return errors.New("should be a number")
+		}
+		value.SetInt(int64(number))
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Type())
+	}
+
+	return nil
+}
+
+// loadEnvFile loads [path] into environment. Missing default file is not an
+// error, missing file given explicitly is.
+func loadEnvFile(path string) error {
+	if path == "" {
+		_, err := os.Stat(DefaultEnvFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		path = DefaultEnvFile
+	}
+
+	err := godotenv.Load(path)
+	if err != nil {
+		return fmt.Errorf("could not load env file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadFile reads yaml (or json, which is yaml as well) config file on top of
+// [config]. Unknown keys are reported, so typos do not go unnoticed.
+func loadFile(config *Config, path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return fmt.Errorf("unsupported config file format %s, should be yaml or json", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyLegacyEnv reads token lifetimes from JWT_DURATION_MIN and
+// REFRESH_DURATION_DAYS env variables earlier versions were configured with.
+func applyLegacyEnv(config *Config) error {
+	legacy := []struct {
+		env   string
+		unit  time.Duration
+		value *time.Duration
+	}{
+		{"JWT_DURATION_MIN", time.Minute, &config.JWT.TokenDuration},
+		{"REFRESH_DURATION_DAYS", 24 * time.Hour, &config.JWT.RefreshDuration},
+	}
+
+	for _, l := range legacy {
+		raw := os.Getenv(l.env)
+		if raw == "" {
+			continue
+		}
+
+		number, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid value %q of env variable %s: should be a number", raw, l.env)
+		}
+		*l.value = time.Duration(number) * l.unit
+	}
+
+	return nil
+}
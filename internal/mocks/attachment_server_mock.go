@@ -0,0 +1,29 @@
+package mocks
+
+import (
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+)
+
+type UploadAttachmentServerMock struct {
+	ServerStreamMock
+}
+
+func (m *UploadAttachmentServerMock) SendAndClose(attachment *pb.Attachment) error {
+	args := m.Called(attachment)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *UploadAttachmentServerMock) Recv() (*pb.UploadAttachmentRequest, error) {
+	args := m.Called()
+	return utils.Unwrap[*pb.UploadAttachmentRequest](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+type DownloadAttachmentServerMock struct {
+	ServerStreamMock
+}
+
+func (m *DownloadAttachmentServerMock) Send(response *pb.DownloadAttachmentResponse) error {
+	args := m.Called(response)
+	return utils.Unwrap[error](args.Get(0))
+}
@@ -0,0 +1,55 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type AttachmentStoreMock struct {
+	mock.Mock
+}
+
+func (m *AttachmentStoreMock) Add(ctx context.Context, attachment *model.Attachment) error {
+	args := m.Called(ctx, attachment)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *AttachmentStoreMock) Get(ctx context.Context, id uuid.UUID) (*model.Attachment, error) {
+	args := m.Called(ctx, id)
+	return utils.Unwrap[*model.Attachment](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *AttachmentStoreMock) GetMany(ctx context.Context, ids ...uuid.UUID) ([]model.Attachment, error) {
+	args := m.Called(ctx, ids)
+	return utils.Unwrap[[]model.Attachment](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *AttachmentStoreMock) ListByMessages(ctx context.Context, messageIds ...uuid.UUID) ([]model.Attachment, error) {
+	args := m.Called(ctx, messageIds)
+	return utils.Unwrap[[]model.Attachment](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *AttachmentStoreMock) CountUnsent(ctx context.Context, uploaderId uuid.UUID) (int, error) {
+	args := m.Called(ctx, uploaderId)
+	return args.Int(0), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *AttachmentStoreMock) DeleteUnsent(ctx context.Context, createdBefore time.Time) (int64, error) {
+	args := m.Called(ctx, createdBefore)
+	return utils.Unwrap[int64](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *AttachmentStoreMock) ListDeletedBlobs(ctx context.Context, limit int) ([]string, error) {
+	args := m.Called(ctx, limit)
+	return utils.Unwrap[[]string](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *AttachmentStoreMock) ForgetDeletedBlobs(ctx context.Context, keys ...string) error {
+	args := m.Called(ctx, keys)
+	return utils.Unwrap[error](args.Get(0))
+}
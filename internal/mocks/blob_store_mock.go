@@ -0,0 +1,35 @@
+package mocks
+
+import (
+	"context"
+	"io"
+
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/stretchr/testify/mock"
+)
+
+type BlobStoreMock struct {
+	mock.Mock
+}
+
+// Put reads whole content, so expectations are set on content rather than
+// on reader.
+func (m *BlobStoreMock) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	args := m.Called(ctx, key, content)
+	return int64(args.Int(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *BlobStoreMock) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	args := m.Called(ctx, key)
+	return utils.Unwrap[io.ReadCloser](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *BlobStoreMock) Delete(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return utils.Unwrap[error](args.Get(0))
+}
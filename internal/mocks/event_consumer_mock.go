@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type EventConsumerMock struct {
+	mock.Mock
+}
+
+func (m *EventConsumerMock) Consume() {
+	m.Called()
+}
+
+func (m *EventConsumerMock) Subscribe(userId uuid.UUID) error {
+	args := m.Called(userId)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *EventConsumerMock) Unsubscribe(userId uuid.UUID) error {
+	args := m.Called(userId)
+	return utils.Unwrap[error](args.Get(0))
+}
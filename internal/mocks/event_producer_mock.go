@@ -6,11 +6,11 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
-type AMQPProducerMock struct {
+type EventProducerMock struct {
 	mock.Mock
 }
 
-func (m *AMQPProducerMock) Produce(message *proto.MessageDelivery) error {
+func (m *EventProducerMock) Produce(message *proto.MessageDelivery) error {
 	args := m.Called(message)
 	return utils.Unwrap[error](args.Get(0))
 }
@@ -0,0 +1,15 @@
+package mocks
+
+import (
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+)
+
+type GetMessagesServerMock struct {
+	ServerStreamMock
+}
+
+func (m *GetMessagesServerMock) Send(message *pb.MessageStreamResponse) error {
+	args := m.Called(message)
+	return utils.Unwrap[error](args.Get(0))
+}
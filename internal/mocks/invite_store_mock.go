@@ -0,0 +1,40 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type InviteStoreMock struct {
+	mock.Mock
+}
+
+func (m *InviteStoreMock) Add(ctx context.Context, invite *model.Invite) error {
+	args := m.Called(ctx, invite)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *InviteStoreMock) Get(ctx context.Context, code string) (*model.Invite, error) {
+	args := m.Called(ctx, code)
+	return utils.Unwrap[*model.Invite](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *InviteStoreMock) ListByRoom(ctx context.Context, roomId uuid.UUID) ([]model.Invite, error) {
+	args := m.Called(ctx, roomId)
+	return utils.Unwrap[[]model.Invite](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *InviteStoreMock) Revoke(ctx context.Context, code string, revokedAt time.Time) error {
+	args := m.Called(ctx, code, revokedAt)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *InviteStoreMock) Join(ctx context.Context, code string, userId uuid.UUID, message *model.Message) (bool, error) {
+	args := m.Called(ctx, code, userId, message)
+	return args.Bool(0), utils.Unwrap[error](args.Get(1))
+}
@@ -32,3 +32,13 @@ func (m *JWTManagerMock) GetAndVerifyClaims(ctx context.Context) (*model.UserCla
 	args := m.Called(ctx)
 	return utils.Unwrap[*model.UserClaims](args.Get(0)), utils.Unwrap[error](args.Get(1))
 }
+
+func (m *JWTManagerMock) Revoke(ctx context.Context, refreshToken *model.RefreshToken) error {
+	args := m.Called(ctx, refreshToken)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *JWTManagerMock) JWKS() []model.JWK {
+	args := m.Called()
+	return utils.Unwrap[[]model.JWK](args.Get(0))
+}
@@ -14,17 +14,82 @@ type MessageStoreMock struct {
 	mock.Mock
 }
 
-func (m *MessageStoreMock) ListMessages(ctx context.Context, id uuid.UUID, createdAt time.Time, pageSize int) ([]model.Message, error) {
-	args := m.Called(ctx, id, pageSize)
+func (m *MessageStoreMock) ListMessages(ctx context.Context, id, userId uuid.UUID, beforeSeq int64, pageSize int) ([]model.Message, error) {
+	args := m.Called(ctx, id, userId, beforeSeq, pageSize)
 	return utils.Unwrap[[]model.Message](args.Get(0)), utils.Unwrap[error](args.Get(1))
 }
 
-func (m *MessageStoreMock) ListMessagesFirst(ctx context.Context, id uuid.UUID, pageSize int) ([]model.Message, error) {
-	args := m.Called(ctx, id, pageSize)
+func (m *MessageStoreMock) ListMessagesNewer(ctx context.Context, id, userId uuid.UUID, afterSeq int64, pageSize int) ([]model.Message, error) {
+	args := m.Called(ctx, id, userId, afterSeq, pageSize)
 	return utils.Unwrap[[]model.Message](args.Get(0)), utils.Unwrap[error](args.Get(1))
 }
 
-func (m *MessageStoreMock) SendMessage(ctx context.Context, message *model.Message) (error) {
-	args := m.Called(ctx, message)
+func (m *MessageStoreMock) ListThread(ctx context.Context, rootId, userId uuid.UUID, beforeSeq int64, pageSize int) ([]model.Message, error) {
+	args := m.Called(ctx, rootId, userId, beforeSeq, pageSize)
+	return utils.Unwrap[[]model.Message](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *MessageStoreMock) ListThreadFirst(ctx context.Context, rootId, userId uuid.UUID, pageSize int) ([]model.Message, error) {
+	args := m.Called(ctx, rootId, userId, pageSize)
+	return utils.Unwrap[[]model.Message](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *MessageStoreMock) ListMessagesFirst(ctx context.Context, id, userId uuid.UUID, pageSize int) ([]model.Message, error) {
+	args := m.Called(ctx, id, userId, pageSize)
+	return utils.Unwrap[[]model.Message](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *MessageStoreMock) ListMessagesSince(ctx context.Context, userId uuid.UUID, since time.Time, roomSeqs map[uuid.UUID]int64, limit int) ([]model.Message, error) {
+	args := m.Called(ctx, userId, since, roomSeqs, limit)
+	return utils.Unwrap[[]model.Message](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *MessageStoreMock) SendMessage(ctx context.Context, message *model.Message, recipientIds []uuid.UUID) (error) {
+	args := m.Called(ctx, message, recipientIds)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *MessageStoreMock) GetMessage(ctx context.Context, id uuid.UUID) (*model.Message, error) {
+	args := m.Called(ctx, id)
+	return utils.Unwrap[*model.Message](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *MessageStoreMock) EditMessage(ctx context.Context, id uuid.UUID, text string, editedAt time.Time) error {
+	args := m.Called(ctx, id, text, editedAt)
 	return utils.Unwrap[error](args.Get(0))
 }
+
+func (m *MessageStoreMock) DeleteMessage(ctx context.Context, id uuid.UUID, deletedAt time.Time) error {
+	args := m.Called(ctx, id, deletedAt)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *MessageStoreMock) HideMessage(ctx context.Context, id, userId uuid.UUID) error {
+	args := m.Called(ctx, id, userId)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *MessageStoreMock) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	args := m.Called(ctx, reaction)
+	return args.Bool(0), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *MessageStoreMock) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	args := m.Called(ctx, reaction)
+	return args.Bool(0), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *MessageStoreMock) ReactionCounts(ctx context.Context, userId uuid.UUID, messageIds ...uuid.UUID) ([]model.ReactionCount, error) {
+	args := m.Called(ctx, userId, messageIds)
+	return utils.Unwrap[[]model.ReactionCount](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *MessageStoreMock) SearchMessages(ctx context.Context, search *model.MessageSearch) ([]model.SearchResult, error) {
+	args := m.Called(ctx, search)
+	return utils.Unwrap[[]model.SearchResult](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *MessageStoreMock) GetMessageByClientId(ctx context.Context, userId uuid.UUID, clientMessageId string) (*model.Message, error) {
+	args := m.Called(ctx, userId, clientMessageId)
+	return utils.Unwrap[*model.Message](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
@@ -0,0 +1,13 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+)
+
+type OutboxNotifierMock struct {
+	mock.Mock
+}
+
+func (m *OutboxNotifierMock) Notify() {
+	m.Called()
+}
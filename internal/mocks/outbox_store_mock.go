@@ -0,0 +1,44 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/stretchr/testify/mock"
+)
+
+type OutboxStoreMock struct {
+	mock.Mock
+}
+
+func (m *OutboxStoreMock) Claim(ctx context.Context, now, leaseUntil time.Time, limit int) ([]model.OutboxEvent, error) {
+	args := m.Called(ctx, now, leaseUntil, limit)
+	return utils.Unwrap[[]model.OutboxEvent](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *OutboxStoreMock) MarkSent(ctx context.Context, id int64, sentAt time.Time) error {
+	args := m.Called(ctx, id, sentAt)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *OutboxStoreMock) MarkFailed(ctx context.Context, id int64, nextAttemptAt time.Time, reason string) error {
+	args := m.Called(ctx, id, nextAttemptAt, reason)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *OutboxStoreMock) MarkPartiallySent(ctx context.Context, id int64, delivery []byte, nextAttemptAt time.Time, reason string) error {
+	args := m.Called(ctx, id, delivery, nextAttemptAt, reason)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *OutboxStoreMock) MarkDead(ctx context.Context, id int64, deadAt time.Time, reason string) error {
+	args := m.Called(ctx, id, deadAt, reason)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *OutboxStoreMock) DeleteSent(ctx context.Context, before time.Time) (int64, error) {
+	args := m.Called(ctx, before)
+	return args.Get(0).(int64), utils.Unwrap[error](args.Get(1))
+}
@@ -0,0 +1,30 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type PresenceStoreMock struct {
+	mock.Mock
+}
+
+func (m *PresenceStoreMock) Get(ctx context.Context, userIds ...uuid.UUID) ([]model.Presence, error) {
+	args := m.Called(ctx, userIds)
+	return utils.Unwrap[[]model.Presence](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *PresenceStoreMock) SetLastSeen(ctx context.Context, userId uuid.UUID, lastSeenAt time.Time) error {
+	args := m.Called(ctx, userId, lastSeenAt)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *PresenceStoreMock) SetHideLastSeen(ctx context.Context, userId uuid.UUID, hide bool) error {
+	args := m.Called(ctx, userId, hide)
+	return utils.Unwrap[error](args.Get(0))
+}
@@ -0,0 +1,23 @@
+package mocks
+
+import (
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type PresenceTrackerMock struct {
+	mock.Mock
+}
+
+func (m *PresenceTrackerMock) Connect(userId uuid.UUID) {
+	m.Called(userId)
+}
+
+func (m *PresenceTrackerMock) Disconnect(userId uuid.UUID) {
+	m.Called(userId)
+}
+
+func (m *PresenceTrackerMock) IsOnline(userId uuid.UUID) bool {
+	args := m.Called(userId)
+	return args.Bool(0)
+}
@@ -27,3 +27,28 @@ func (m *RefreshTokenStoreMock) Get(ctx context.Context, token uuid.UUID) (*mode
 	args := m.Called(ctx, token)
 	return utils.Unwrap[*model.RefreshToken](args.Get(0)), utils.Unwrap[error](args.Get(1))
 }
+
+func (m *RefreshTokenStoreMock) Rotate(ctx context.Context, oldToken uuid.UUID, newToken *model.RefreshToken) error {
+	args := m.Called(ctx, oldToken, newToken)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *RefreshTokenStoreMock) ListByUser(ctx context.Context, userId uuid.UUID) ([]model.RefreshToken, error) {
+	args := m.Called(ctx, userId)
+	return utils.Unwrap[[]model.RefreshToken](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *RefreshTokenStoreMock) DeleteByUser(ctx context.Context, userId uuid.UUID) error {
+	args := m.Called(ctx, userId)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *RefreshTokenStoreMock) ListByFamily(ctx context.Context, familyId uuid.UUID) ([]model.RefreshToken, error) {
+	args := m.Called(ctx, familyId)
+	return utils.Unwrap[[]model.RefreshToken](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *RefreshTokenStoreMock) DeleteFamily(ctx context.Context, familyId uuid.UUID) error {
+	args := m.Called(ctx, familyId)
+	return utils.Unwrap[error](args.Get(0))
+}
@@ -19,8 +19,8 @@ func (m *RoomStoreMock) Add(ctx context.Context, room *model.Room) error {
 	return utils.Unwrap[error](args.Get(0))
 }
 
-func (m *RoomStoreMock) AddAndSendMessage(ctx context.Context, room *model.Room, message *model.Message) (*model.Room, error) {
-	args := m.Called(ctx, room, message)
+func (m *RoomStoreMock) AddAndSendMessage(ctx context.Context, room *model.Room, message *model.Message, recipientIds []uuid.UUID) (*model.Room, error) {
+	args := m.Called(ctx, room, message, recipientIds)
 	return utils.Unwrap[*model.Room](args.Get(0)), utils.Unwrap[error](args.Get(1))
 }
 
@@ -34,6 +34,41 @@ func (m *RoomStoreMock) FindDialogRoom(ctx context.Context, userId1, userId2 uui
 	return utils.Unwrap[*model.Room](args.Get(0)), utils.Unwrap[error](args.Get(1))
 }
 
+func (m *RoomStoreMock) AddMembers(ctx context.Context, roomId uuid.UUID, userIds []uuid.UUID, message *model.Message) error {
+	args := m.Called(ctx, roomId, userIds, message)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *RoomStoreMock) RemoveMember(ctx context.Context, roomId, userId uuid.UUID, message *model.Message) error {
+	args := m.Called(ctx, roomId, userId, message)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *RoomStoreMock) SetMemberRole(ctx context.Context, roomId, userId uuid.UUID, role string, message *model.Message) error {
+	args := m.Called(ctx, roomId, userId, role, message)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *RoomStoreMock) TransferOwnership(ctx context.Context, roomId, ownerId, userId uuid.UUID, message *model.Message) error {
+	args := m.Called(ctx, roomId, ownerId, userId, message)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *RoomStoreMock) Rename(ctx context.Context, roomId uuid.UUID, name string, message *model.Message) error {
+	args := m.Called(ctx, roomId, name, message)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *RoomStoreMock) Members(ctx context.Context, id uuid.UUID) ([]model.RoomMember, error) {
+	args := m.Called(ctx, id)
+	return utils.Unwrap[[]model.RoomMember](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *RoomStoreMock) RoomMates(ctx context.Context, userId uuid.UUID) ([]uuid.UUID, error) {
+	args := m.Called(ctx, userId)
+	return utils.Unwrap[[]uuid.UUID](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
 func (m *RoomStoreMock) UsersInRoom(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
 	args := m.Called(ctx, id)
 	return utils.Unwrap[[]uuid.UUID](args.Get(0)), utils.Unwrap[error](args.Get(1))
@@ -44,8 +79,13 @@ func (m *RoomStoreMock) FindByIds(ctx context.Context, userIds ...uuid.UUID) ([]
 	return utils.Unwrap[[]model.User](args.Get(0)), utils.Unwrap[error](args.Get(1))
 }
 
-func (m *RoomStoreMock) ListRooms(ctx context.Context, userId uuid.UUID, lastMessageDate time.Time, pageSize int) ([]model.Room, error) {
-	args := m.Called(ctx, userId, lastMessageDate, pageSize)
+func (m *RoomStoreMock) ListRooms(ctx context.Context, userId uuid.UUID, lastMessageTime time.Time, roomId uuid.UUID, pageSize int) ([]model.Room, error) {
+	args := m.Called(ctx, userId, lastMessageTime, roomId, pageSize)
+	return utils.Unwrap[[]model.Room](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
+
+func (m *RoomStoreMock) ListRoomsNewer(ctx context.Context, userId uuid.UUID, lastMessageTime time.Time, roomId uuid.UUID, pageSize int) ([]model.Room, error) {
+	args := m.Called(ctx, userId, lastMessageTime, roomId, pageSize)
 	return utils.Unwrap[[]model.Room](args.Get(0)), utils.Unwrap[error](args.Get(1))
 }
 
@@ -53,3 +93,8 @@ func (m *RoomStoreMock) ListRoomsFirst(ctx context.Context, userId uuid.UUID, pa
 	args := m.Called(ctx, userId, pageSize)
 	return utils.Unwrap[[]model.Room](args.Get(0)), utils.Unwrap[error](args.Get(1))
 }
+
+func (m *RoomStoreMock) MarkRead(ctx context.Context, marker *model.ReadMarker) (bool, error) {
+	args := m.Called(ctx, marker)
+	return args.Bool(0), utils.Unwrap[error](args.Get(1))
+}
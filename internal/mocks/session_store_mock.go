@@ -0,0 +1,27 @@
+package mocks
+
+import (
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type SessionStoreMock struct {
+	mock.Mock
+}
+
+func (m *SessionStoreMock) Add(session *model.Session) error {
+	args := m.Called(session)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *SessionStoreMock) Send(userId uuid.UUID, messageStream *pb.MessageStreamResponse) error {
+	args := m.Called(userId, messageStream)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *SessionStoreMock) Delete(userId, sessionId uuid.UUID) {
+	m.Called(userId, sessionId)
+}
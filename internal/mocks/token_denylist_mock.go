@@ -0,0 +1,24 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type TokenDenylistMock struct {
+	mock.Mock
+}
+
+func (m *TokenDenylistMock) Add(ctx context.Context, jti uuid.UUID, expiresAt time.Time) error {
+	args := m.Called(ctx, jti, expiresAt)
+	return utils.Unwrap[error](args.Get(0))
+}
+
+func (m *TokenDenylistMock) Contains(ctx context.Context, jti uuid.UUID) (bool, error) {
+	args := m.Called(ctx, jti)
+	return utils.Unwrap[bool](args.Get(0)), utils.Unwrap[error](args.Get(1))
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Attachment is metadata of file uploaded to room, content of file is kept
+// in blob store under attachment id.
+type Attachment struct {
+	Id         uuid.UUID     `db:"id"`
+	RoomId     uuid.UUID     `db:"room_id"`
+	UploaderId uuid.UUID     `db:"uploader_id"`
+	MessageId  uuid.NullUUID `db:"message_id"`
+	FileName   string        `db:"file_name"`
+	MimeType   string        `db:"mime_type"`
+	Size       int64         `db:"size"`
+	Sha256     string        `db:"sha256"`
+	Width      int           `db:"width"`
+	Height     int           `db:"height"`
+	CreatedAt  time.Time     `db:"created_at"`
+}
+
+func NewAttachment(uploaderId, roomId uuid.UUID, fileName string) *Attachment {
+	return &Attachment{
+		Id:         uuid.New(),
+		RoomId:     roomId,
+		UploaderId: uploaderId,
+		FileName:   fileName,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// BlobKey is key content of attachment is stored under.
+func (a *Attachment) BlobKey() string {
+	return a.Id.String()
+}
+
+func (a *Attachment) ToPbAttachment() *pb.Attachment {
+	attachment := &pb.Attachment{
+		Id:         a.Id.String(),
+		RoomId:     a.RoomId.String(),
+		UploaderId: a.UploaderId.String(),
+		FileName:   a.FileName,
+		MimeType:   a.MimeType,
+		Size:       a.Size,
+		Sha256:     a.Sha256,
+		Width:      uint32(a.Width),
+		Height:     uint32(a.Height),
+		CreatedAt:  timestamppb.New(a.CreatedAt),
+	}
+
+	if a.MessageId.Valid {
+		attachment.MessageId = a.MessageId.UUID.String()
+	}
+
+	return attachment
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"crypto/sha256"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// PageDirection tells whether page continues towards older or newer items.
+type PageDirection uint8
+
+const (
+	PAGE_OLDER PageDirection = iota
+	PAGE_NEWER
+)
+
+// CursorFilterSize is length of filter hash cursor is bound to.
+const CursorFilterSize = 16
+
+// CursorFilter identifies listing cursor belongs to, so cursor issued for
+// one room or user is not accepted by another listing.
+type CursorFilter [CursorFilterSize]byte
+
+// NewCursorFilter hashes listing name and its parameters.
+func NewCursorFilter(parts ...string) CursorFilter {
+	var filter CursorFilter
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	copy(filter[:], sum[:])
+
+	return filter
+}
+
+// Cursor
+//
+// Position in listing: sort key and id of the item page starts after, and
+// direction page goes to. Sort key is message sequence number for messages,
+// last message time in microseconds for rooms and rank in millionths for
+// search results.
+type Cursor struct {
+	SortKey   int64
+	Id        uuid.UUID
+	Direction PageDirection
+	Filter    CursorFilter
+}
+
+func NewCursor(filter CursorFilter, sortKey int64, id uuid.UUID, direction PageDirection) *Cursor {
+	return &Cursor{
+		SortKey:   sortKey,
+		Id:        id,
+		Direction: direction,
+		Filter:    filter,
+	}
+}
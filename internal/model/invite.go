@@ -0,0 +1,76 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// inviteCodeBytes is amount of randomness in invite code, it is encoded to
+// 22 characters.
+const inviteCodeBytes = 16
+
+type Invite struct {
+	Code      string     `db:"code"`
+	RoomId    uuid.UUID  `db:"room_id"`
+	CreatedBy uuid.UUID  `db:"created_by"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	MaxUses   int        `db:"max_uses"`
+	Uses      int        `db:"uses"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+// NewInvite creates invite to room with random code. Zero [maxUses] allows
+// unlimited number of uses, nil [expiresAt] makes invite never expire.
+func NewInvite(roomId, createdBy uuid.UUID, maxUses int, expiresAt *time.Time) (*Invite, error) {
+	code := make([]byte, inviteCodeBytes)
+	if _, err := rand.Read(code); err != nil {
+		return nil, err
+	}
+
+	return &Invite{
+		Code:      base64.RawURLEncoding.EncodeToString(code),
+		RoomId:    roomId,
+		CreatedBy: createdBy,
+		CreatedAt: utils.Now(),
+		ExpiresAt: expiresAt,
+		MaxUses:   maxUses,
+	}, nil
+}
+
+// IsUsable reports whether invite is neither revoked, expired nor used up.
+func (i *Invite) IsUsable(now time.Time) bool {
+	if i.RevokedAt != nil {
+		return false
+	}
+
+	if i.ExpiresAt != nil && !now.Before(*i.ExpiresAt) {
+		return false
+	}
+
+	return i.MaxUses == 0 || i.Uses < i.MaxUses
+}
+
+func (i *Invite) ToPbInvite() *pb.Invite {
+	invite := &pb.Invite{
+		Code:      i.Code,
+		RoomId:    i.RoomId.String(),
+		CreatedBy: i.CreatedBy.String(),
+		CreatedAt: timestamppb.New(i.CreatedAt),
+		MaxUses:   int32(i.MaxUses),
+		Uses:      int32(i.Uses),
+		Revoked:   i.RevokedAt != nil,
+	}
+
+	if i.ExpiresAt != nil {
+		invite.ExpiresAt = timestamppb.New(*i.ExpiresAt)
+	}
+
+	return invite
+}
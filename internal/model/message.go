@@ -9,11 +9,28 @@ import (
 )
 
 type Message struct {
-	Id        uuid.UUID `db:"id"`
-	UserId    uuid.UUID `db:"user_id"`
-	RoomId    uuid.UUID `db:"room_id"`
-	Text      string    `db:"text"`
-	CreatedAt time.Time `db:"created_at"`
+	Id        uuid.UUID  `db:"id"`
+	UserId    uuid.UUID  `db:"user_id"`
+	RoomId    uuid.UUID  `db:"room_id"`
+	Text      string     `db:"text"`
+	CreatedAt time.Time  `db:"created_at"`
+	EditedAt  *time.Time `db:"edited_at"`
+	DeletedAt *time.Time `db:"deleted_at"`
+	System    bool       `db:"system"`
+
+	// Seq is position of message in its room assigned on insert, messages
+	// are ordered by it rather than by creation time
+	Seq int64 `db:"seq"`
+
+	ReplyToId    uuid.NullUUID `db:"reply_to_id"`
+	ThreadRootId uuid.NullUUID `db:"thread_root_id"`
+	ReplyCount   int           `db:"reply_count"`
+
+	// ClientMessageId is idempotency key sender has sent message with
+	ClientMessageId *string `db:"client_message_id"`
+
+	Reactions   []ReactionCount `db:"-"`
+	Attachments []Attachment    `db:"-"`
 }
 
 func NewMessage(userId, roomId uuid.UUID, text string) *Message {
@@ -26,12 +43,90 @@ func NewMessage(userId, roomId uuid.UUID, text string) *Message {
 	}
 }
 
+// NewSystemMessage creates message describing room event caused by [userId].
+func NewSystemMessage(userId, roomId uuid.UUID, text string) *Message {
+	message := NewMessage(userId, roomId, text)
+	message.System = true
+
+	return message
+}
+
+// ReplyTo makes message reply to [parent]. Replies to replies belong to
+// thread of parent, so threads are never nested.
+func (m *Message) ReplyTo(parent *Message) {
+	m.ReplyToId = uuid.NullUUID{UUID: parent.Id, Valid: true}
+	m.ThreadRootId = uuid.NullUUID{UUID: parent.ThreadRoot(), Valid: true}
+}
+
+// ThreadRoot returns id of the first message of thread message belongs to.
+func (m *Message) ThreadRoot() uuid.UUID {
+	if m.ThreadRootId.Valid {
+		return m.ThreadRootId.UUID
+	}
+
+	return m.Id
+}
+
+func (m *Message) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+// NewDelivery announces creation of message to [userIds].
+func (m *Message) NewDelivery(userIds []uuid.UUID) *pb.MessageDelivery {
+	delivery := &pb.MessageDelivery{
+		Message:   m.ToPbMessage(),
+		EventType: pb.EventType_MESSAGE_CREATED,
+	}
+	for _, userId := range userIds {
+		delivery.UserIds = append(delivery.UserIds, userId.String())
+	}
+
+	return delivery
+}
+
 func (m *Message) ToPbMessage() *pb.Message {
-	return &pb.Message{
+	message := &pb.Message{
 		Id:        m.Id.String(),
 		RoomId:    m.RoomId.String(),
 		UserId:    m.UserId.String(),
 		Text:      m.Text,
 		CreatedAt: timestamppb.New(m.CreatedAt),
+		System:    m.System,
+		Seq:       m.Seq,
+
+		ReplyCount: uint32(m.ReplyCount),
+	}
+
+	for _, attachment := range m.Attachments {
+		message.Attachments = append(message.Attachments, attachment.ToPbAttachment())
+	}
+
+	for _, reaction := range m.Reactions {
+		message.Reactions = append(message.Reactions, reaction.ToPbReactionCount())
+	}
+
+	if m.ClientMessageId != nil {
+		message.ClientMessageId = *m.ClientMessageId
 	}
+
+	if m.ReplyToId.Valid {
+		message.ReplyToId = m.ReplyToId.UUID.String()
+	}
+
+	if m.ThreadRootId.Valid {
+		message.ThreadRootId = m.ThreadRootId.UUID.String()
+	}
+
+	if m.EditedAt != nil {
+		message.EditedAt = timestamppb.New(*m.EditedAt)
+	}
+
+	if m.DeletedAt != nil {
+		message.Text = ""
+		message.Reactions = nil
+		message.Attachments = nil
+		message.DeletedAt = timestamppb.New(*m.DeletedAt)
+	}
+
+	return message
 }
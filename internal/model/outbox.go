@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// OutboxEvent is delivery stored together with the change it announces and
+// published to message broker afterwards.
+type OutboxEvent struct {
+	Id        int64     `db:"id"`
+	Delivery  []byte    `db:"delivery"`
+	CreatedAt time.Time `db:"created_at"`
+	Attempts  int       `db:"attempts"`
+}
+
+func (e *OutboxEvent) PbDelivery() (*pb.MessageDelivery, error) {
+	delivery := new(pb.MessageDelivery)
+	err := proto.Unmarshal(e.Delivery, delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
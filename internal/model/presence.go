@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Presence struct {
+	UserId       uuid.UUID  `db:"user_id"`
+	LastSeenAt   *time.Time `db:"last_seen_at"`
+	HideLastSeen bool       `db:"hide_last_seen"`
+}
+
+// ToPbPresence returns presence as seen by [viewerId]. Last seen time is
+// shown only for offline users who have not hidden it, users always see
+// their own one.
+func (p *Presence) ToPbPresence(online bool, viewerId uuid.UUID) *pb.Presence {
+	presence := &pb.Presence{
+		UserId: p.UserId.String(),
+		Online: online,
+	}
+
+	if !online && p.LastSeenAt != nil && (!p.HideLastSeen || viewerId == p.UserId) {
+		presence.LastSeenAt = timestamppb.New(*p.LastSeenAt)
+	}
+
+	return presence
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+)
+
+// MaxEmojiLength is length of message_reactions.emoji column. It fits emoji
+// made of several code points, such as flags and skin tones.
+const MaxEmojiLength = 16
+
+type Reaction struct {
+	MessageId uuid.UUID `db:"message_id"`
+	RoomId    uuid.UUID `db:"-"`
+	UserId    uuid.UUID `db:"user_id"`
+	Emoji     string    `db:"emoji"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func NewReaction(userId uuid.UUID, message *Message, emoji string) *Reaction {
+	return &Reaction{
+		MessageId: message.Id,
+		RoomId:    message.RoomId,
+		UserId:    userId,
+		Emoji:     emoji,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (r *Reaction) ToPbReaction() *pb.Reaction {
+	return &pb.Reaction{
+		MessageId: r.MessageId.String(),
+		RoomId:    r.RoomId.String(),
+		UserId:    r.UserId.String(),
+		Emoji:     r.Emoji,
+	}
+}
+
+// ReactionCount is number of users who reacted to message with emoji.
+type ReactionCount struct {
+	MessageId uuid.UUID `db:"message_id"`
+	Emoji     string    `db:"emoji"`
+	Count     int       `db:"count"`
+	Reacted   bool      `db:"reacted"`
+}
+
+func (c *ReactionCount) ToPbReactionCount() *pb.ReactionCount {
+	return &pb.ReactionCount{
+		Emoji:   c.Emoji,
+		Count:   uint32(c.Count),
+		Reacted: c.Reacted,
+	}
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"time"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ReadMarker points to the last message user has read in room. Messages are
+// ordered by sequence number, so everything up to the marked message counts
+// as read.
+type ReadMarker struct {
+	RoomId     uuid.UUID `db:"room_id"`
+	UserId     uuid.UUID `db:"user_id"`
+	MessageId  uuid.UUID `db:"message_id"`
+	MessageSeq int64     `db:"message_seq"`
+	ReadAt     time.Time `db:"read_at"`
+}
+
+func NewReadMarker(userId uuid.UUID, message *Message) *ReadMarker {
+	return &ReadMarker{
+		RoomId:     message.RoomId,
+		UserId:     userId,
+		MessageId:  message.Id,
+		MessageSeq: message.Seq,
+		ReadAt:     utils.Now(),
+	}
+}
+
+func (m *ReadMarker) ToPbReadReceipt() *pb.ReadReceipt {
+	return &pb.ReadReceipt{
+		RoomId:     m.RoomId.String(),
+		UserId:     m.UserId.String(),
+		MessageId:  m.MessageId.String(),
+		ReadAt:     timestamppb.New(m.ReadAt),
+		MessageSeq: m.MessageSeq,
+	}
+}
@@ -17,6 +17,13 @@ type Room struct {
 	UserIds         []uuid.UUID `db:"-"`
 	DialogRoom      bool        `db:"dialog_room"`
 	LastMessageTime time.Time   `db:"last_message_time"`
+
+	// Creator of group room, dialog rooms have no owner
+	OwnerId uuid.UUID `db:"-"`
+
+	// Read state of user rooms are listed for
+	UnreadCount       int           `db:"unread_count"`
+	LastReadMessageId uuid.NullUUID `db:"last_read_message_id"`
 }
 
 func NewRoom(name string, dialogRoom bool, users ...uuid.UUID) *Room {
@@ -31,11 +38,18 @@ func NewRoom(name string, dialogRoom bool, users ...uuid.UUID) *Room {
 }
 
 func (r *Room) PbRoom() *pb.Room {
-	return &pb.Room{
+	room := &pb.Room{
 		Id:              r.Id.String(),
 		Name:            r.Name,
 		CreatedAt:       timestamppb.New(r.CreatedAt),
 		DialogRoom:      r.DialogRoom,
 		LastMessageTime: timestamppb.New(r.LastMessageTime),
+		UnreadCount:     uint32(r.UnreadCount),
 	}
+
+	if r.LastReadMessageId.Valid {
+		room.LastReadMessageId = r.LastReadMessageId.UUID.String()
+	}
+
+	return room
 }
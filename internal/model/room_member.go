@@ -0,0 +1,49 @@
+package model
+
+import (
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+)
+
+const (
+	ROOM_OWNER_ROLE     = "owner"
+	ROOM_MODERATOR_ROLE = "moderator"
+	ROOM_MEMBER_ROLE    = "member"
+)
+
+type RoomMember struct {
+	RoomId uuid.UUID `db:"room_id"`
+	UserId uuid.UUID `db:"user_id"`
+	Role   string    `db:"role"`
+}
+
+// roomRoleRanks orders room roles, role with higher rank has every
+// permission of roles below it.
+var roomRoleRanks = map[string]int{
+	ROOM_MEMBER_ROLE:    1,
+	ROOM_MODERATOR_ROLE: 2,
+	ROOM_OWNER_ROLE:     3,
+}
+
+func IsRoomRole(role string) bool {
+	_, ok := roomRoleRanks[role]
+	return ok
+}
+
+// CanModerate reports whether member may rename room and moderate other
+// members and their messages.
+func (m *RoomMember) CanModerate() bool {
+	return roomRoleRanks[m.Role] >= roomRoleRanks[ROOM_MODERATOR_ROLE]
+}
+
+// Outranks reports whether member has higher role than [other].
+func (m *RoomMember) Outranks(other *RoomMember) bool {
+	return roomRoleRanks[m.Role] > roomRoleRanks[other.Role]
+}
+
+func (m *RoomMember) ToPbRoomMember() *pb.RoomMember {
+	return &pb.RoomMember{
+		UserId: m.UserId.String(),
+		Role:   m.Role,
+	}
+}
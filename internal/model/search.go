@@ -0,0 +1,83 @@
+package model
+
+import (
+	"math"
+	"time"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+)
+
+// MessageSearch is full-text search in rooms user belongs to, optionally
+// narrowed down by filters.
+type MessageSearch struct {
+	UserId   uuid.UUID
+	Query    string
+	RoomId   uuid.NullUUID
+	SenderId uuid.NullUUID
+	From     *time.Time
+	To       *time.Time
+	After    *SearchCursor
+	PageSize int
+}
+
+// searchRankScale is inverse of precision rank is rounded to by database,
+// so rank kept in cursor sort key compares equal to rank computed again.
+const searchRankScale = 1e6
+
+// SearchCursor is position of last result of previous page. Results of the
+// same rank are ordered by time message was sent, which is looked up by id.
+type SearchCursor struct {
+	Rank      float64
+	MessageId uuid.UUID
+}
+
+// NewSearchCursor reads search position from [cursor] issued for search
+// results.
+func NewSearchCursor(cursor *Cursor) *SearchCursor {
+	return &SearchCursor{
+		Rank:      float64(cursor.SortKey) / searchRankScale,
+		MessageId: cursor.Id,
+	}
+}
+
+// CursorFilter identifies search by its query and filters, so page token
+// of one search is not accepted by another.
+func (s *MessageSearch) CursorFilter() CursorFilter {
+	parts := []string{"search", s.UserId.String(), s.Query, "", "", "", ""}
+	if s.RoomId.Valid {
+		parts[3] = s.RoomId.UUID.String()
+	}
+	if s.SenderId.Valid {
+		parts[4] = s.SenderId.UUID.String()
+	}
+	if s.From != nil {
+		parts[5] = s.From.Format(time.RFC3339Nano)
+	}
+	if s.To != nil {
+		parts[6] = s.To.Format(time.RFC3339Nano)
+	}
+
+	return NewCursorFilter(parts...)
+}
+
+type SearchResult struct {
+	Message
+	// Snippet is HTML-escaped fragments of text with matches wrapped in
+	// <mark></mark>, so it can be rendered as HTML as is.
+	Snippet string  `db:"snippet"`
+	Rank    float64 `db:"rank"`
+}
+
+// Cursor is position of next page of search bound to [filter].
+func (r *SearchResult) Cursor(filter CursorFilter) *Cursor {
+	return NewCursor(filter, int64(math.Round(r.Rank*searchRankScale)), r.Id, PAGE_OLDER)
+}
+
+func (r *SearchResult) ToPbSearchResult() *pb.SearchResult {
+	return &pb.SearchResult{
+		Message: r.ToPbMessage(),
+		Snippet: r.Snippet,
+		Rank:    float32(r.Rank),
+	}
+}
@@ -7,9 +7,26 @@ import (
 	"github.com/google/uuid"
 )
 
+// Session
+//
+// Single GetMessages stream. User may have several sessions at once, one per
+// connected device, so sessions are identified by [Id] within [UserId].
 type Session struct {
 	Id         uuid.UUID
+	UserId     uuid.UUID
+	DeviceId   string
 	Connection pb.MessageService_GetMessagesServer
 	Expires    time.Duration
 	Done       chan<- error
 }
+
+func NewSession(userId uuid.UUID, deviceId string, connection pb.MessageService_GetMessagesServer, expires time.Duration, done chan<- error) *Session {
+	return &Session{
+		Id:         uuid.New(),
+		UserId:     userId,
+		DeviceId:   deviceId,
+		Connection: connection,
+		Expires:    expires,
+		Done:       done,
+	}
+}
@@ -13,10 +13,12 @@ type TokenPair struct {
 }
 
 type RefreshToken struct {
-	Token     uuid.UUID `db:"token"`
-	UserId    uuid.UUID `db:"user_id"`
-	ExpiresAt time.Time `db:"expires_at"`
-	IssuedAt  time.Time `db:"issued_at"`
+	Token     uuid.UUID  `db:"token"`
+	UserId    uuid.UUID  `db:"user_id"`
+	FamilyId  uuid.UUID  `db:"family_id"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	IssuedAt  time.Time  `db:"issued_at"`
+	UsedAt    *time.Time `db:"used_at"`
 }
 
 type UserClaims struct {
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const attachmentColumns = "id, room_id, uploader_id, message_id, file_name, mime_type, size, sha256, width, height, created_at"
+
+type AttachmentStore interface {
+	Add(ctx context.Context, attachment *model.Attachment) error
+	Get(ctx context.Context, id uuid.UUID) (*model.Attachment, error)
+	GetMany(ctx context.Context, ids ...uuid.UUID) ([]model.Attachment, error)
+	ListByMessages(ctx context.Context, messageIds ...uuid.UUID) ([]model.Attachment, error)
+	CountUnsent(ctx context.Context, uploaderId uuid.UUID) (int, error)
+	DeleteUnsent(ctx context.Context, createdBefore time.Time) (int64, error)
+	ListDeletedBlobs(ctx context.Context, limit int) ([]string, error)
+	ForgetDeletedBlobs(ctx context.Context, keys ...string) error
+}
+
+type PostgresAttachmentStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresAttachmentStore(db *sqlx.DB) *PostgresAttachmentStore {
+	return &PostgresAttachmentStore{
+		db: db,
+	}
+}
+
+func (s *PostgresAttachmentStore) Add(ctx context.Context, attachment *model.Attachment) error {
+	_, err := s.db.NamedExecContext(ctx, "INSERT INTO attachments("+attachmentColumns+") VALUES(:id, :room_id, :uploader_id, :message_id, :file_name, :mime_type, :size, :sha256, :width, :height, :created_at)",
+		attachment)
+
+	return err
+}
+
+func (s *PostgresAttachmentStore) Get(ctx context.Context, id uuid.UUID) (*model.Attachment, error) {
+	attachment := new(model.Attachment)
+	err := s.db.GetContext(ctx, attachment, "SELECT "+attachmentColumns+" FROM attachments WHERE id=$1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+func (s *PostgresAttachmentStore) GetMany(ctx context.Context, ids ...uuid.UUID) ([]model.Attachment, error) {
+	return s.list(ctx, sq.Eq{"id": ids})
+}
+
+// ListByMessages lists attachments of messages in order they were uploaded.
+func (s *PostgresAttachmentStore) ListByMessages(ctx context.Context, messageIds ...uuid.UUID) ([]model.Attachment, error) {
+	return s.list(ctx, sq.Eq{"message_id": messageIds})
+}
+
+// CountUnsent counts attachments [uploaderId] has uploaded but not sent yet.
+func (s *PostgresAttachmentStore) CountUnsent(ctx context.Context, uploaderId uuid.UUID) (int, error) {
+	var count int
+	err := s.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM attachments WHERE uploader_id=$1 AND message_id IS NULL",
+		uploaderId)
+
+	return count, err
+}
+
+// DeleteUnsent
+//
+// Deletes attachments uploaded before [createdBefore] which were never sent.
+// Blobs of deleted attachments, as well as of attachments deleted together
+// with their message, room or uploader, are queued to be deleted and listed
+// by ListDeletedBlobs.
+func (s *PostgresAttachmentStore) DeleteUnsent(ctx context.Context, createdBefore time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM attachments WHERE message_id IS NULL AND created_at<$1",
+		createdBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// ListDeletedBlobs lists keys of blobs whose attachments are deleted, oldest
+// first.
+func (s *PostgresAttachmentStore) ListDeletedBlobs(ctx context.Context, limit int) ([]string, error) {
+	keys := []string{}
+	err := s.db.SelectContext(ctx, &keys, "SELECT blob_key FROM deleted_attachment_blobs ORDER BY deleted_at LIMIT $1",
+		limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ForgetDeletedBlobs removes keys of blobs which are deleted from blob store.
+func (s *PostgresAttachmentStore) ForgetDeletedBlobs(ctx context.Context, keys ...string) error {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.
+		Delete("deleted_attachment_blobs").
+		Where(sq.Eq{"blob_key": keys}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, sql, args...)
+
+	return err
+}
+
+func (s *PostgresAttachmentStore) list(ctx context.Context, where sq.Sqlizer) ([]model.Attachment, error) {
+	attachments := []model.Attachment{}
+
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.
+		Select(attachmentColumns).
+		From("attachments").
+		Where(where).
+		OrderBy("created_at ASC").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.SelectContext(ctx, &attachments, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalBlobStore_PutAndGet(t *testing.T) {
+	store, err := NewLocalBlobStore(t.TempDir())
+	assert.Nil(t, err)
+
+	ctx := context.TODO()
+	key := uuid.New().String()
+
+	size, err := store.Put(ctx, key, strings.NewReader("content"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), size)
+
+	blob, err := store.Get(ctx, key)
+	assert.Nil(t, err)
+	defer blob.Close()
+
+	content, err := io.ReadAll(blob)
+	assert.Nil(t, err)
+	assert.Equal(t, "content", string(content))
+}
+
+func TestLocalBlobStore_PutFailureLeavesNoBlob(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewLocalBlobStore(root)
+	assert.Nil(t, err)
+
+	ctx := context.TODO()
+	key := uuid.New().String()
+
+	_, err = store.Put(ctx, key, io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = store.Get(ctx, key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+
+	// Temporary file is removed as well
+	files, err := os.ReadDir(root + "/" + key[:2])
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+}
+
+func TestLocalBlobStore_Delete(t *testing.T) {
+	store, err := NewLocalBlobStore(t.TempDir())
+	assert.Nil(t, err)
+
+	ctx := context.TODO()
+	key := uuid.New().String()
+
+	_, err = store.Put(ctx, key, strings.NewReader("content"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.Delete(ctx, key))
+	// Deleting missing blob is no-op
+	assert.Nil(t, store.Delete(ctx, key))
+
+	_, err = store.Get(ctx, key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestLocalBlobStore_RejectsKeysEscapingRoot(t *testing.T) {
+	store, err := NewLocalBlobStore(t.TempDir())
+	assert.Nil(t, err)
+
+	_, err = store.Put(context.TODO(), "../../etc/passwd", strings.NewReader("content"))
+	assert.NotNil(t, err)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInviteUnusable is returned when invite is revoked, expired or used up.
+var ErrInviteUnusable = errors.New("invite is revoked, expired or used up")
+
+const inviteColumns = "code, room_id, created_by, created_at, expires_at, max_uses, uses, revoked_at"
+
+type InviteStore interface {
+	Add(ctx context.Context, invite *model.Invite) error
+	Get(ctx context.Context, code string) (*model.Invite, error)
+	ListByRoom(ctx context.Context, roomId uuid.UUID) ([]model.Invite, error)
+	Revoke(ctx context.Context, code string, revokedAt time.Time) error
+	Join(ctx context.Context, code string, userId uuid.UUID, message *model.Message) (bool, error)
+}
+
+type PostgresInviteStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresInviteStore(db *sqlx.DB) *PostgresInviteStore {
+	return &PostgresInviteStore{
+		db: db,
+	}
+}
+
+func (s *PostgresInviteStore) Add(ctx context.Context, invite *model.Invite) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO room_invites(code, room_id, created_by, created_at, expires_at, max_uses) VALUES($1, $2, $3, $4, $5, $6)",
+		invite.Code, invite.RoomId, invite.CreatedBy, invite.CreatedAt, invite.ExpiresAt, invite.MaxUses)
+
+	return err
+}
+
+func (s *PostgresInviteStore) Get(ctx context.Context, code string) (*model.Invite, error) {
+	invite := new(model.Invite)
+	err := s.db.GetContext(ctx, invite, "SELECT "+inviteColumns+" FROM room_invites WHERE code=$1", code)
+	if err != nil {
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+func (s *PostgresInviteStore) ListByRoom(ctx context.Context, roomId uuid.UUID) ([]model.Invite, error) {
+	invites := []model.Invite{}
+	err := s.db.SelectContext(ctx, &invites, "SELECT "+inviteColumns+" FROM room_invites WHERE room_id=$1 ORDER BY created_at DESC", roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+func (s *PostgresInviteStore) Revoke(ctx context.Context, code string, revokedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE room_invites SET revoked_at=$2 WHERE code=$1 AND revoked_at IS NULL",
+		code, revokedAt)
+
+	return err
+}
+
+// Join
+//
+// Adds [userId] to room of invite as member and records system [message]
+// about it. Use of invite is counted in the same transaction: check and
+// increment happen in single statement, so concurrent joins cannot exceed max
+// uses, and use is given back if user turns out to be a member already.
+// Reports whether user was added.
+func (s *PostgresInviteStore) Join(ctx context.Context, code string, userId uuid.UUID, message *model.Message) (bool, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var roomId uuid.UUID
+	err = tx.GetContext(
+		ctx,
+		&roomId,
+		`
+		UPDATE room_invites SET uses=uses+1
+		WHERE code=$1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at>$2) AND (max_uses=0 OR uses<max_uses)
+		RETURNING room_id`,
+		code, message.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrInviteUnusable
+	}
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO user_in_room(room_id, user_id) VALUES($1, $2) ON CONFLICT DO NOTHING",
+		roomId, userId)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	message.RoomId = roomId
+	err = insertSystemMessage(ctx, tx, message)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
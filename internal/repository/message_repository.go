@@ -2,6 +2,11 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ArtyomArtamonov/msg/internal/model"
@@ -10,10 +15,40 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+// ErrDuplicateMessage is returned when sender has sent message with the same
+// client message id already.
+var ErrDuplicateMessage = errors.New("message with this client message id is sent already")
+
+// ErrAttachmentUnavailable is returned when attachment is sent with another
+// message already.
+var ErrAttachmentUnavailable = errors.New("attachment is sent with another message")
+
+// ErrMessageDeleted is returned when message is edited after it is deleted.
+var ErrMessageDeleted = errors.New("message is deleted")
+
+const messageColumns = "messages.id, messages.room_id, messages.user_id, messages.text, messages.created_at, messages.edited_at, messages.deleted_at, messages.system, messages.seq, messages.reply_to_id, messages.thread_root_id, messages.client_message_id"
+
+// replyCountColumn counts replies in thread started by message, replies
+// deleted for everyone are not counted.
+const replyCountColumn = "(SELECT COUNT(*) FROM messages replies WHERE replies.thread_root_id=messages.id AND replies.deleted_at IS NULL) AS reply_count"
+
 type MessageStore interface {
-	SendMessage(ctx context.Context, message *model.Message) error
-	ListMessages(ctx context.Context, id uuid.UUID, createdAt time.Time, pageSize int) ([]model.Message, error)
-	ListMessagesFirst(ctx context.Context, id uuid.UUID, pageSize int) ([]model.Message, error)
+	SendMessage(ctx context.Context, message *model.Message, recipientIds []uuid.UUID) error
+	GetMessage(ctx context.Context, id uuid.UUID) (*model.Message, error)
+	GetMessageByClientId(ctx context.Context, userId uuid.UUID, clientMessageId string) (*model.Message, error)
+	EditMessage(ctx context.Context, id uuid.UUID, text string, editedAt time.Time) error
+	DeleteMessage(ctx context.Context, id uuid.UUID, deletedAt time.Time) error
+	HideMessage(ctx context.Context, id, userId uuid.UUID) error
+	ListMessages(ctx context.Context, id, userId uuid.UUID, beforeSeq int64, pageSize int) ([]model.Message, error)
+	ListMessagesNewer(ctx context.Context, id, userId uuid.UUID, afterSeq int64, pageSize int) ([]model.Message, error)
+	ListMessagesFirst(ctx context.Context, id, userId uuid.UUID, pageSize int) ([]model.Message, error)
+	ListMessagesSince(ctx context.Context, userId uuid.UUID, since time.Time, roomSeqs map[uuid.UUID]int64, limit int) ([]model.Message, error)
+	ListThread(ctx context.Context, rootId, userId uuid.UUID, beforeSeq int64, pageSize int) ([]model.Message, error)
+	ListThreadFirst(ctx context.Context, rootId, userId uuid.UUID, pageSize int) ([]model.Message, error)
+	AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error)
+	RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error)
+	ReactionCounts(ctx context.Context, userId uuid.UUID, messageIds ...uuid.UUID) ([]model.ReactionCount, error)
+	SearchMessages(ctx context.Context, search *model.MessageSearch) ([]model.SearchResult, error)
 }
 
 type PostgresMessageStore struct {
@@ -26,16 +61,24 @@ func NewPostgresMessageStore(db *sqlx.DB) *PostgresMessageStore {
 	}
 }
 
-func (s *PostgresMessageStore) ListMessages(ctx context.Context, chatId uuid.UUID, createdAt time.Time, pageSize int) ([]model.Message, error) {
+// notHiddenFor filters out messages user has deleted for themselves.
+func notHiddenFor(userId uuid.UUID) sq.Sqlizer {
+	return sq.Expr("NOT EXISTS (SELECT 1 FROM hidden_messages WHERE hidden_messages.message_id=messages.id AND hidden_messages.user_id=?)", userId)
+}
+
+// ListMessages lists messages of room preceding message [beforeSeq], newest
+// first.
+func (s *PostgresMessageStore) ListMessages(ctx context.Context, chatId, userId uuid.UUID, beforeSeq int64, pageSize int) ([]model.Message, error) {
 	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	sql, _, err := psql.
-		Select("id, room_id, user_id, text, created_at").
+	sql, args, err := psql.
+		Select(messageColumns, replyCountColumn).
 		From("messages").
 		Where(sq.And{
 			sq.Eq{"room_id": chatId},
-			sq.Lt{"created_at": createdAt},
+			sq.Lt{"seq": beforeSeq},
+			notHiddenFor(userId),
 		}).
-		OrderBy("created_at DESC").
+		OrderBy("seq DESC").
 		Limit(uint64(pageSize)).
 		ToSql()
 	if err != nil {
@@ -43,7 +86,7 @@ func (s *PostgresMessageStore) ListMessages(ctx context.Context, chatId uuid.UUI
 	}
 
 	messages := []model.Message{}
-	err = s.db.SelectContext(ctx, &messages, sql, chatId, createdAt)
+	err = s.db.SelectContext(ctx, &messages, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -51,13 +94,19 @@ func (s *PostgresMessageStore) ListMessages(ctx context.Context, chatId uuid.UUI
 	return messages, err
 }
 
-func (s *PostgresMessageStore) ListMessagesFirst(ctx context.Context, chatId uuid.UUID, pageSize int) ([]model.Message, error) {
+// ListMessagesNewer lists messages of room following message [afterSeq],
+// nearest to it first.
+func (s *PostgresMessageStore) ListMessagesNewer(ctx context.Context, chatId, userId uuid.UUID, afterSeq int64, pageSize int) ([]model.Message, error) {
 	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	sql, _, err := psql.
-		Select("id, room_id, user_id, text, created_at").
+	sql, args, err := psql.
+		Select(messageColumns, replyCountColumn).
 		From("messages").
-		Where(sq.Eq{"room_id": chatId}).
-		OrderBy("created_at DESC").
+		Where(sq.And{
+			sq.Eq{"room_id": chatId},
+			sq.Gt{"seq": afterSeq},
+			notHiddenFor(userId),
+		}).
+		OrderBy("seq ASC").
 		Limit(uint64(pageSize)).
 		ToSql()
 	if err != nil {
@@ -65,7 +114,32 @@ func (s *PostgresMessageStore) ListMessagesFirst(ctx context.Context, chatId uui
 	}
 
 	messages := []model.Message{}
-	err = s.db.SelectContext(ctx, &messages, sql, chatId)
+	err = s.db.SelectContext(ctx, &messages, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (s *PostgresMessageStore) ListMessagesFirst(ctx context.Context, chatId, userId uuid.UUID, pageSize int) ([]model.Message, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.
+		Select(messageColumns, replyCountColumn).
+		From("messages").
+		Where(sq.And{
+			sq.Eq{"room_id": chatId},
+			notHiddenFor(userId),
+		}).
+		OrderBy("seq DESC").
+		Limit(uint64(pageSize)).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []model.Message{}
+	err = s.db.SelectContext(ctx, &messages, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -73,11 +147,384 @@ func (s *PostgresMessageStore) ListMessagesFirst(ctx context.Context, chatId uui
 	return messages, err
 }
 
-func (s *PostgresMessageStore) SendMessage(ctx context.Context, message *model.Message) error {
+// ListMessagesSince
+//
+// Lists messages sent by others to every room user belongs to. Messages of
+// rooms in [roomSeqs] are listed after the sequence number given for room,
+// messages of other rooms starting from [since] inclusive. Messages are
+// grouped by room, oldest first. Messages user has hidden are not listed,
+// neither are ones deleted, which were sent and deleted while user was away.
+func (s *PostgresMessageStore) ListMessagesSince(ctx context.Context, userId uuid.UUID, since time.Time, roomSeqs map[uuid.UUID]int64, limit int) ([]model.Message, error) {
+	resumed := sq.Or{}
+	roomIds := []uuid.UUID{}
+	for roomId, seq := range roomSeqs {
+		resumed = append(resumed, sq.And{
+			sq.Eq{"messages.room_id": roomId},
+			sq.Gt{"messages.seq": seq},
+		})
+		roomIds = append(roomIds, roomId)
+	}
+	resumed = append(resumed, sq.And{
+		sq.NotEq{"messages.room_id": roomIds},
+		sq.GtOrEq{"messages.created_at": since},
+	})
+
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.
+		Select(messageColumns).
+		From("messages").
+		InnerJoin("user_in_room ON messages.room_id=user_in_room.room_id").
+		Where(sq.And{
+			sq.Eq{"user_in_room.user_id": userId},
+			sq.NotEq{"messages.user_id": userId},
+			sq.Eq{"messages.deleted_at": nil},
+			notHiddenFor(userId),
+			resumed,
+		}).
+		OrderBy("messages.room_id ASC", "messages.seq ASC").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []model.Message{}
+	err = s.db.SelectContext(ctx, &messages, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// ListThread lists replies in thread preceding message [beforeSeq], newest
+// first.
+func (s *PostgresMessageStore) ListThread(ctx context.Context, rootId, userId uuid.UUID, beforeSeq int64, pageSize int) ([]model.Message, error) {
+	return s.listThread(ctx, sq.And{
+		sq.Eq{"thread_root_id": rootId},
+		sq.Lt{"seq": beforeSeq},
+		notHiddenFor(userId),
+	}, pageSize)
+}
+
+func (s *PostgresMessageStore) ListThreadFirst(ctx context.Context, rootId, userId uuid.UUID, pageSize int) ([]model.Message, error) {
+	return s.listThread(ctx, sq.And{
+		sq.Eq{"thread_root_id": rootId},
+		notHiddenFor(userId),
+	}, pageSize)
+}
+
+func (s *PostgresMessageStore) listThread(ctx context.Context, where sq.Sqlizer, pageSize int) ([]model.Message, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.
+		Select(messageColumns).
+		From("messages").
+		Where(where).
+		OrderBy("seq DESC").
+		Limit(uint64(pageSize)).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []model.Message{}
+	err = s.db.SelectContext(ctx, &messages, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// SendMessage
+//
+// Adds message and links its attachments to it. Attachments which are
+// linked to other message already fail whole message with
+// ErrAttachmentUnavailable, message with client message id sender has used
+// already fails with ErrDuplicateMessage. Delivery of message to
+// [recipientIds] is put to outbox in the same transaction.
+func (s *PostgresMessageStore) SendMessage(ctx context.Context, message *model.Message, recipientIds []uuid.UUID) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	message.Seq, err = nextMessageSeq(ctx, tx, message)
+	if err != nil {
+		return err
+	}
+
 	var messageId uuid.UUID
-	err := s.db.GetContext(ctx, &messageId, "INSERT INTO messages(id, room_id, user_id, text, created_at) VALUES($1, $2, $3, $4, $5) RETURNING id",
-		message.Id, message.RoomId, message.UserId, message.Text, message.CreatedAt)
+	err = tx.GetContext(
+		ctx,
+		&messageId,
+		`
+		INSERT INTO messages(id, room_id, user_id, text, created_at, seq, reply_to_id, thread_root_id, client_message_id)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (user_id, client_message_id) WHERE client_message_id IS NOT NULL DO NOTHING
+		RETURNING id`,
+		message.Id, message.RoomId, message.UserId, message.Text, message.CreatedAt, message.Seq, message.ReplyToId, message.ThreadRootId, message.ClientMessageId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDuplicateMessage
+	}
+	if err != nil {
+		return err
+	}
 	message.Id = messageId
 
+	for i := range message.Attachments {
+		attachment := &message.Attachments[i]
+		result, err := tx.ExecContext(ctx, "UPDATE attachments SET message_id=$2 WHERE id=$1 AND message_id IS NULL",
+			attachment.Id, message.Id)
+		if err != nil {
+			return err
+		}
+
+		linked, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if linked == 0 {
+			return ErrAttachmentUnavailable
+		}
+
+		attachment.MessageId = uuid.NullUUID{UUID: message.Id, Valid: true}
+	}
+
+	err = addToOutbox(ctx, tx, message.NewDelivery(recipientIds), message.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// nextMessageSeq
+//
+// Takes next sequence number of message room. Room row stays locked until
+// transaction ends, so concurrent messages of room are numbered one after
+// another, and number of rolled back message is taken again by the next one.
+func nextMessageSeq(ctx context.Context, tx *sqlx.Tx, message *model.Message) (int64, error) {
+	var seq int64
+	err := tx.GetContext(ctx, &seq, "UPDATE rooms SET last_seq=last_seq+1, last_message_time=GREATEST(last_message_time, $2) WHERE id=$1 RETURNING last_seq",
+		message.RoomId, message.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	return seq, nil
+}
+
+func (s *PostgresMessageStore) GetMessage(ctx context.Context, id uuid.UUID) (*model.Message, error) {
+	message := new(model.Message)
+	err := s.db.GetContext(ctx, message, "SELECT "+messageColumns+", "+replyCountColumn+" FROM messages WHERE id=$1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (s *PostgresMessageStore) GetMessageByClientId(ctx context.Context, userId uuid.UUID, clientMessageId string) (*model.Message, error) {
+	message := new(model.Message)
+	err := s.db.GetContext(ctx, message, "SELECT "+messageColumns+" FROM messages WHERE user_id=$1 AND client_message_id=$2", userId, clientMessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// EditMessage replaces text of message. Returns [ErrMessageDeleted] if
+// message is deleted, so edit racing with deletion does not bring text back.
+func (s *PostgresMessageStore) EditMessage(ctx context.Context, id uuid.UUID, text string, editedAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE messages SET text=$2, edited_at=$3 WHERE id=$1 AND deleted_at IS NULL",
+		id, text, editedAt)
+	if err != nil {
+		return err
+	}
+
+	edited, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if edited == 0 {
+		return ErrMessageDeleted
+	}
+
+	return nil
+}
+
+// DeleteMessage deletes message for everyone. Row is kept as a tombstone so
+// clients can tell deleted message apart from one they have never seen.
+// Attachments of message are deleted, their blobs are queued for deletion.
+func (s *PostgresMessageStore) DeleteMessage(ctx context.Context, id uuid.UUID, deletedAt time.Time) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE messages SET text='', deleted_at=$2 WHERE id=$1 AND deleted_at IS NULL",
+		id, deletedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM attachments WHERE message_id=$1", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresMessageStore) HideMessage(ctx context.Context, id, userId uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO hidden_messages(message_id, user_id) VALUES($1, $2) ON CONFLICT DO NOTHING",
+		id, userId)
+
 	return err
 }
+
+// AddReaction reports whether reaction was added, user reacting with the
+// same emoji twice is no-op.
+func (s *PostgresMessageStore) AddReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "INSERT INTO message_reactions(message_id, user_id, emoji, created_at) VALUES($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+		reaction.MessageId, reaction.UserId, reaction.Emoji, reaction.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	added, err := result.RowsAffected()
+
+	return added > 0, err
+}
+
+// RemoveReaction reports whether reaction existed.
+func (s *PostgresMessageStore) RemoveReaction(ctx context.Context, reaction *model.Reaction) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM message_reactions WHERE message_id=$1 AND user_id=$2 AND emoji=$3",
+		reaction.MessageId, reaction.UserId, reaction.Emoji)
+	if err != nil {
+		return false, err
+	}
+
+	removed, err := result.RowsAffected()
+
+	return removed > 0, err
+}
+
+// ReactionCounts
+//
+// Counts reactions to messages grouped by emoji, in order emoji were first
+// used. [userId] is user whose own reactions are marked.
+func (s *PostgresMessageStore) ReactionCounts(ctx context.Context, userId uuid.UUID, messageIds ...uuid.UUID) ([]model.ReactionCount, error) {
+	counts := []model.ReactionCount{}
+	if len(messageIds) == 0 {
+		return counts, nil
+	}
+
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.
+		Select("message_id", "emoji", "COUNT(*) AS count").
+		Column(sq.Expr("BOOL_OR(user_id=?) AS reacted", userId)).
+		From("message_reactions").
+		Where(sq.Eq{"message_id": messageIds}).
+		GroupBy("message_id", "emoji").
+		OrderBy("MIN(created_at)").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.SelectContext(ctx, &counts, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+const (
+	// searchRank is relevance of message, rounded so that it can be compared
+	// exactly with rank from page token.
+	searchRank = "ROUND(ts_rank_cd(messages.search_vector, search_query)::numeric, 6)"
+	// searchHeadlineOptions configure snippets of search results. Matches
+	// are wrapped in control characters, which are removed from text, so
+	// snippet can be escaped before matches are marked.
+	searchHeadlineOptions = "StartSel=\"\x02\", StopSel=\"\x03\", MaxWords=20, MinWords=5, MaxFragments=2, FragmentDelimiter=\" … \""
+	// searchHeadline is snippet of message matching search query.
+	searchHeadline = "ts_headline('simple', translate(messages.text, E'\\x02\\x03', ''), search_query, ?) AS snippet"
+)
+
+var snippetMarker = strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+
+// markSnippet escapes [snippet] for HTML and wraps matches in <mark></mark>.
+func markSnippet(snippet string) string {
+	return snippetMarker.Replace(html.EscapeString(snippet))
+}
+
+// SearchMessages
+//
+// Searches text of messages in rooms user belongs to. System messages and
+// messages user has hidden are not searched, deleted ones have no text.
+// Results are ordered by rank, newer first among equally ranked.
+func (s *PostgresMessageStore) SearchMessages(ctx context.Context, search *model.MessageSearch) ([]model.SearchResult, error) {
+	where := sq.And{
+		sq.Expr("messages.search_vector @@ search_query"),
+		sq.Eq{"messages.system": false},
+		notHiddenFor(search.UserId),
+	}
+
+	if search.RoomId.Valid {
+		where = append(where, sq.Eq{"messages.room_id": search.RoomId.UUID})
+	}
+
+	if search.SenderId.Valid {
+		where = append(where, sq.Eq{"messages.user_id": search.SenderId.UUID})
+	}
+
+	if search.From != nil {
+		where = append(where, sq.GtOrEq{"messages.created_at": *search.From})
+	}
+
+	if search.To != nil {
+		where = append(where, sq.Lt{"messages.created_at": *search.To})
+	}
+
+	if search.After != nil {
+		rank := strconv.FormatFloat(search.After.Rank, 'f', 6, 64)
+		where = append(where, sq.Expr(
+			"("+searchRank+" < ?::numeric OR ("+searchRank+" = ?::numeric AND (messages.created_at, messages.id) < (SELECT after_message.created_at, after_message.id FROM messages after_message WHERE after_message.id=?)))",
+			rank, rank, search.After.MessageId,
+		))
+	}
+
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.
+		Select(messageColumns).
+		Column(searchHeadline, searchHeadlineOptions).
+		Column(searchRank+" AS rank").
+		From("messages").
+		JoinClause("CROSS JOIN websearch_to_tsquery('simple', ?) AS search_query", search.Query).
+		JoinClause("INNER JOIN user_in_room ON user_in_room.room_id=messages.room_id AND user_in_room.user_id=?", search.UserId).
+		Where(where).
+		OrderBy("rank DESC", "messages.created_at DESC", "messages.id DESC").
+		Limit(uint64(search.PageSize)).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []model.SearchResult{}
+	err = s.db.SelectContext(ctx, &results, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Snippet = markSnippet(results[i].Snippet)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkSnippet_EscapesTextAndMarksMatches(t *testing.T) {
+	snippet := markSnippet("\x02deploy\x03 <script>alert(\"x\")</script> & \x02deploy\x03")
+
+	assert.Equal(t, "<mark>deploy</mark> &lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; <mark>deploy</mark>", snippet)
+}
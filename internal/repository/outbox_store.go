@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/jmoiron/sqlx"
+	"google.golang.org/protobuf/proto"
+)
+
+type OutboxStore interface {
+	Claim(ctx context.Context, now, leaseUntil time.Time, limit int) ([]model.OutboxEvent, error)
+	MarkSent(ctx context.Context, id int64, sentAt time.Time) error
+	MarkFailed(ctx context.Context, id int64, nextAttemptAt time.Time, reason string) error
+	MarkPartiallySent(ctx context.Context, id int64, delivery []byte, nextAttemptAt time.Time, reason string) error
+	MarkDead(ctx context.Context, id int64, deadAt time.Time, reason string) error
+	DeleteSent(ctx context.Context, before time.Time) (int64, error)
+}
+
+type PostgresOutboxStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresOutboxStore(db *sqlx.DB) *PostgresOutboxStore {
+	return &PostgresOutboxStore{
+		db: db,
+	}
+}
+
+// addToOutbox stores [delivery] in transaction of the change it announces.
+func addToOutbox(ctx context.Context, tx *sqlx.Tx, delivery *pb.MessageDelivery, createdAt time.Time) error {
+	data, err := proto.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO outbox(delivery, created_at, next_attempt_at) VALUES($1, $2, $2)",
+		data, createdAt)
+
+	return err
+}
+
+// Claim
+//
+// Takes events due at [now], oldest first, and postpones their next attempt
+// to [leaseUntil]. Other relays skip claimed events, event of relay which
+// has died before marking it is claimed again once lease is over.
+func (s *PostgresOutboxStore) Claim(ctx context.Context, now, leaseUntil time.Time, limit int) ([]model.OutboxEvent, error) {
+	events := []model.OutboxEvent{}
+	err := s.db.SelectContext(
+		ctx,
+		&events,
+		`
+		UPDATE outbox SET next_attempt_at=$2
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE sent_at IS NULL AND dead_at IS NULL AND next_attempt_at<=$1
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, delivery, created_at, attempts
+		`, now, leaseUntil, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (s *PostgresOutboxStore) MarkSent(ctx context.Context, id int64, sentAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE outbox SET sent_at=$2, attempts=attempts+1, last_error=NULL WHERE id=$1",
+		id, sentAt)
+
+	return err
+}
+
+func (s *PostgresOutboxStore) MarkFailed(ctx context.Context, id int64, nextAttemptAt time.Time, reason string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE outbox SET next_attempt_at=$2, attempts=attempts+1, last_error=$3 WHERE id=$1",
+		id, nextAttemptAt, reason)
+
+	return err
+}
+
+// MarkPartiallySent
+//
+// Replaces delivery of event published to some of recipients with
+// [delivery] for the rest of them, which is retried at [nextAttemptAt].
+func (s *PostgresOutboxStore) MarkPartiallySent(ctx context.Context, id int64, delivery []byte, nextAttemptAt time.Time, reason string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE outbox SET delivery=$2, next_attempt_at=$3, attempts=attempts+1, last_error=$4 WHERE id=$1",
+		id, delivery, nextAttemptAt, reason)
+
+	return err
+}
+
+// MarkDead parks event which is not going to be published, so it is not
+// claimed anymore. Dead events are kept for investigation.
+func (s *PostgresOutboxStore) MarkDead(ctx context.Context, id int64, deadAt time.Time, reason string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE outbox SET dead_at=$2, attempts=attempts+1, last_error=$3 WHERE id=$1",
+		id, deadAt, reason)
+
+	return err
+}
+
+// DeleteSent deletes events sent before [before] and returns their number.
+func (s *PostgresOutboxStore) DeleteSent(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM outbox WHERE sent_at<$1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/config"
+	"github.com/jmoiron/sqlx"
+)
+
+// OpenPostgres
+//
+// Connects to database of [cfg] with pool limits applied. Fails if database
+// does not answer within [timeout].
+func OpenPostgres(cfg config.DatabaseConfig, timeout time.Duration) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", cfg.DataSourceName())
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
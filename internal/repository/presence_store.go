@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type PresenceStore interface {
+	Get(ctx context.Context, userIds ...uuid.UUID) ([]model.Presence, error)
+	SetLastSeen(ctx context.Context, userId uuid.UUID, lastSeenAt time.Time) error
+	SetHideLastSeen(ctx context.Context, userId uuid.UUID, hide bool) error
+}
+
+type PostgresPresenceStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresPresenceStore(db *sqlx.DB) *PostgresPresenceStore {
+	return &PostgresPresenceStore{
+		db: db,
+	}
+}
+
+// Get returns stored presence of users. Users who have never been seen
+// online and have not changed settings are omitted.
+func (s *PostgresPresenceStore) Get(ctx context.Context, userIds ...uuid.UUID) ([]model.Presence, error) {
+	presences := []model.Presence{}
+	if len(userIds) == 0 {
+		return presences, nil
+	}
+
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql, args, err := psql.
+		Select("user_id, last_seen_at, hide_last_seen").
+		From("user_presence").
+		Where(sq.Eq{"user_id": userIds}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.SelectContext(ctx, &presences, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return presences, nil
+}
+
+func (s *PostgresPresenceStore) SetLastSeen(ctx context.Context, userId uuid.UUID, lastSeenAt time.Time) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+		INSERT INTO user_presence(user_id, last_seen_at) VALUES($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET last_seen_at=EXCLUDED.last_seen_at
+		`, userId, lastSeenAt)
+
+	return err
+}
+
+func (s *PostgresPresenceStore) SetHideLastSeen(ctx context.Context, userId uuid.UUID, hide bool) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+		INSERT INTO user_presence(user_id, hide_last_seen) VALUES($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET hide_last_seen=EXCLUDED.hide_last_seen
+		`, userId, hide)
+
+	return err
+}
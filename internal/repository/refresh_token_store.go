@@ -2,16 +2,25 @@ package repository
 
 import (
 	"context"
+	"errors"
 
 	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
+var ErrRefreshTokenReused = errors.New("refresh token is already used")
+
 type RefreshTokenStore interface {
 	Add(ctx context.Context, token *model.RefreshToken) error
 	Delete(ctx context.Context, token uuid.UUID) error
 	Get(ctx context.Context, token uuid.UUID) (*model.RefreshToken, error)
+	Rotate(ctx context.Context, oldToken uuid.UUID, newToken *model.RefreshToken) error
+	ListByUser(ctx context.Context, userId uuid.UUID) ([]model.RefreshToken, error)
+	DeleteByUser(ctx context.Context, userId uuid.UUID) error
+	ListByFamily(ctx context.Context, familyId uuid.UUID) ([]model.RefreshToken, error)
+	DeleteFamily(ctx context.Context, familyId uuid.UUID) error
 }
 
 type RefreshTokenPostgresStore struct {
@@ -24,15 +33,28 @@ func NewRefreshTokenPostgresStore(db *sqlx.DB) *RefreshTokenPostgresStore {
 	}
 }
 
+// Add saves [token] starting new family and deletes expired tokens of the
+// same user, so families user has abandoned do not pile up.
 func (s *RefreshTokenPostgresStore) Add(ctx context.Context, token *model.RefreshToken) error {
-	_, err := s.db.ExecContext(ctx, "INSERT INTO refresh_tokens(token, user_id, expires_at, issued_at) VALUES($1, $2, $3, $4)",
-		token.Token, token.UserId, token.ExpiresAt, token.IssuedAt)
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	_, err = tx.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE user_id=$1 AND expires_at<$2",
+		token.UserId, utils.Now())
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = tx.ExecContext(ctx, "INSERT INTO refresh_tokens(token, user_id, family_id, expires_at, issued_at) VALUES($1, $2, $3, $4, $5)",
+		token.Token, token.UserId, token.FamilyId, token.ExpiresAt, token.IssuedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (s *RefreshTokenPostgresStore) Delete(ctx context.Context, token uuid.UUID) error {
@@ -55,3 +77,89 @@ func (s *RefreshTokenPostgresStore) Get(ctx context.Context, token uuid.UUID) (*
 
 	return refreshToken, nil
 }
+
+// Rotate
+//
+// Marks [oldToken] as used and saves [newToken] in one transaction.
+// Used tokens are kept until they expire, so that presenting one of them
+// again can be detected, and expired tokens of the family are deleted.
+// Expired token is rejected whether it was used or not, so forgetting it
+// does not let reuse go unnoticed. Returns [ErrRefreshTokenReused] if
+// [oldToken] has already been used.
+func (s *RefreshTokenPostgresStore) Rotate(ctx context.Context, oldToken uuid.UUID, newToken *model.RefreshToken) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at=$2 WHERE token=$1 AND used_at IS NULL",
+		oldToken, utils.Now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE family_id=$1 AND expires_at<$2",
+		newToken.FamilyId, utils.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO refresh_tokens(token, user_id, family_id, expires_at, issued_at) VALUES($1, $2, $3, $4, $5)",
+		newToken.Token, newToken.UserId, newToken.FamilyId, newToken.ExpiresAt, newToken.IssuedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *RefreshTokenPostgresStore) ListByUser(ctx context.Context, userId uuid.UUID) ([]model.RefreshToken, error) {
+	refreshTokens := []model.RefreshToken{}
+	err := s.db.SelectContext(ctx, &refreshTokens, "SELECT * FROM refresh_tokens WHERE user_id=$1", userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return refreshTokens, nil
+}
+
+func (s *RefreshTokenPostgresStore) DeleteByUser(ctx context.Context, userId uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE user_id=$1", userId)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *RefreshTokenPostgresStore) ListByFamily(ctx context.Context, familyId uuid.UUID) ([]model.RefreshToken, error) {
+	refreshTokens := []model.RefreshToken{}
+	err := s.db.SelectContext(ctx, &refreshTokens, "SELECT * FROM refresh_tokens WHERE family_id=$1", familyId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return refreshTokens, nil
+}
+
+func (s *RefreshTokenPostgresStore) DeleteFamily(ctx context.Context, familyId uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE family_id=$1", familyId)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
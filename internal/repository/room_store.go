@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -14,16 +16,36 @@ import (
 )
 
 type RoomStore interface {
-	AddAndSendMessage(ctx context.Context, room *model.Room, message *model.Message) (*model.Room, error)
+	AddAndSendMessage(ctx context.Context, room *model.Room, message *model.Message, recipientIds []uuid.UUID) (*model.Room, error)
 	Add(ctx context.Context, room *model.Room) error
 	Get(ctx context.Context, id uuid.UUID) (*model.Room, error)
 	FindDialogRoom(ctx context.Context, userId1, userId2 uuid.UUID) (*model.Room, error)
 	UsersInRoom(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	Members(ctx context.Context, id uuid.UUID) ([]model.RoomMember, error)
+	RoomMates(ctx context.Context, userId uuid.UUID) ([]uuid.UUID, error)
+	AddMembers(ctx context.Context, roomId uuid.UUID, userIds []uuid.UUID, message *model.Message) error
+	RemoveMember(ctx context.Context, roomId, userId uuid.UUID, message *model.Message) error
+	SetMemberRole(ctx context.Context, roomId, userId uuid.UUID, role string, message *model.Message) error
+	TransferOwnership(ctx context.Context, roomId, ownerId, userId uuid.UUID, message *model.Message) error
+	Rename(ctx context.Context, roomId uuid.UUID, name string, message *model.Message) error
 	FindByIds(ctx context.Context, ids ...uuid.UUID) ([]model.User, error)
-	ListRooms(ctx context.Context, userId uuid.UUID, lastMessageDate time.Time, pageSize int) ([]model.Room, error)
+	ListRooms(ctx context.Context, userId uuid.UUID, lastMessageTime time.Time, roomId uuid.UUID, pageSize int) ([]model.Room, error)
+	ListRoomsNewer(ctx context.Context, userId uuid.UUID, lastMessageTime time.Time, roomId uuid.UUID, pageSize int) ([]model.Room, error)
 	ListRoomsFirst(ctx context.Context, userId uuid.UUID, pageSize int) ([]model.Room, error)
+	MarkRead(ctx context.Context, marker *model.ReadMarker) (bool, error)
 }
 
+// roomReadStateColumns selects read state of user $1 in room. It expects
+// read_markers of that user to be joined to rooms.
+const roomReadStateColumns = `
+		read_markers.message_id AS last_read_message_id,
+		(
+			SELECT COUNT(*) FROM messages
+			WHERE messages.room_id=rooms.id AND messages.user_id<>$1 AND messages.deleted_at IS NULL
+			AND (read_markers.message_seq IS NULL OR messages.seq>read_markers.message_seq)
+			AND NOT EXISTS (SELECT 1 FROM hidden_messages WHERE hidden_messages.message_id=messages.id AND hidden_messages.user_id=$1)
+		) AS unread_count`
+
 type PostgresRoomStore struct {
 	db *sqlx.DB
 }
@@ -34,9 +56,16 @@ func NewPostgresRoomStore(db *sqlx.DB) *PostgresRoomStore {
 	}
 }
 
-func (s *PostgresRoomStore) AddAndSendMessage(ctx context.Context, room *model.Room, message *model.Message) (*model.Room, error) {
+// AddAndSendMessage
+//
+// Adds dialog room together with its first message, delivery of message to
+// [recipientIds] is put to outbox in the same transaction. Existing dialog
+// room of the same users is returned with codes.AlreadyExists instead.
+// Returns [ErrDuplicateMessage] and adds no room if sender has sent message
+// with the same client message id already.
+func (s *PostgresRoomStore) AddAndSendMessage(ctx context.Context, room *model.Room, message *model.Message, recipientIds []uuid.UUID) (*model.Room, error) {
 	r, err := s.FindDialogRoom(ctx, room.UserIds[0], room.UserIds[1])
-	if err != nil {
+	if !errors.Is(err, sql.ErrNoRows) {
 		return r, err
 	}
 
@@ -62,15 +91,35 @@ func (s *PostgresRoomStore) AddAndSendMessage(ctx context.Context, room *model.R
 	}
 
 	message.RoomId = room.Id
+	message.Seq, err = nextMessageSeq(ctx, tx, message)
+	if err != nil {
+		return nil, err
+	}
+
 	var messageId uuid.UUID
-	err = tx.GetContext(ctx, &messageId, "INSERT INTO messages(id, room_id, user_id, text, created_at) VALUES($1, $2, $3, $4, $5) RETURNING id",
-		message.Id, message.RoomId, message.UserId, message.Text, message.CreatedAt)
+	err = tx.GetContext(
+		ctx,
+		&messageId,
+		`
+		INSERT INTO messages(id, room_id, user_id, text, created_at, seq, client_message_id)
+		VALUES($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, client_message_id) WHERE client_message_id IS NOT NULL DO NOTHING
+		RETURNING id`,
+		message.Id, message.RoomId, message.UserId, message.Text, message.CreatedAt, message.Seq, message.ClientMessageId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDuplicateMessage
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	message.Id = messageId
 
+	err = addToOutbox(ctx, tx, message.NewDelivery(recipientIds), message.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
 	return room, tx.Commit()
 }
 
@@ -88,9 +137,14 @@ func (s *PostgresRoomStore) Add(ctx context.Context, room *model.Room) error {
 	}
 
 	for _, userId := range room.UserIds {
+		role := model.ROOM_MEMBER_ROLE
+		if userId == room.OwnerId {
+			role = model.ROOM_OWNER_ROLE
+		}
+
 		var room_id uuid.UUID
-		err = tx.GetContext(ctx, &room_id, "INSERT INTO user_in_room(room_id, user_id) VALUES($1, $2) RETURNING room_id",
-			room.Id, userId)
+		err = tx.GetContext(ctx, &room_id, "INSERT INTO user_in_room(room_id, user_id, role) VALUES($1, $2, $3) RETURNING room_id",
+			room.Id, userId, role)
 		if err != nil {
 			return err
 		}
@@ -107,15 +161,24 @@ func (s *PostgresRoomStore) Get(ctx context.Context, id uuid.UUID) (*model.Room,
 	return room, err
 }
 
+// FindDialogRoom
+//
+// Finds dialog room of both users. Returns found room with
+// codes.AlreadyExists, sql.ErrNoRows if users have no dialog yet.
 func (s *PostgresRoomStore) FindDialogRoom(ctx context.Context, userId1, userId2 uuid.UUID) (*model.Room, error) {
 	room := new(model.Room)
 	err := s.db.GetContext(
 		ctx,
 		room,
 		`
-		SELECT DISTINCT rooms.id, rooms.* FROM rooms
-		INNER JOIN user_in_room ON rooms.id=user_in_room.room_id
-		WHERE rooms.dialog_room=TRUE AND (user_in_room.user_id=$1 OR user_in_room.user_id=$2)
+		SELECT id, name, created_at, dialog_room, last_message_time FROM rooms
+		WHERE dialog_room=TRUE AND id IN (
+			SELECT room_id FROM user_in_room
+			WHERE user_id=$1 OR user_id=$2
+			GROUP BY room_id
+			HAVING COUNT(DISTINCT user_id)=2
+		)
+		LIMIT 1
 		`, userId1, userId2)
 
 	if err != nil {
@@ -144,6 +207,188 @@ func (s *PostgresRoomStore) UsersInRoom(ctx context.Context, id uuid.UUID) ([]uu
 	return userUUIDs, nil
 }
 
+// AddMembers adds users to room and records system [message] about it.
+func (s *PostgresRoomStore) AddMembers(ctx context.Context, roomId uuid.UUID, userIds []uuid.UUID, message *model.Message) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, userId := range userIds {
+		_, err = tx.ExecContext(ctx, "INSERT INTO user_in_room(room_id, user_id) VALUES($1, $2) ON CONFLICT DO NOTHING",
+			roomId, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertSystemMessage(ctx, tx, message)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember removes user from room and records system [message] about it.
+func (s *PostgresRoomStore) RemoveMember(ctx context.Context, roomId, userId uuid.UUID, message *model.Message) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM user_in_room WHERE room_id=$1 AND user_id=$2",
+		roomId, userId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return status.Error(codes.NotFound, "user is not a member of room")
+	}
+
+	err = insertSystemMessage(ctx, tx, message)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetMemberRole changes role of member and records system [message] about it.
+func (s *PostgresRoomStore) SetMemberRole(ctx context.Context, roomId, userId uuid.UUID, role string, message *model.Message) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = setMemberRole(ctx, tx, roomId, userId, role)
+	if err != nil {
+		return err
+	}
+
+	err = insertSystemMessage(ctx, tx, message)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TransferOwnership makes [userId] owner of room, previous owner stays as
+// moderator.
+func (s *PostgresRoomStore) TransferOwnership(ctx context.Context, roomId, ownerId, userId uuid.UUID, message *model.Message) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Room has single owner, so previous owner is demoted first
+	err = setMemberRole(ctx, tx, roomId, ownerId, model.ROOM_MODERATOR_ROLE)
+	if err != nil {
+		return err
+	}
+
+	err = setMemberRole(ctx, tx, roomId, userId, model.ROOM_OWNER_ROLE)
+	if err != nil {
+		return err
+	}
+
+	err = insertSystemMessage(ctx, tx, message)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresRoomStore) Rename(ctx context.Context, roomId uuid.UUID, name string, message *model.Message) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE rooms SET name=$2 WHERE id=$1", roomId, name)
+	if err != nil {
+		return err
+	}
+
+	err = insertSystemMessage(ctx, tx, message)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setMemberRole(ctx context.Context, tx *sqlx.Tx, roomId, userId uuid.UUID, role string) error {
+	res, err := tx.ExecContext(ctx, "UPDATE user_in_room SET role=$3 WHERE room_id=$1 AND user_id=$2",
+		roomId, userId, role)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return status.Error(codes.NotFound, "user is not a member of room")
+	}
+
+	return nil
+}
+
+func insertSystemMessage(ctx context.Context, tx *sqlx.Tx, message *model.Message) error {
+	seq, err := nextMessageSeq(ctx, tx, message)
+	if err != nil {
+		return err
+	}
+	message.Seq = seq
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO messages(id, room_id, user_id, text, created_at, seq, system) VALUES($1, $2, $3, $4, $5, $6, TRUE)",
+		message.Id, message.RoomId, message.UserId, message.Text, message.CreatedAt, message.Seq)
+
+	return err
+}
+
+// RoomMates returns every user sharing at least one room with [userId].
+func (s *PostgresRoomStore) RoomMates(ctx context.Context, userId uuid.UUID) ([]uuid.UUID, error) {
+	userIds := []uuid.UUID{}
+	err := s.db.SelectContext(
+		ctx,
+		&userIds,
+		`
+		SELECT DISTINCT mates.user_id FROM user_in_room
+		INNER JOIN user_in_room AS mates ON mates.room_id=user_in_room.room_id
+		WHERE user_in_room.user_id=$1 AND mates.user_id<>$1
+		`, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return userIds, nil
+}
+
+func (s *PostgresRoomStore) Members(ctx context.Context, id uuid.UUID) ([]model.RoomMember, error) {
+	members := []model.RoomMember{}
+	err := s.db.SelectContext(ctx, &members, "SELECT room_id, user_id, role FROM user_in_room WHERE room_id=$1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
 func (s *PostgresRoomStore) FindByIds(ctx context.Context, ids ...uuid.UUID) ([]model.User, error) {
 	// TODO: rewrite with more safety. 
 	// We can pass array with $1
@@ -166,7 +411,9 @@ func (s *PostgresRoomStore) FindByIds(ctx context.Context, ids ...uuid.UUID) ([]
 	return users, nil
 }
 
-func (s *PostgresRoomStore) ListRooms(ctx context.Context, userId uuid.UUID, lastMessageDate time.Time, pageSize int) ([]model.Room, error) {
+// ListRooms lists rooms ordered after room [roomId] with [lastMessageTime],
+// rooms with the latest messages first.
+func (s *PostgresRoomStore) ListRooms(ctx context.Context, userId uuid.UUID, lastMessageTime time.Time, roomId uuid.UUID, pageSize int) ([]model.Room, error) {
 	res := []model.Room{}
 	// This SQL Query has a workaround in it: first and second returned rows are actually the same.
 	// But removing first row somehow ruins everything.
@@ -176,13 +423,37 @@ func (s *PostgresRoomStore) ListRooms(ctx context.Context, userId uuid.UUID, las
 		ctx,
 		&res,
 		`
-		SELECT DISTINCT rooms.id, rooms.* FROM rooms
+		SELECT DISTINCT rooms.id, rooms.*, `+roomReadStateColumns+` FROM rooms
 		INNER JOIN user_in_room ON rooms.id=user_in_room.room_id
-		WHERE user_in_room.user_id=$1 AND rooms.last_message_time<=$2
-		ORDER BY rooms.last_message_time DESC
-		LIMIT $3
-		`, userId, lastMessageDate, pageSize)
+		LEFT JOIN read_markers ON read_markers.room_id=rooms.id AND read_markers.user_id=user_in_room.user_id
+		WHERE user_in_room.user_id=$1 AND (rooms.last_message_time, rooms.id)<($2, $3)
+		ORDER BY rooms.last_message_time DESC, rooms.id DESC
+		LIMIT $4
+		`, userId, lastMessageTime, roomId, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
 
+// ListRoomsNewer lists rooms ordered before room [roomId] with
+// [lastMessageTime], nearest to it first.
+func (s *PostgresRoomStore) ListRoomsNewer(ctx context.Context, userId uuid.UUID, lastMessageTime time.Time, roomId uuid.UUID, pageSize int) ([]model.Room, error) {
+	res := []model.Room{}
+	udb := s.db.Unsafe()
+	err := udb.SelectContext(
+		ctx,
+		&res,
+		`
+		SELECT DISTINCT rooms.id, rooms.*, `+roomReadStateColumns+` FROM rooms
+		INNER JOIN user_in_room ON rooms.id=user_in_room.room_id
+		LEFT JOIN read_markers ON read_markers.room_id=rooms.id AND read_markers.user_id=user_in_room.user_id
+		WHERE user_in_room.user_id=$1 AND (rooms.last_message_time, rooms.id)>($2, $3)
+		ORDER BY rooms.last_message_time ASC, rooms.id ASC
+		LIMIT $4
+		`, userId, lastMessageTime, roomId, pageSize)
 	if err != nil {
 		return nil, err
 	}
@@ -200,10 +471,11 @@ func (s *PostgresRoomStore) ListRoomsFirst(ctx context.Context, userId uuid.UUID
 		ctx,
 		&res,
 		`
-		SELECT DISTINCT rooms.id, rooms.* FROM rooms
+		SELECT DISTINCT rooms.id, rooms.*, `+roomReadStateColumns+` FROM rooms
 		INNER JOIN user_in_room ON rooms.id=user_in_room.room_id
+		LEFT JOIN read_markers ON read_markers.room_id=rooms.id AND read_markers.user_id=user_in_room.user_id
 		WHERE user_in_room.user_id=$1
-		ORDER BY rooms.last_message_time DESC
+		ORDER BY rooms.last_message_time DESC, rooms.id DESC
 		LIMIT $2
 		`, userId, pageSize)
 	if err != nil {
@@ -212,3 +484,28 @@ func (s *PostgresRoomStore) ListRoomsFirst(ctx context.Context, userId uuid.UUID
 
 	return res, nil
 }
+
+// MarkRead
+//
+// Moves read marker of user forward to the message of [marker]. Marker never
+// moves backwards, returned flag reports whether it has moved.
+func (s *PostgresRoomStore) MarkRead(ctx context.Context, marker *model.ReadMarker) (bool, error) {
+	res, err := s.db.ExecContext(
+		ctx,
+		`
+		INSERT INTO read_markers(room_id, user_id, message_id, message_seq, read_at) VALUES($1, $2, $3, $4, $5)
+		ON CONFLICT (room_id, user_id) DO UPDATE
+		SET message_id=EXCLUDED.message_id, message_seq=EXCLUDED.message_seq, read_at=EXCLUDED.read_at
+		WHERE read_markers.message_seq<EXCLUDED.message_seq
+		`, marker.RoomId, marker.UserId, marker.MessageId, marker.MessageSeq, marker.ReadAt)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
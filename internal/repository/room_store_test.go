@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRoomStore(t *testing.T) (*PostgresRoomStore, sqlmock.Sqlmock) {
+	db, dbMock, err := sqlmock.New()
+	assert.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return NewPostgresRoomStore(sqlx.NewDb(db, "postgres")), dbMock
+}
+
+// expectDialogRoomInsert expects dialog room of [room] to be looked up and
+// not found, then room and its members to be inserted.
+func expectDialogRoomInsert(dbMock sqlmock.Sqlmock, room *model.Room) {
+	dbMock.ExpectQuery(`SELECT id, name, created_at, dialog_room, last_message_time FROM rooms\s+WHERE dialog_room=TRUE AND id IN \(.+HAVING COUNT\(DISTINCT user_id\)=2`).
+		WithArgs(room.UserIds[0], room.UserIds[1]).
+		WillReturnError(sql.ErrNoRows)
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO rooms`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(room.Id))
+	for _, userId := range room.UserIds {
+		dbMock.ExpectQuery(`INSERT INTO user_in_room`).
+			WithArgs(room.Id, userId).
+			WillReturnRows(sqlmock.NewRows([]string{"room_id"}).AddRow(room.Id))
+	}
+	dbMock.ExpectQuery(`UPDATE rooms SET last_seq=last_seq\+1`).
+		WithArgs(room.Id, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"last_seq"}).AddRow(1))
+}
+
+func TestPostgresRoomStore_AddAndSendMessageCreatesDialogRoom(t *testing.T) {
+	utils.MockNow(utils.DefaultMockTime)
+
+	store, dbMock := newTestRoomStore(t)
+	senderId, recipientId := uuid.New(), uuid.New()
+	room := model.NewRoom("", true, senderId, recipientId)
+	message := model.NewMessage(senderId, uuid.Nil, "text")
+
+	expectDialogRoomInsert(dbMock, room)
+	dbMock.ExpectQuery(`INSERT INTO messages.+ON CONFLICT \(user_id, client_message_id\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(message.Id))
+	dbMock.ExpectExec(`INSERT INTO outbox`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectCommit()
+
+	res, err := store.AddAndSendMessage(context.TODO(), room, message, []uuid.UUID{recipientId})
+
+	assert.Nil(t, err)
+	assert.Equal(t, room.Id, res.Id)
+	assert.Equal(t, room.Id, message.RoomId)
+	assert.Equal(t, int64(1), message.Seq)
+	assert.Nil(t, dbMock.ExpectationsWereMet())
+}
+
+func TestPostgresRoomStore_AddAndSendMessageFailsIfMessageIsDuplicate(t *testing.T) {
+	utils.MockNow(utils.DefaultMockTime)
+
+	store, dbMock := newTestRoomStore(t)
+	senderId, recipientId := uuid.New(), uuid.New()
+	room := model.NewRoom("", true, senderId, recipientId)
+	clientMessageId := "key-1"
+	message := model.NewMessage(senderId, uuid.Nil, "text")
+	message.ClientMessageId = &clientMessageId
+
+	expectDialogRoomInsert(dbMock, room)
+	// Conflicting message is skipped, so nothing is returned
+	dbMock.ExpectQuery(`INSERT INTO messages.+ON CONFLICT \(user_id, client_message_id\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	dbMock.ExpectRollback()
+
+	res, err := store.AddAndSendMessage(context.TODO(), room, message, []uuid.UUID{recipientId})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, ErrDuplicateMessage)
+	assert.Nil(t, dbMock.ExpectationsWereMet())
+}
@@ -14,19 +14,19 @@ import (
 
 type SessionStore interface {
 	Add(session *model.Session) error
-	Send(id uuid.UUID, messageStream *pb.MessageStreamResponse) error
-	Delete(id uuid.UUID)
+	Send(userId uuid.UUID, messageStream *pb.MessageStreamResponse) error
+	Delete(userId, sessionId uuid.UUID)
 }
 
 type InMemorySessionStore struct {
 	mutex    sync.Mutex
-	sessions map[uuid.UUID]*model.Session
+	sessions map[uuid.UUID]map[uuid.UUID]*model.Session
 }
 
 func NewInMemorySessionStore() *InMemorySessionStore {
 	return &InMemorySessionStore{
 		mutex:    sync.Mutex{},
-		sessions: make(map[uuid.UUID]*model.Session),
+		sessions: make(map[uuid.UUID]map[uuid.UUID]*model.Session),
 	}
 }
 
@@ -34,32 +34,67 @@ func (s *InMemorySessionStore) Add(session *model.Session) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.sessions[session.Id] = session
+	userSessions, ok := s.sessions[session.UserId]
+	if !ok {
+		userSessions = make(map[uuid.UUID]*model.Session)
+		s.sessions[session.UserId] = userSessions
+	}
+	userSessions[session.Id] = session
 
 	return nil
 }
 
-func (s *InMemorySessionStore) Send(id uuid.UUID, messageStream *pb.MessageStreamResponse) error {
+// Send
+//
+// Sends message to every session of user. Fails only if user has no
+// sessions or message could not be delivered to any of them.
+func (s *InMemorySessionStore) Send(userId uuid.UUID, messageStream *pb.MessageStreamResponse) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	session, ok := s.sessions[id]
-	if !ok {
-		return status.Errorf(codes.Unavailable, "user %s is not connected to session", id)
+	userSessions, ok := s.sessions[userId]
+	if !ok || len(userSessions) == 0 {
+		return status.Errorf(codes.Unavailable, "user %s is not connected to session", userId)
 	}
 
-	if time.Duration(utils.Now().Unix()) >= session.Expires {
-		session.Done <- status.Errorf(codes.Unauthenticated, "JWT is expired")
-		return status.Errorf(codes.Unauthenticated, "JWT is expired")
+	var sendErr error
+	delivered := false
+	for _, session := range userSessions {
+		if time.Duration(utils.Now().Unix()) >= session.Expires {
+			sendErr = status.Errorf(codes.Unauthenticated, "JWT is expired")
+			// Session is deleted by its stream after it receives done
+			select {
+			case session.Done <- sendErr:
+			default:
+			}
+			continue
+		}
+
+		if err := session.Connection.Send(messageStream); err != nil {
+			sendErr = err
+			continue
+		}
+		delivered = true
 	}
 
-	err := session.Connection.Send(messageStream)
+	if delivered {
+		return nil
+	}
 
-	return err
+	return sendErr
 }
 
-func (s *InMemorySessionStore) Delete(id uuid.UUID) {
+func (s *InMemorySessionStore) Delete(userId, sessionId uuid.UUID) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	delete(s.sessions, id)
+
+	userSessions, ok := s.sessions[userId]
+	if !ok {
+		return
+	}
+
+	delete(userSessions, sessionId)
+	if len(userSessions) == 0 {
+		delete(s.sessions, userId)
+	}
 }
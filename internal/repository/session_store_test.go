@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestSession(userId uuid.UUID, connection pb.MessageService_GetMessagesServer) (*model.Session, chan error) {
+	done := make(chan error, 1)
+	expires := time.Duration(utils.Now().Add(time.Minute).Unix())
+	return model.NewSession(userId, "", connection, expires, done), done
+}
+
+func TestInMemorySessionStore_SendFailsIfUserIsNotConnected(t *testing.T) {
+	utils.MockNow(utils.DefaultMockTime)
+
+	store := NewInMemorySessionStore()
+	userId := uuid.New()
+
+	err := store.Send(userId, &pb.MessageStreamResponse{})
+
+	assert.ErrorIs(t, err, status.Errorf(codes.Unavailable, "user %s is not connected to session", userId))
+}
+
+func TestInMemorySessionStore_SendToEverySession(t *testing.T) {
+	utils.MockNow(utils.DefaultMockTime)
+
+	store := NewInMemorySessionStore()
+	userId := uuid.New()
+	message := &pb.MessageStreamResponse{Message: &pb.Message{Text: "some message"}}
+
+	phone := new(mocks.GetMessagesServerMock)
+	phone.On("Send", message).Return(nil)
+	laptop := new(mocks.GetMessagesServerMock)
+	laptop.On("Send", message).Return(nil)
+
+	phoneSession, _ := newTestSession(userId, phone)
+	laptopSession, _ := newTestSession(userId, laptop)
+	store.Add(phoneSession)
+	store.Add(laptopSession)
+
+	err := store.Send(userId, message)
+
+	assert.Nil(t, err)
+	phone.AssertNumberOfCalls(t, "Send", 1)
+	laptop.AssertNumberOfCalls(t, "Send", 1)
+}
+
+func TestInMemorySessionStore_SendSucceedsIfAnySessionReceives(t *testing.T) {
+	utils.MockNow(utils.DefaultMockTime)
+
+	store := NewInMemorySessionStore()
+	userId := uuid.New()
+	message := &pb.MessageStreamResponse{}
+
+	broken := new(mocks.GetMessagesServerMock)
+	broken.On("Send", message).Return(errors.New("some_error"))
+	working := new(mocks.GetMessagesServerMock)
+	working.On("Send", message).Return(nil)
+
+	brokenSession, _ := newTestSession(userId, broken)
+	workingSession, _ := newTestSession(userId, working)
+	store.Add(brokenSession)
+	store.Add(workingSession)
+
+	err := store.Send(userId, message)
+
+	assert.Nil(t, err)
+	working.AssertNumberOfCalls(t, "Send", 1)
+}
+
+func TestInMemorySessionStore_SendEndsExpiredSession(t *testing.T) {
+	utils.MockNow(utils.DefaultMockTime)
+
+	store := NewInMemorySessionStore()
+	userId := uuid.New()
+	connection := new(mocks.GetMessagesServerMock)
+
+	session, done := newTestSession(userId, connection)
+	session.Expires = time.Duration(utils.Now().Add(-time.Minute).Unix())
+	store.Add(session)
+
+	err := store.Send(userId, &pb.MessageStreamResponse{})
+
+	expectedError := status.Errorf(codes.Unauthenticated, "JWT is expired")
+	assert.ErrorIs(t, err, expectedError)
+	assert.ErrorIs(t, <-done, expectedError)
+	connection.AssertNotCalled(t, "Send")
+}
+
+func TestInMemorySessionStore_DeleteRemovesOnlyEndingSession(t *testing.T) {
+	utils.MockNow(utils.DefaultMockTime)
+
+	store := NewInMemorySessionStore()
+	userId := uuid.New()
+	message := &pb.MessageStreamResponse{}
+
+	phone := new(mocks.GetMessagesServerMock)
+	laptop := new(mocks.GetMessagesServerMock)
+	laptop.On("Send", message).Return(nil)
+
+	phoneSession, _ := newTestSession(userId, phone)
+	laptopSession, _ := newTestSession(userId, laptop)
+	store.Add(phoneSession)
+	store.Add(laptopSession)
+
+	store.Delete(userId, phoneSession.Id)
+	err := store.Send(userId, message)
+
+	assert.Nil(t, err)
+	phone.AssertNotCalled(t, "Send", message)
+	laptop.AssertNumberOfCalls(t, "Send", 1)
+
+	store.Delete(userId, laptopSession.Id)
+	err = store.Send(userId, message)
+
+	assert.ErrorIs(t, err, status.Errorf(codes.Unavailable, "user %s is not connected to session", userId))
+}
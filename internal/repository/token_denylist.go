@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type TokenDenylist interface {
+	Add(ctx context.Context, jti uuid.UUID, expiresAt time.Time) error
+	Contains(ctx context.Context, jti uuid.UUID) (bool, error)
+}
+
+type PostgresTokenDenylist struct {
+	db *sqlx.DB
+}
+
+func NewPostgresTokenDenylist(db *sqlx.DB) *PostgresTokenDenylist {
+	return &PostgresTokenDenylist{
+		db: db,
+	}
+}
+
+func (s *PostgresTokenDenylist) Add(ctx context.Context, jti uuid.UUID, expiresAt time.Time) error {
+	// Access tokens are short-lived, so rows are useless once the token they
+	// deny would have expired anyway
+	_, err := s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at<$1", utils.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, "INSERT INTO revoked_tokens(jti, expires_at) VALUES($1, $2) ON CONFLICT (jti) DO NOTHING",
+		jti, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *PostgresTokenDenylist) Contains(ctx context.Context, jti uuid.UUID) (bool, error) {
+	var revoked bool
+	err := s.db.GetContext(ctx, &revoked, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti=$1 AND expires_at>=$2)",
+		jti, utils.Now())
+	if err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
@@ -1,7 +1,23 @@
 package server
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/ArtyomArtamonov/msg/internal/model"
 	"github.com/ArtyomArtamonov/msg/internal/repository"
@@ -12,24 +28,79 @@ import (
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+// maxRoomNameLength is length of rooms.name column.
+const maxRoomNameLength = 30
+
+const (
+	// maxAttachmentSize is the biggest file which can be uploaded, in bytes.
+	maxAttachmentSize = 25 << 20
+	// maxFileNameLength is length of attachments.file_name column.
+	maxFileNameLength = 255
+	// maxMessageAttachments is the most attachments one message can carry.
+	maxMessageAttachments = 10
+	// maxUnsentAttachments is the most attachments user can have uploaded
+	// without sending them.
+	maxUnsentAttachments = 20
+	// attachmentChunkSize is size of chunks attachments are downloaded by.
+	attachmentChunkSize = 64 << 10
+)
+
+const (
+	maxSearchQueryLength  = 256
+	defaultSearchPageSize = 20
+)
+
+// maxClientMessageIdLength is length of messages.client_message_id column.
+const maxClientMessageIdLength = 64
+
+// allowedAttachmentTypes are mime types files can be uploaded with. Images
+// of decodable types get their dimensions stored as well.
+var allowedAttachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"audio/mpeg":      true,
+	"audio/ogg":       true,
+	"application/pdf": true,
+	"application/zip": true,
+	"text/plain":      true,
+}
+
 type ApiServer struct {
 	pb.UnimplementedApiServiceServer
 
-	jwtManager   service.JWTManagerProtol
-	roomStore    repository.RoomStore
-	messageStore repository.MessageStore
-	amqpManager  service.AMQPProducer
+	jwtManager    service.JWTManagerProtol
+	roomStore     repository.RoomStore
+	messageStore  repository.MessageStore
+	inviteStore   repository.InviteStore
+	eventProducer service.EventProducer
+	// outboxNotifier is told about deliveries written to outbox
+	outboxNotifier service.OutboxNotifier
+
+	attachmentStore repository.AttachmentStore
+	blobStore       repository.BlobStore
+	cursorCodec     *service.CursorCodec
 }
 
-func NewApiServer(jwtManager service.JWTManagerProtol, roomStore repository.RoomStore, messageStore repository.MessageStore, amqpManager service.AMQPProducer) *ApiServer {
+func NewApiServer(jwtManager service.JWTManagerProtol, roomStore repository.RoomStore, messageStore repository.MessageStore, inviteStore repository.InviteStore, attachmentStore repository.AttachmentStore, blobStore repository.BlobStore, cursorCodec *service.CursorCodec, eventProducer service.EventProducer, outboxNotifier service.OutboxNotifier) *ApiServer {
 	return &ApiServer{
-		jwtManager:   jwtManager,
-		roomStore:    roomStore,
-		messageStore: messageStore,
-		amqpManager:  amqpManager,
+		jwtManager:      jwtManager,
+		roomStore:       roomStore,
+		messageStore:    messageStore,
+		inviteStore:     inviteStore,
+		eventProducer:   eventProducer,
+		outboxNotifier:  outboxNotifier,
+		attachmentStore: attachmentStore,
+		blobStore:       blobStore,
+		cursorCodec:     cursorCodec,
 	}
 }
 
@@ -39,8 +110,13 @@ func (s *ApiServer) CreateRoom(ctx context.Context, req *pb.CreateRoomRequest) (
 		return nil, err
 	}
 
+	ownerId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
 	usersInRoom := map[string]bool{}
-	usersInRoom[claims.Id] = true
+	usersInRoom[claims.Subject] = true
 	for _, userId := range req.UserIds {
 		usersInRoom[userId] = true
 	}
@@ -55,6 +131,7 @@ func (s *ApiServer) CreateRoom(ctx context.Context, req *pb.CreateRoomRequest) (
 	}
 
 	newRoom := model.NewRoom(req.Name, false, usersInRoomUUIDs...)
+	newRoom.OwnerId = ownerId
 	err = s.roomStore.Add(ctx, newRoom)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot create room: %v", err)
@@ -84,56 +161,56 @@ func (s *ApiServer) ListRooms(ctx context.Context, req *pb.ListRoomsRequest) (*p
 		return nil, err
 	}
 
-	userId, err := uuid.Parse(claims.Id)
+	userId, err := uuid.Parse(claims.Subject)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
 	}
 
+	filter := model.NewCursorFilter("rooms", userId.String())
+
 	var rooms []model.Room
+	var bounds pageBounds
 	if req.NextToken == nil {
 		rooms, err = s.roomStore.ListRoomsFirst(ctx, userId, int(req.PageSize))
 		if err != nil {
 			return nil, err
 		}
+		bounds = firstPage(int(req.PageSize), len(rooms))
 	} else {
-		lastMessageTime, e := utils.DecodePageToken(req.NextToken.Value)
+		cursor, e := s.cursorCodec.Decode(req.NextToken.Value, filter)
 		if e != nil {
 			return nil, status.Errorf(codes.InvalidArgument, "cannot parse next token: %v", e)
 		}
-		rooms, err = s.roomStore.ListRooms(ctx, userId, *lastMessageTime, int(req.PageSize))
+
+		lastMessageTime := time.UnixMicro(cursor.SortKey).UTC()
+		if cursor.Direction == model.PAGE_NEWER {
+			rooms, err = s.roomStore.ListRoomsNewer(ctx, userId, lastMessageTime, cursor.Id, int(req.PageSize))
+			utils.Reverse(rooms)
+		} else {
+			rooms, err = s.roomStore.ListRooms(ctx, userId, lastMessageTime, cursor.Id, int(req.PageSize))
+		}
+		bounds = pageAfter(cursor.Direction, int(req.PageSize), len(rooms))
 	}
 
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot get rooms: %v", err)
 	}
 
-	var nextToken string
-	if len(rooms) < int(req.PageSize) {
-		nextToken = ""
-	} else {
-		lastRoom := rooms[len(rooms)-1]
-		nextToken = utils.EncodePageToken(lastRoom.LastMessageTime)
-	}
-
 	pbRooms := []*pb.Room{}
 	for _, room := range rooms {
 		pbRooms = append(pbRooms, room.PbRoom())
 	}
 
-	var listRoomsResponse *pb.ListRoomsResponse
-	if len(nextToken) == 0 {
-		listRoomsResponse = &pb.ListRoomsResponse{
-			NextToken: nil,
-			Rooms:     pbRooms,
-		}
-	} else {
-		listRoomsResponse = &pb.ListRoomsResponse{
-			NextToken: &wrapperspb.StringValue{Value: nextToken},
-			Rooms:     pbRooms,
-		}
+	response := &pb.ListRoomsResponse{
+		Rooms: pbRooms,
+	}
+	if len(rooms) > 0 {
+		first, last := rooms[0], rooms[len(rooms)-1]
+		response.NextToken = bounds.token(s.cursorCodec, model.NewCursor(filter, last.LastMessageTime.UnixMicro(), last.Id, model.PAGE_OLDER))
+		response.PrevToken = bounds.token(s.cursorCodec, model.NewCursor(filter, first.LastMessageTime.UnixMicro(), first.Id, model.PAGE_NEWER))
 	}
 
-	return listRoomsResponse, nil
+	return response, nil
 }
 
 func (s *ApiServer) ListMessages(ctx context.Context, req *pb.ListMessagesRequest) (*pb.ListMessagesResponse, error) {
@@ -146,7 +223,7 @@ func (s *ApiServer) ListMessages(ctx context.Context, req *pb.ListMessagesReques
 		return nil, err
 	}
 
-	userId, err := uuid.Parse(claims.Id)
+	userId, err := uuid.Parse(claims.Subject)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
 	}
@@ -165,26 +242,37 @@ func (s *ApiServer) ListMessages(ctx context.Context, req *pb.ListMessagesReques
 		return nil, status.Error(codes.PermissionDenied, "")
 	}
 
+	filter := model.NewCursorFilter("messages", chatId.String(), userId.String())
+
 	var messages []model.Message
-	if req.NextToken == nil {
-		messages, err = s.messageStore.ListMessagesFirst(ctx, chatId, int(req.PageSize))
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		lastMessageTime, e := utils.DecodePageToken(req.NextToken.Value)
+	var bounds pageBounds
+	switch {
+	case req.AroundMessageId != "":
+		messages, bounds, err = s.listMessagesAround(ctx, chatId, userId, req.AroundMessageId, int(req.PageSize))
+	case req.NextToken == nil:
+		messages, err = s.messageStore.ListMessagesFirst(ctx, chatId, userId, int(req.PageSize))
+		bounds = firstPage(int(req.PageSize), len(messages))
+	default:
+		cursor, e := s.cursorCodec.Decode(req.NextToken.Value, filter)
 		if e != nil {
 			return nil, status.Errorf(codes.InvalidArgument, "cannot parse next token: %v", e)
 		}
-		messages, err = s.messageStore.ListMessages(ctx, chatId, *lastMessageTime, int(req.PageSize))
+
+		if cursor.Direction == model.PAGE_NEWER {
+			messages, err = s.messageStore.ListMessagesNewer(ctx, chatId, userId, cursor.SortKey, int(req.PageSize))
+			utils.Reverse(messages)
+		} else {
+			messages, err = s.messageStore.ListMessages(ctx, chatId, userId, cursor.SortKey, int(req.PageSize))
+		}
+		bounds = pageAfter(cursor.Direction, int(req.PageSize), len(messages))
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	var nextToken string
-	if len(messages) < int(req.PageSize) {
-		nextToken = ""
-	} else {
-		lastMessage := messages[len(messages)-1]
-		nextToken = utils.EncodePageToken(lastMessage.CreatedAt)
+	err = s.withDetails(ctx, userId, messages)
+	if err != nil {
+		return nil, err
 	}
 
 	pbMessages := []*pb.Message{}
@@ -192,20 +280,248 @@ func (s *ApiServer) ListMessages(ctx context.Context, req *pb.ListMessagesReques
 		pbMessages = append(pbMessages, message.ToPbMessage())
 	}
 
-	var listMessagesResponse *pb.ListMessagesResponse
-	if len(nextToken) == 0 {
-		listMessagesResponse = &pb.ListMessagesResponse{
-			NextToken: nil,
-			Messages:  pbMessages,
+	response := &pb.ListMessagesResponse{
+		Messages: pbMessages,
+	}
+	if len(messages) > 0 {
+		first, last := messages[0], messages[len(messages)-1]
+		response.NextToken = bounds.token(s.cursorCodec, model.NewCursor(filter, last.Seq, last.Id, model.PAGE_OLDER))
+		response.PrevToken = bounds.token(s.cursorCodec, model.NewCursor(filter, first.Seq, first.Id, model.PAGE_NEWER))
+	}
+
+	return response, nil
+}
+
+// listMessagesAround
+//
+// Lists page of messages with message [id] in the middle of it, newest
+// first. Message itself is listed with older half of page.
+func (s *ApiServer) listMessagesAround(ctx context.Context, chatId, userId uuid.UUID, id string, pageSize int) ([]model.Message, pageBounds, error) {
+	message, err := s.getMessage(ctx, id)
+	if err != nil {
+		return nil, pageBounds{}, err
+	}
+	if message.RoomId != chatId {
+		return nil, pageBounds{}, status.Error(codes.InvalidArgument, "message is not from this room")
+	}
+
+	newerSize := pageSize / 2
+	olderSize := pageSize - newerSize
+
+	newer, err := s.messageStore.ListMessagesNewer(ctx, chatId, userId, message.Seq, newerSize)
+	if err != nil {
+		return nil, pageBounds{}, err
+	}
+	older, err := s.messageStore.ListMessages(ctx, chatId, userId, message.Seq+1, olderSize)
+	if err != nil {
+		return nil, pageBounds{}, err
+	}
+
+	utils.Reverse(newer)
+	bounds := pageBounds{
+		hasOlder: len(older) == olderSize,
+		hasNewer: len(newer) == newerSize,
+	}
+
+	return append(newer, older...), bounds, nil
+}
+
+// ListThread
+//
+// Lists replies in thread paginated like ListMessages, newest first. Thread
+// is found by its root or any reply in it.
+func (s *ApiServer) ListThread(ctx context.Context, req *pb.ListThreadRequest) (*pb.ListThreadResponse, error) {
+	if req.PageSize > 100 {
+		return nil, status.Error(codes.InvalidArgument, "page_size cannot be bigger than 100")
+	}
+
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	root, err := s.getMessage(ctx, req.MessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	roomUserIds, err := s.roomStore.UsersInRoom(ctx, root.RoomId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "")
+	}
+
+	if !utils.ArrayContains(roomUserIds, userId) {
+		return nil, status.Error(codes.PermissionDenied, "")
+	}
+
+	if root.ThreadRootId.Valid {
+		root, err = s.messageStore.GetMessage(ctx, root.ThreadRootId.UUID)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "message not found")
 		}
+	}
+
+	filter := model.NewCursorFilter("thread", root.Id.String(), userId.String())
+
+	var messages []model.Message
+	if req.NextToken == nil {
+		messages, err = s.messageStore.ListThreadFirst(ctx, root.Id, userId, int(req.PageSize))
 	} else {
-		listMessagesResponse = &pb.ListMessagesResponse{
-			NextToken: &wrapperspb.StringValue{Value: nextToken},
-			Messages:  pbMessages,
+		cursor, e := s.cursorCodec.Decode(req.NextToken.Value, filter)
+		if e != nil || cursor.Direction != model.PAGE_OLDER {
+			return nil, status.Errorf(codes.InvalidArgument, "cannot parse next token: %v", service.ErrInvalidCursor)
+		}
+		messages, err = s.messageStore.ListThread(ctx, root.Id, userId, cursor.SortKey, int(req.PageSize))
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not list thread: %v", err)
+	}
+
+	// Root is listed together with replies, so reactions are counted at once
+	messages = append([]model.Message{*root}, messages...)
+	err = s.withDetails(ctx, userId, messages)
+	if err != nil {
+		return nil, err
+	}
+	root, messages = &messages[0], messages[1:]
+
+	pbMessages := []*pb.Message{}
+	for _, message := range messages {
+		pbMessages = append(pbMessages, message.ToPbMessage())
+	}
+
+	response := &pb.ListThreadResponse{
+		Root:     root.ToPbMessage(),
+		Messages: pbMessages,
+	}
+	if len(messages) > 0 && len(messages) >= int(req.PageSize) {
+		lastMessage := messages[len(messages)-1]
+		cursor := model.NewCursor(filter, lastMessage.Seq, lastMessage.Id, model.PAGE_OLDER)
+		response.NextToken = &wrapperspb.StringValue{Value: s.cursorCodec.Encode(cursor)}
+	}
+
+	return response, nil
+}
+
+// SearchMessages
+//
+// Searches messages in every room caller belongs to, or in one of them.
+// Results are paginated by next token like ListMessages.
+func (s *ApiServer) SearchMessages(ctx context.Context, req *pb.SearchMessagesRequest) (*pb.SearchMessagesResponse, error) {
+	if req.PageSize < 0 || req.PageSize > 100 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must be from 0 to 100")
+	}
+
+	query := strings.TrimSpace(req.Query)
+	queryLength := utf8.RuneCountInString(query)
+	if queryLength == 0 || queryLength > maxSearchQueryLength {
+		return nil, status.Errorf(codes.InvalidArgument, "query must be from 1 to %d characters long", maxSearchQueryLength)
+	}
+
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	search := &model.MessageSearch{
+		UserId:   userId,
+		Query:    query,
+		PageSize: int(req.PageSize),
+	}
+	if search.PageSize == 0 {
+		search.PageSize = defaultSearchPageSize
+	}
+
+	if req.RoomId != "" {
+		roomId, err := uuid.Parse(req.RoomId)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+		}
+
+		roomUserIds, err := s.roomStore.UsersInRoom(ctx, roomId)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "")
+		}
+
+		if !utils.ArrayContains(roomUserIds, userId) {
+			return nil, status.Error(codes.PermissionDenied, "")
+		}
+
+		search.RoomId = uuid.NullUUID{UUID: roomId, Valid: true}
+	}
+
+	if req.SenderId != "" {
+		senderId, err := uuid.Parse(req.SenderId)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+		}
+
+		search.SenderId = uuid.NullUUID{UUID: senderId, Valid: true}
+	}
+
+	if req.From != nil {
+		from := req.From.AsTime()
+		search.From = &from
+	}
+
+	if req.To != nil {
+		to := req.To.AsTime()
+		search.To = &to
+	}
+
+	if search.From != nil && search.To != nil && !search.From.Before(*search.To) {
+		return nil, status.Error(codes.InvalidArgument, "from must be before to")
+	}
+
+	filter := search.CursorFilter()
+
+	if req.NextToken != nil {
+		cursor, e := s.cursorCodec.Decode(req.NextToken.Value, filter)
+		if e != nil || cursor.Direction != model.PAGE_OLDER {
+			return nil, status.Errorf(codes.InvalidArgument, "cannot parse next token: %v", service.ErrInvalidCursor)
 		}
+		search.After = model.NewSearchCursor(cursor)
+	}
+
+	results, err := s.messageStore.SearchMessages(ctx, search)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not search messages: %v", err)
+	}
+
+	messages := []model.Message{}
+	for _, result := range results {
+		messages = append(messages, result.Message)
+	}
+
+	err = s.withDetails(ctx, userId, messages)
+	if err != nil {
+		return nil, err
 	}
 
-	return listMessagesResponse, nil
+	response := &pb.SearchMessagesResponse{
+		Results: []*pb.SearchResult{},
+	}
+	for i := range results {
+		results[i].Message = messages[i]
+		response.Results = append(response.Results, results[i].ToPbSearchResult())
+	}
+
+	if len(results) == search.PageSize {
+		lastResult := results[len(results)-1]
+		response.NextToken = &wrapperspb.StringValue{Value: s.cursorCodec.Encode(lastResult.Cursor(filter))}
+	}
+
+	return response, nil
 }
 
 func (s *ApiServer) SendMessage(ctx context.Context, req *pb.MessageRequest) (*pb.MessageResponse, error) {
@@ -214,16 +530,34 @@ func (s *ApiServer) SendMessage(ctx context.Context, req *pb.MessageRequest) (*p
 		return nil, err
 	}
 
-	senderId, err := uuid.Parse(claims.Id)
+	senderId, err := uuid.Parse(claims.Subject)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
 	}
 
+	var clientMessageId *string
+	if req.ClientMessageId != "" {
+		if len(req.ClientMessageId) > maxClientMessageIdLength {
+			return nil, status.Errorf(codes.InvalidArgument, "client_message_id cannot be longer than %d bytes", maxClientMessageIdLength)
+		}
+
+		// Retried request gets message stored by the first one, which is not published again
+		original, err := s.originalMessage(ctx, senderId, req.ClientMessageId)
+		if original != nil || err != nil {
+			return original, err
+		}
+		clientMessageId = &req.ClientMessageId
+	}
+
 	room, ok := req.Recipient.(*pb.MessageRequest_RoomId)
 
 	if !ok {
 		// Dialog room is not created, first message is about to send
 
+		if req.ReplyToMessageId != "" || len(req.AttachmentIds) > 0 {
+			return nil, status.Error(codes.InvalidArgument, "replies and attachments must be sent to room_id")
+		}
+
 		userId := req.Recipient.(*pb.MessageRequest_UserId)
 
 		recipientId, err := uuid.Parse(userId.UserId)
@@ -233,24 +567,21 @@ func (s *ApiServer) SendMessage(ctx context.Context, req *pb.MessageRequest) (*p
 
 		room := model.NewRoom("", true, senderId, recipientId)
 		message := model.NewMessage(senderId, uuid.Nil, req.Message)
-		roomResponse, err := s.roomStore.AddAndSendMessage(ctx, room, message)
-		if err != nil && status.Code(err) == codes.AlreadyExists {
+		message.ClientMessageId = clientMessageId
+		// Message is delivered through outbox written together with it
+		recipientIds := []uuid.UUID{recipientId}
+		roomResponse, err := s.roomStore.AddAndSendMessage(ctx, room, message, recipientIds)
+		if status.Code(err) == codes.AlreadyExists {
 			message.RoomId = roomResponse.Id
-
-			err := s.messageStore.SendMessage(ctx, message)
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "could not create room or send message: %v", err)
-			}
+			err = s.messageStore.SendMessage(ctx, message, recipientIds)
 		}
-
-		messageDelivery := &pb.MessageDelivery{
-			Message: message.ToPbMessage(),
-			UserIds: []string{recipientId.String()},
+		if errors.Is(err, repository.ErrDuplicateMessage) {
+			return s.duplicateMessage(ctx, senderId, req.ClientMessageId)
 		}
-		err = s.amqpManager.Produce(messageDelivery)
 		if err != nil {
-			logrus.Errorf("could not send message by amqp: %v", err)
+			return nil, status.Errorf(codes.Internal, "could not create room or send message: %v", err)
 		}
+		s.outboxNotifier.Notify()
 
 		response := &pb.MessageResponse{
 			RoomId:  roomResponse.PbRoom().Id,
@@ -275,23 +606,43 @@ func (s *ApiServer) SendMessage(ctx context.Context, req *pb.MessageRequest) (*p
 		}
 
 		message := model.NewMessage(senderId, roomId, req.Message)
-		err = s.messageStore.SendMessage(ctx, message)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "could not send message: %v", err)
+		message.ClientMessageId = clientMessageId
+		if req.ReplyToMessageId != "" {
+			parent, err := s.getMessage(ctx, req.ReplyToMessageId)
+			if err != nil {
+				return nil, err
+			}
+
+			if parent.RoomId != roomId {
+				return nil, status.Error(codes.InvalidArgument, "cannot reply to message from other room")
+			}
+
+			if parent.IsDeleted() {
+				return nil, status.Error(codes.FailedPrecondition, "cannot reply to deleted message")
+			}
+
+			message.ReplyTo(parent)
 		}
-		var recipientUserIds []string
-		for _, roomUserId := range roomUserIds {
-			recipientUserIds = append(recipientUserIds, roomUserId.String())
+
+		if len(req.AttachmentIds) > 0 {
+			message.Attachments, err = s.messageAttachments(ctx, message, req.AttachmentIds)
+			if err != nil {
+				return nil, err
+			}
 		}
 
-		messageDelivery := &pb.MessageDelivery{
-			Message: message.ToPbMessage(),
-			UserIds: recipientUserIds,
+		// Message is delivered through outbox written together with it
+		err = s.messageStore.SendMessage(ctx, message, roomUserIds)
+		if errors.Is(err, repository.ErrDuplicateMessage) {
+			return s.duplicateMessage(ctx, senderId, req.ClientMessageId)
+		}
+		if errors.Is(err, repository.ErrAttachmentUnavailable) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
 		}
-		err = s.amqpManager.Produce(messageDelivery)
 		if err != nil {
-			logrus.Errorf("could not send message by amqp: %v", err)
+			return nil, status.Errorf(codes.Internal, "could not send message: %v", err)
 		}
+		s.outboxNotifier.Notify()
 
 		response := &pb.MessageResponse{
 			RoomId:  room.RoomId,
@@ -300,3 +651,1337 @@ func (s *ApiServer) SendMessage(ctx context.Context, req *pb.MessageRequest) (*p
 		return response, nil
 	}
 }
+
+// originalMessage returns message sender has sent with [clientMessageId]
+// before, or nil if there is none.
+func (s *ApiServer) originalMessage(ctx context.Context, senderId uuid.UUID, clientMessageId string) (*pb.MessageResponse, error) {
+	message, err := s.messageStore.GetMessageByClientId(ctx, senderId, clientMessageId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get message: %v", err)
+	}
+
+	messages := []model.Message{*message}
+	err = s.withDetails(ctx, senderId, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.MessageResponse{
+		RoomId:  message.RoomId.String(),
+		Message: messages[0].ToPbMessage(),
+	}, nil
+}
+
+// duplicateMessage returns message stored by concurrent request with the
+// same [clientMessageId].
+func (s *ApiServer) duplicateMessage(ctx context.Context, senderId uuid.UUID, clientMessageId string) (*pb.MessageResponse, error) {
+	original, err := s.originalMessage(ctx, senderId, clientMessageId)
+	if err != nil {
+		return nil, err
+	}
+	if original == nil {
+		return nil, status.Error(codes.Internal, "could not get message")
+	}
+
+	return original, nil
+}
+
+// EditMessage
+//
+// Replaces text of message. Only author who is still member of room is
+// allowed to edit message, deleted messages cannot be edited. Every member of room receives MESSAGE_EDITED event.
+func (s *ApiServer) EditMessage(ctx context.Context, req *pb.EditMessageRequest) (*pb.MessageResponse, error) {
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	if len(req.Text) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "text cannot be empty")
+	}
+
+	message, err := s.getMessage(ctx, req.MessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	roomUserIds, err := s.roomStore.UsersInRoom(ctx, message.RoomId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "")
+	}
+
+	if !utils.ArrayContains(roomUserIds, userId) {
+		return nil, status.Error(codes.PermissionDenied, "")
+	}
+
+	if message.UserId != userId {
+		return nil, status.Error(codes.PermissionDenied, "only author can edit message")
+	}
+
+	if message.IsDeleted() {
+		return nil, status.Error(codes.FailedPrecondition, "message is deleted")
+	}
+
+	editedAt := utils.Now()
+	err = s.messageStore.EditMessage(ctx, message.Id, req.Text, editedAt)
+	if errors.Is(err, repository.ErrMessageDeleted) {
+		// Message is deleted after it was checked
+		return nil, status.Error(codes.FailedPrecondition, "message is deleted")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not edit message: %v", err)
+	}
+	message.Text = req.Text
+	message.EditedAt = &editedAt
+
+	err = s.produce(message, pb.EventType_MESSAGE_EDITED, roomUserIds)
+	if err != nil {
+		logrus.Errorf("could not send message to event bus: %v", err)
+	}
+
+	response := &pb.MessageResponse{
+		RoomId:  message.RoomId.String(),
+		Message: message.ToPbMessage(),
+	}
+	return response, nil
+}
+
+// DeleteMessage
+//
+// Deletes message for everyone or only for the caller. Deleting for everyone
+// is allowed to author and admins, members of room receive MESSAGE_DELETED
+// event. Deleting for the caller only hides message from their history.
+func (s *ApiServer) DeleteMessage(ctx context.Context, req *pb.DeleteMessageRequest) (*emptypb.Empty, error) {
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	message, err := s.getMessage(ctx, req.MessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	roomUserIds, err := s.roomStore.UsersInRoom(ctx, message.RoomId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "")
+	}
+
+	if !utils.ArrayContains(roomUserIds, userId) {
+		return nil, status.Error(codes.PermissionDenied, "")
+	}
+
+	if !req.ForEveryone {
+		err = s.messageStore.HideMessage(ctx, message.Id, userId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not delete message: %v", err)
+		}
+		return &emptypb.Empty{}, nil
+	}
+
+	if message.UserId != userId && claims.Role != model.ADMIN_ROLE {
+		canModerate, err := s.canModerate(ctx, message.RoomId, userId)
+		if err != nil {
+			return nil, err
+		}
+		if !canModerate {
+			return nil, status.Error(codes.PermissionDenied, "only author can delete message for everyone")
+		}
+	}
+
+	if message.IsDeleted() {
+		return &emptypb.Empty{}, nil
+	}
+
+	deletedAt := utils.Now()
+	err = s.messageStore.DeleteMessage(ctx, message.Id, deletedAt)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not delete message: %v", err)
+	}
+	message.DeletedAt = &deletedAt
+
+	err = s.produce(message, pb.EventType_MESSAGE_DELETED, roomUserIds)
+	if err != nil {
+		logrus.Errorf("could not send message to event bus: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// UploadAttachment
+//
+// Stores file uploaded in chunks after request with attachment info.
+// Uploaded attachment is visible to uploader only until it is sent with a
+// message to the same room. Attachment which is not sent in time is deleted,
+// user may keep maxUnsentAttachments of them at once.
+func (s *ApiServer) UploadAttachment(stream pb.ApiService_UploadAttachmentServer) error {
+	ctx := stream.Context()
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return err
+	}
+
+	uploaderId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	info := req.GetInfo()
+	if info == nil {
+		return status.Error(codes.InvalidArgument, "first request must carry attachment info")
+	}
+
+	fileNameLength := utf8.RuneCountInString(info.FileName)
+	if fileNameLength == 0 || fileNameLength > maxFileNameLength {
+		return status.Errorf(codes.InvalidArgument, "file name must be from 1 to %d characters long", maxFileNameLength)
+	}
+
+	roomId, err := uuid.Parse(info.RoomId)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	roomUserIds, err := s.roomStore.UsersInRoom(ctx, roomId)
+	if err != nil {
+		return status.Error(codes.NotFound, "")
+	}
+
+	if !utils.ArrayContains(roomUserIds, uploaderId) {
+		return status.Error(codes.PermissionDenied, "")
+	}
+
+	unsent, err := s.attachmentStore.CountUnsent(ctx, uploaderId)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not count attachments: %v", err)
+	}
+	if unsent >= maxUnsentAttachments {
+		return status.Errorf(codes.ResourceExhausted, "at most %d attachments may be uploaded without being sent", maxUnsentAttachments)
+	}
+
+	content := bufio.NewReaderSize(&attachmentReader{stream: stream}, sniffLength)
+	head, err := content.Peek(sniffLength)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	attachment := model.NewAttachment(uploaderId, roomId, info.FileName)
+	attachment.MimeType = attachmentType(head, info.MimeType)
+	if !allowedAttachmentTypes[attachment.MimeType] {
+		return status.Errorf(codes.InvalidArgument, "attachments of type %s are not allowed", attachment.MimeType)
+	}
+
+	// One byte over limit is read to tell too big file apart from file of exactly max size
+	hash := sha256.New()
+	attachment.Size, err = s.blobStore.Put(ctx, attachment.BlobKey(), io.TeeReader(io.LimitReader(content, maxAttachmentSize+1), hash))
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.Internal, "could not store attachment: %v", err)
+	}
+
+	err = s.describeAttachment(ctx, attachment)
+	if err == nil {
+		attachment.Sha256 = hex.EncodeToString(hash.Sum(nil))
+		err = s.attachmentStore.Add(ctx, attachment)
+		if err != nil {
+			err = status.Errorf(codes.Internal, "could not add attachment: %v", err)
+		}
+	}
+	if err != nil {
+		if e := s.blobStore.Delete(ctx, attachment.BlobKey()); e != nil {
+			logrus.Errorf("could not delete attachment %s: %v", attachment.Id, e)
+		}
+		return err
+	}
+
+	return stream.SendAndClose(attachment.ToPbAttachment())
+}
+
+// DownloadAttachment
+//
+// Streams attachment info followed by its content to members of room it
+// was sent to.
+func (s *ApiServer) DownloadAttachment(req *pb.DownloadAttachmentRequest, stream pb.ApiService_DownloadAttachmentServer) error {
+	ctx := stream.Context()
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	attachmentId, err := uuid.Parse(req.AttachmentId)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	attachment, err := s.attachmentStore.Get(ctx, attachmentId)
+	if err != nil {
+		return status.Error(codes.NotFound, "attachment not found")
+	}
+
+	// Attachment which is not sent yet is known to uploader only
+	if !attachment.MessageId.Valid && attachment.UploaderId != userId {
+		return status.Error(codes.NotFound, "attachment not found")
+	}
+
+	roomUserIds, err := s.roomStore.UsersInRoom(ctx, attachment.RoomId)
+	if err != nil {
+		return status.Error(codes.NotFound, "")
+	}
+
+	if !utils.ArrayContains(roomUserIds, userId) {
+		return status.Error(codes.PermissionDenied, "")
+	}
+
+	if attachment.MessageId.Valid {
+		message, err := s.messageStore.GetMessage(ctx, attachment.MessageId.UUID)
+		if err != nil || message.IsDeleted() {
+			return status.Error(codes.NotFound, "attachment not found")
+		}
+	}
+
+	blob, err := s.blobStore.Get(ctx, attachment.BlobKey())
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not read attachment: %v", err)
+	}
+	defer blob.Close()
+
+	err = stream.Send(&pb.DownloadAttachmentResponse{
+		Data: &pb.DownloadAttachmentResponse_Attachment{Attachment: attachment.ToPbAttachment()},
+	})
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, attachmentChunkSize)
+	for {
+		n, err := io.ReadFull(blob, chunk)
+		if n > 0 {
+			e := stream.Send(&pb.DownloadAttachmentResponse{
+				Data: &pb.DownloadAttachmentResponse_Chunk{Chunk: chunk[:n]},
+			})
+			if e != nil {
+				return e
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "could not read attachment: %v", err)
+		}
+	}
+}
+
+// describeAttachment checks size of stored attachment and reads dimensions
+// of images.
+func (s *ApiServer) describeAttachment(ctx context.Context, attachment *model.Attachment) error {
+	if attachment.Size == 0 {
+		return status.Error(codes.InvalidArgument, "attachment is empty")
+	}
+
+	if attachment.Size > maxAttachmentSize {
+		return status.Errorf(codes.InvalidArgument, "attachment cannot be bigger than %d bytes", maxAttachmentSize)
+	}
+
+	if attachment.MimeType == "image/webp" || !strings.HasPrefix(attachment.MimeType, "image/") {
+		return nil
+	}
+
+	blob, err := s.blobStore.Get(ctx, attachment.BlobKey())
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not read attachment: %v", err)
+	}
+	defer blob.Close()
+
+	config, _, err := image.DecodeConfig(blob)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "could not decode image: %v", err)
+	}
+
+	attachment.Width = config.Width
+	attachment.Height = config.Height
+
+	return nil
+}
+
+// messageAttachments loads attachments to be sent with [message]. They
+// must be uploaded by sender of message to its room and not sent yet.
+func (s *ApiServer) messageAttachments(ctx context.Context, message *model.Message, rawIds []string) ([]model.Attachment, error) {
+	if len(rawIds) > maxMessageAttachments {
+		return nil, status.Errorf(codes.InvalidArgument, "message cannot have more than %d attachments", maxMessageAttachments)
+	}
+
+	ids, err := utils.StringSliceToUUIDSlice(rawIds...)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	stored, err := s.attachmentStore.GetMany(ctx, ids...)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get attachments: %v", err)
+	}
+
+	attachmentsById := map[uuid.UUID]model.Attachment{}
+	for _, attachment := range stored {
+		attachmentsById[attachment.Id] = attachment
+	}
+
+	attachments := []model.Attachment{}
+	for _, id := range ids {
+		attachment, ok := attachmentsById[id]
+		if !ok || attachment.UploaderId != message.UserId || attachment.RoomId != message.RoomId {
+			return nil, status.Errorf(codes.InvalidArgument, "attachment %s not found", id)
+		}
+
+		if attachment.MessageId.Valid {
+			return nil, status.Errorf(codes.FailedPrecondition, "attachment %s is sent already", id)
+		}
+
+		if utils.ArrayContains(attachments, attachment) {
+			continue
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+// AddReaction
+//
+// Reacts to message with emoji on behalf of room member. Every member of
+// room receives REACTION_ADDED event unless caller has reacted with the
+// same emoji already.
+func (s *ApiServer) AddReaction(ctx context.Context, req *pb.ReactionRequest) (*emptypb.Empty, error) {
+	reaction, roomUserIds, err := s.reaction(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := s.messageStore.AddReaction(ctx, reaction)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not add reaction: %v", err)
+	}
+
+	if added {
+		s.publishReaction(reaction, pb.EventType_REACTION_ADDED, roomUserIds)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// RemoveReaction
+//
+// Removes reaction of caller. Every member of room receives REACTION_REMOVED
+// event if reaction existed.
+func (s *ApiServer) RemoveReaction(ctx context.Context, req *pb.ReactionRequest) (*emptypb.Empty, error) {
+	reaction, roomUserIds, err := s.reaction(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := s.messageStore.RemoveReaction(ctx, reaction)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not remove reaction: %v", err)
+	}
+
+	if removed {
+		s.publishReaction(reaction, pb.EventType_REACTION_REMOVED, roomUserIds)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// reaction validates reaction request and ensures caller is member of room
+// message belongs to.
+func (s *ApiServer) reaction(ctx context.Context, req *pb.ReactionRequest) (*model.Reaction, []uuid.UUID, error) {
+	emojiLength := utf8.RuneCountInString(req.Emoji)
+	if emojiLength == 0 || emojiLength > model.MaxEmojiLength || strings.TrimSpace(req.Emoji) != req.Emoji {
+		return nil, nil, status.Error(codes.InvalidArgument, "invalid emoji")
+	}
+
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	message, err := s.getMessage(ctx, req.MessageId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roomUserIds, err := s.roomStore.UsersInRoom(ctx, message.RoomId)
+	if err != nil {
+		return nil, nil, status.Error(codes.NotFound, "")
+	}
+
+	if !utils.ArrayContains(roomUserIds, userId) {
+		return nil, nil, status.Error(codes.PermissionDenied, "")
+	}
+
+	if message.IsDeleted() {
+		return nil, nil, status.Error(codes.FailedPrecondition, "cannot react to deleted message")
+	}
+
+	return model.NewReaction(userId, message, req.Emoji), roomUserIds, nil
+}
+
+func (s *ApiServer) publishReaction(reaction *model.Reaction, eventType pb.EventType, userIds []uuid.UUID) {
+	err := s.eventProducer.Produce(&pb.MessageDelivery{
+		UserIds:   uuidsToStrings(userIds),
+		EventType: eventType,
+		Reaction:  reaction.ToPbReaction(),
+	})
+	if err != nil {
+		logrus.Errorf("could not send reaction to event bus: %v", err)
+	}
+}
+
+// withDetails attaches attachments and reaction counts to [messages] as
+// seen by [userId].
+func (s *ApiServer) withDetails(ctx context.Context, userId uuid.UUID, messages []model.Message) error {
+	messageIds := []uuid.UUID{}
+	for _, message := range messages {
+		messageIds = append(messageIds, message.Id)
+	}
+
+	attachments, err := s.attachmentStore.ListByMessages(ctx, messageIds...)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not list attachments: %v", err)
+	}
+
+	attachmentsByMessage := map[uuid.UUID][]model.Attachment{}
+	for _, attachment := range attachments {
+		attachmentsByMessage[attachment.MessageId.UUID] = append(attachmentsByMessage[attachment.MessageId.UUID], attachment)
+	}
+
+	counts, err := s.messageStore.ReactionCounts(ctx, userId, messageIds...)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not count reactions: %v", err)
+	}
+
+	countsByMessage := map[uuid.UUID][]model.ReactionCount{}
+	for _, count := range counts {
+		countsByMessage[count.MessageId] = append(countsByMessage[count.MessageId], count)
+	}
+
+	for i := range messages {
+		messages[i].Attachments = attachmentsByMessage[messages[i].Id]
+		messages[i].Reactions = countsByMessage[messages[i].Id]
+	}
+
+	return nil
+}
+
+// MarkRead
+//
+// Moves read marker of caller in room to the given message. Other members
+// receive MESSAGE_READ event when marker moves forward.
+func (s *ApiServer) MarkRead(ctx context.Context, req *pb.MarkReadRequest) (*emptypb.Empty, error) {
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	roomId, err := uuid.Parse(req.RoomId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	roomUserIds, err := s.roomStore.UsersInRoom(ctx, roomId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "")
+	}
+
+	if !utils.ArrayContains(roomUserIds, userId) {
+		return nil, status.Error(codes.PermissionDenied, "")
+	}
+
+	message, err := s.getMessage(ctx, req.MessageId)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.RoomId != roomId {
+		return nil, status.Error(codes.InvalidArgument, "message does not belong to room")
+	}
+
+	marker := model.NewReadMarker(userId, message)
+	moved, err := s.roomStore.MarkRead(ctx, marker)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not mark message as read: %v", err)
+	}
+
+	if moved {
+		recipientUserIds := []string{}
+		for _, id := range roomUserIds {
+			recipientUserIds = append(recipientUserIds, id.String())
+		}
+
+		err = s.eventProducer.Produce(&pb.MessageDelivery{
+			UserIds:     recipientUserIds,
+			EventType:   pb.EventType_MESSAGE_READ,
+			ReadReceipt: marker.ToPbReadReceipt(),
+		})
+		if err != nil {
+			logrus.Errorf("could not send read receipt to event bus: %v", err)
+		}
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// SetTyping
+//
+// Publishes typing indicator of caller to other members of room. Indicator
+// is not persisted, message_service stops it after service.TypingTimeout
+// unless client refreshes it.
+func (s *ApiServer) SetTyping(ctx context.Context, req *pb.SetTypingRequest) (*emptypb.Empty, error) {
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	roomId, err := uuid.Parse(req.RoomId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	roomUserIds, err := s.roomStore.UsersInRoom(ctx, roomId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "")
+	}
+
+	if !utils.ArrayContains(roomUserIds, userId) {
+		return nil, status.Error(codes.PermissionDenied, "")
+	}
+
+	typing := &pb.TypingIndicator{
+		RoomId: roomId.String(),
+		UserId: userId.String(),
+	}
+	eventType := pb.EventType_TYPING_STOPPED
+	if req.Typing {
+		eventType = pb.EventType_TYPING_STARTED
+		typing.ExpiresAt = timestamppb.New(utils.Now().Add(service.TypingTimeout))
+	}
+
+	recipientUserIds := []string{}
+	for _, id := range roomUserIds {
+		recipientUserIds = append(recipientUserIds, id.String())
+	}
+
+	err = s.eventProducer.Produce(&pb.MessageDelivery{
+		UserIds:   recipientUserIds,
+		EventType: eventType,
+		Typing:    typing,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "could not publish typing indicator: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// AddMembers
+//
+// Adds users to group room on behalf of its member. Users who are members
+// already are skipped, new ones join with member role.
+func (s *ApiServer) AddMembers(ctx context.Context, req *pb.AddMembersRequest) (*pb.MembersResponse, error) {
+	membership, err := s.membership(ctx, req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	actorId := membership.actor.UserId
+
+	userIds, err := utils.StringSliceToUUIDSlice(req.UserIds...)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	newMemberIds := []uuid.UUID{}
+	for _, userId := range userIds {
+		if membership.member(userId) == nil && !utils.ArrayContains(newMemberIds, userId) {
+			newMemberIds = append(newMemberIds, userId)
+		}
+	}
+
+	if len(newMemberIds) > 0 {
+		usernames, err := s.usernames(ctx, append([]uuid.UUID{actorId}, newMemberIds...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		addedUsernames := []string{}
+		for _, userId := range newMemberIds {
+			addedUsernames = append(addedUsernames, usernames[userId])
+		}
+
+		text := fmt.Sprintf("%s added %s", usernames[actorId], strings.Join(addedUsernames, ", "))
+		message := model.NewSystemMessage(actorId, membership.roomId, text)
+		err = s.roomStore.AddMembers(ctx, membership.roomId, newMemberIds, message)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not add members: %v", err)
+		}
+
+		for _, userId := range newMemberIds {
+			membership.members = append(membership.members, model.RoomMember{
+				RoomId: membership.roomId,
+				UserId: userId,
+				Role:   model.ROOM_MEMBER_ROLE,
+			})
+		}
+		s.publishMembershipChange(message, membership.userIds(), &pb.MembershipChange{
+			AddedUserIds: uuidsToStrings(newMemberIds),
+		})
+	}
+
+	return membership.toPbMembersResponse(), nil
+}
+
+// RemoveMember
+//
+// Removes other member from group room. Owner removes anyone, moderators
+// remove members only. Members leave room themselves with LeaveRoom.
+func (s *ApiServer) RemoveMember(ctx context.Context, req *pb.RemoveMemberRequest) (*emptypb.Empty, error) {
+	membership, err := s.membership(ctx, req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	actorId := membership.actor.UserId
+
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	if userId == actorId {
+		return nil, status.Error(codes.InvalidArgument, "use LeaveRoom to leave room")
+	}
+
+	target := membership.member(userId)
+	if target == nil {
+		return nil, status.Error(codes.NotFound, "user is not a member of room")
+	}
+
+	if !membership.actor.CanModerate() || !membership.actor.Outranks(target) {
+		return nil, status.Error(codes.PermissionDenied, "not allowed to remove this member")
+	}
+
+	usernames, err := s.usernames(ctx, actorId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("%s removed %s", usernames[actorId], usernames[userId])
+	err = s.removeMember(ctx, membership, userId, model.NewSystemMessage(actorId, membership.roomId, text))
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// LeaveRoom
+//
+// Removes caller from group room. Owner has to transfer ownership before
+// leaving unless they are the last member.
+func (s *ApiServer) LeaveRoom(ctx context.Context, req *pb.LeaveRoomRequest) (*emptypb.Empty, error) {
+	membership, err := s.membership(ctx, req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	actorId := membership.actor.UserId
+
+	if membership.actor.Role == model.ROOM_OWNER_ROLE && len(membership.members) > 1 {
+		return nil, status.Error(codes.FailedPrecondition, "owner must transfer ownership before leaving")
+	}
+
+	usernames, err := s.usernames(ctx, actorId)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("%s left the room", usernames[actorId])
+	err = s.removeMember(ctx, membership, actorId, model.NewSystemMessage(actorId, membership.roomId, text))
+	if err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// RenameRoom
+//
+// Changes name of group room. Allowed to owner and moderators.
+func (s *ApiServer) RenameRoom(ctx context.Context, req *pb.RenameRoomRequest) (*emptypb.Empty, error) {
+	nameLength := utf8.RuneCountInString(req.Name)
+	if nameLength == 0 || nameLength > maxRoomNameLength {
+		return nil, status.Errorf(codes.InvalidArgument, "name must be from 1 to %d characters long", maxRoomNameLength)
+	}
+
+	membership, err := s.membership(ctx, req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	actorId := membership.actor.UserId
+
+	if !membership.actor.CanModerate() {
+		return nil, status.Error(codes.PermissionDenied, "only owner and moderators can rename room")
+	}
+
+	usernames, err := s.usernames(ctx, actorId)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("%s renamed the room to %s", usernames[actorId], req.Name)
+	message := model.NewSystemMessage(actorId, membership.roomId, text)
+	err = s.roomStore.Rename(ctx, membership.roomId, req.Name, message)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not rename room: %v", err)
+	}
+
+	err = s.produce(message, pb.EventType_MESSAGE_CREATED, membership.userIds())
+	if err != nil {
+		logrus.Errorf("could not send message to event bus: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// SetMemberRole
+//
+// Promotes member to moderator or demotes moderator to member. Allowed to
+// owner only.
+func (s *ApiServer) SetMemberRole(ctx context.Context, req *pb.SetMemberRoleRequest) (*emptypb.Empty, error) {
+	if req.Role != model.ROOM_MODERATOR_ROLE && req.Role != model.ROOM_MEMBER_ROLE {
+		return nil, status.Error(codes.InvalidArgument, "role must be either moderator or member")
+	}
+
+	membership, target, err := s.ownerAction(ctx, req.RoomId, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	actorId := membership.actor.UserId
+
+	usernames, err := s.usernames(ctx, actorId, target.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("%s made %s %s", usernames[actorId], usernames[target.UserId], req.Role)
+	message := model.NewSystemMessage(actorId, membership.roomId, text)
+	err = s.roomStore.SetMemberRole(ctx, membership.roomId, target.UserId, req.Role, message)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not change role: %v", err)
+	}
+
+	s.publishMembershipChange(message, membership.userIds(), &pb.MembershipChange{
+		ChangedRoles: []*pb.RoomMember{
+			{UserId: target.UserId.String(), Role: req.Role},
+		},
+	})
+
+	return &emptypb.Empty{}, nil
+}
+
+// TransferOwnership
+//
+// Makes other member owner of room. Previous owner becomes moderator.
+func (s *ApiServer) TransferOwnership(ctx context.Context, req *pb.TransferOwnershipRequest) (*emptypb.Empty, error) {
+	membership, target, err := s.ownerAction(ctx, req.RoomId, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	actorId := membership.actor.UserId
+
+	usernames, err := s.usernames(ctx, actorId, target.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("%s transferred ownership to %s", usernames[actorId], usernames[target.UserId])
+	message := model.NewSystemMessage(actorId, membership.roomId, text)
+	err = s.roomStore.TransferOwnership(ctx, membership.roomId, actorId, target.UserId, message)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not transfer ownership: %v", err)
+	}
+
+	s.publishMembershipChange(message, membership.userIds(), &pb.MembershipChange{
+		ChangedRoles: []*pb.RoomMember{
+			{UserId: actorId.String(), Role: model.ROOM_MODERATOR_ROLE},
+			{UserId: target.UserId.String(), Role: model.ROOM_OWNER_ROLE},
+		},
+	})
+
+	return &emptypb.Empty{}, nil
+}
+
+// CreateInvite
+//
+// Creates invite link to group room. Zero max uses allows unlimited joins,
+// missing expiry makes invite valid until revoked. Allowed to owner and
+// moderators.
+func (s *ApiServer) CreateInvite(ctx context.Context, req *pb.CreateInviteRequest) (*pb.Invite, error) {
+	if req.MaxUses < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max uses cannot be negative")
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		t := req.ExpiresAt.AsTime()
+		if !t.After(utils.Now()) {
+			return nil, status.Error(codes.InvalidArgument, "expiry must be in the future")
+		}
+		expiresAt = &t
+	}
+
+	membership, err := s.membership(ctx, req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !membership.actor.CanModerate() {
+		return nil, status.Error(codes.PermissionDenied, "only owner and moderators can manage invites")
+	}
+
+	invite, err := model.NewInvite(membership.roomId, membership.actor.UserId, int(req.MaxUses), expiresAt)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not generate invite: %v", err)
+	}
+
+	err = s.inviteStore.Add(ctx, invite)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not add invite: %v", err)
+	}
+
+	return invite.ToPbInvite(), nil
+}
+
+// ListInvites
+//
+// Lists invites to group room including revoked and expired ones. Allowed
+// to owner and moderators.
+func (s *ApiServer) ListInvites(ctx context.Context, req *pb.ListInvitesRequest) (*pb.ListInvitesResponse, error) {
+	membership, err := s.membership(ctx, req.RoomId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !membership.actor.CanModerate() {
+		return nil, status.Error(codes.PermissionDenied, "only owner and moderators can manage invites")
+	}
+
+	invites, err := s.inviteStore.ListByRoom(ctx, membership.roomId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not list invites: %v", err)
+	}
+
+	pbInvites := []*pb.Invite{}
+	for _, invite := range invites {
+		pbInvites = append(pbInvites, invite.ToPbInvite())
+	}
+
+	return &pb.ListInvitesResponse{
+		Invites: pbInvites,
+	}, nil
+}
+
+// RevokeInvite
+//
+// Makes invite unusable. Allowed to owner and moderators of invite's room.
+func (s *ApiServer) RevokeInvite(ctx context.Context, req *pb.RevokeInviteRequest) (*emptypb.Empty, error) {
+	invite, err := s.inviteStore.Get(ctx, req.Code)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "invite not found")
+	}
+
+	membership, err := s.membership(ctx, invite.RoomId.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if !membership.actor.CanModerate() {
+		return nil, status.Error(codes.PermissionDenied, "only owner and moderators can manage invites")
+	}
+
+	err = s.inviteStore.Revoke(ctx, invite.Code, utils.Now())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not revoke invite: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// JoinByInvite
+//
+// Adds caller to room of invite as member. Invite is not used up when
+// caller is a member already, including when the same caller joins
+// concurrently: only the call which actually adds caller announces it.
+func (s *ApiServer) JoinByInvite(ctx context.Context, req *pb.JoinByInviteRequest) (*pb.MembersResponse, error) {
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	invite, err := s.inviteStore.Get(ctx, req.Code)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "invite not found")
+	}
+
+	if !invite.IsUsable(utils.Now()) {
+		return nil, status.Error(codes.FailedPrecondition, "invite is revoked, expired or used up")
+	}
+
+	members, err := s.roomStore.Members(ctx, invite.RoomId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get members: %v", err)
+	}
+
+	membership := &roomMembership{
+		roomId:  invite.RoomId,
+		members: members,
+	}
+	if membership.member(userId) != nil {
+		return membership.toPbMembersResponse(), nil
+	}
+
+	usernames, err := s.usernames(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("%s joined via invite link", usernames[userId])
+	message := model.NewSystemMessage(userId, invite.RoomId, text)
+	joined, err := s.inviteStore.Join(ctx, invite.Code, userId, message)
+	if errors.Is(err, repository.ErrInviteUnusable) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not join room: %v", err)
+	}
+
+	if !joined {
+		// Joined concurrently by another call, which has announced it
+		members, err := s.roomStore.Members(ctx, invite.RoomId)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not get members: %v", err)
+		}
+		membership.members = members
+
+		return membership.toPbMembersResponse(), nil
+	}
+
+	membership.members = append(membership.members, model.RoomMember{
+		RoomId: invite.RoomId,
+		UserId: userId,
+		Role:   model.ROOM_MEMBER_ROLE,
+	})
+	s.publishMembershipChange(message, membership.userIds(), &pb.MembershipChange{
+		AddedUserIds: []string{userId.String()},
+	})
+
+	return membership.toPbMembersResponse(), nil
+}
+
+// roomMembership is caller of membership changing RPC together with every
+// member of the room.
+type roomMembership struct {
+	roomId  uuid.UUID
+	actor   *model.RoomMember
+	members []model.RoomMember
+}
+
+func (m *roomMembership) member(userId uuid.UUID) *model.RoomMember {
+	for i := range m.members {
+		if m.members[i].UserId == userId {
+			return &m.members[i]
+		}
+	}
+
+	return nil
+}
+
+func (m *roomMembership) userIds() []uuid.UUID {
+	userIds := []uuid.UUID{}
+	for _, member := range m.members {
+		userIds = append(userIds, member.UserId)
+	}
+
+	return userIds
+}
+
+func (m *roomMembership) toPbMembersResponse() *pb.MembersResponse {
+	pbMembers := []*pb.RoomMember{}
+	for _, member := range m.members {
+		pbMembers = append(pbMembers, member.ToPbRoomMember())
+	}
+
+	return &pb.MembersResponse{
+		RoomId:  m.roomId.String(),
+		UserIds: uuidsToStrings(m.userIds()),
+		Members: pbMembers,
+	}
+}
+
+// membership loads members of group room and ensures caller is one of them.
+func (s *ApiServer) membership(ctx context.Context, rawRoomId string) (*roomMembership, error) {
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	actorId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	roomId, err := uuid.Parse(rawRoomId)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	room, err := s.roomStore.Get(ctx, roomId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "")
+	}
+
+	if room.DialogRoom {
+		return nil, status.Error(codes.FailedPrecondition, "members of dialog room cannot be changed")
+	}
+
+	members, err := s.roomStore.Members(ctx, roomId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "")
+	}
+
+	membership := &roomMembership{
+		roomId:  roomId,
+		members: members,
+	}
+	membership.actor = membership.member(actorId)
+	if membership.actor == nil {
+		return nil, status.Error(codes.PermissionDenied, "")
+	}
+
+	return membership, nil
+}
+
+// ownerAction authorizes action of room owner on other member [rawUserId].
+func (s *ApiServer) ownerAction(ctx context.Context, rawRoomId, rawUserId string) (*roomMembership, *model.RoomMember, error) {
+	userId, err := uuid.Parse(rawUserId)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	membership, err := s.membership(ctx, rawRoomId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if membership.actor.Role != model.ROOM_OWNER_ROLE {
+		return nil, nil, status.Error(codes.PermissionDenied, "only owner can change roles")
+	}
+
+	if userId == membership.actor.UserId {
+		return nil, nil, status.Error(codes.InvalidArgument, "owner cannot change own role")
+	}
+
+	target := membership.member(userId)
+	if target == nil {
+		return nil, nil, status.Error(codes.NotFound, "user is not a member of room")
+	}
+
+	return membership, target, nil
+}
+
+func (s *ApiServer) removeMember(ctx context.Context, membership *roomMembership, userId uuid.UUID, message *model.Message) error {
+	err := s.roomStore.RemoveMember(ctx, membership.roomId, userId, message)
+	if status.Code(err) == codes.NotFound {
+		return err
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not remove member: %v", err)
+	}
+
+	// Removed user is notified as well, so their clients drop the room
+	s.publishMembershipChange(message, membership.userIds(), &pb.MembershipChange{
+		RemovedUserIds: []string{userId.String()},
+	})
+
+	return nil
+}
+
+// canModerate reports whether user is owner or moderator of room.
+func (s *ApiServer) canModerate(ctx context.Context, roomId, userId uuid.UUID) (bool, error) {
+	members, err := s.roomStore.Members(ctx, roomId)
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "could not get members: %v", err)
+	}
+
+	for _, member := range members {
+		if member.UserId == userId {
+			return member.CanModerate(), nil
+		}
+	}
+
+	return false, nil
+}
+
+// publishMembershipChange delivers system [message] and membership change
+// event to [userIds].
+func (s *ApiServer) publishMembershipChange(message *model.Message, userIds []uuid.UUID, change *pb.MembershipChange) {
+	err := s.produce(message, pb.EventType_MESSAGE_CREATED, userIds)
+	if err != nil {
+		logrus.Errorf("could not send message to event bus: %v", err)
+	}
+
+	change.RoomId = message.RoomId.String()
+	change.ActorId = message.UserId.String()
+	err = s.eventProducer.Produce(&pb.MessageDelivery{
+		UserIds:    uuidsToStrings(userIds),
+		EventType:  pb.EventType_MEMBERS_CHANGED,
+		Membership: change,
+	})
+	if err != nil {
+		logrus.Errorf("could not send membership change to event bus: %v", err)
+	}
+}
+
+// usernames maps ids of users to their usernames.
+func (s *ApiServer) usernames(ctx context.Context, userIds ...uuid.UUID) (map[uuid.UUID]string, error) {
+	users, err := s.roomStore.FindByIds(ctx, userIds...)
+	if status.Code(err) == codes.InvalidArgument {
+		return nil, err
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not find users: %v", err)
+	}
+
+	usernames := map[uuid.UUID]string{}
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
+
+	return usernames, nil
+}
+
+func (s *ApiServer) getMessage(ctx context.Context, id string) (*model.Message, error) {
+	messageId, err := uuid.Parse(id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	message, err := s.messageStore.GetMessage(ctx, messageId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "message not found")
+	}
+
+	return message, nil
+}
+
+func (s *ApiServer) produce(message *model.Message, eventType pb.EventType, userIds []uuid.UUID) error {
+	return s.eventProducer.Produce(&pb.MessageDelivery{
+		Message:   message.ToPbMessage(),
+		UserIds:   uuidsToStrings(userIds),
+		EventType: eventType,
+	})
+}
+
+func uuidsToStrings(ids []uuid.UUID) []string {
+	res := []string{}
+	for _, id := range ids {
+		res = append(res, id.String())
+	}
+
+	return res
+}
+
+// sniffLength is how much of content http.DetectContentType considers.
+const sniffLength = 512
+
+// attachmentType detects mime type of attachment from its first bytes and
+// falls back to type declared by client if content is not recognized.
+func attachmentType(head []byte, declared string) string {
+	detected, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err == nil && detected != "application/octet-stream" {
+		return detected
+	}
+
+	declared, _, err = mime.ParseMediaType(declared)
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	return declared
+}
+
+// attachmentReader reads content of attachment from upload stream.
+type attachmentReader struct {
+	stream pb.ApiService_UploadAttachmentServer
+	chunk  []byte
+}
+
+func (r *attachmentReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		req, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+
+		chunk, ok := req.Data.(*pb.UploadAttachmentRequest_Chunk)
+		if !ok {
+			return 0, status.Error(codes.InvalidArgument, "attachment info must be sent once")
+		}
+		r.chunk = chunk.Chunk
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+
+	return n, nil
+}
@@ -1,12 +1,23 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
+	"image"
+	"image/png"
+	"io"
 	"testing"
+	"time"
 
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
 	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/repository"
 	proto "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/service"
 	"github.com/ArtyomArtamonov/msg/internal/utils"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
@@ -14,6 +25,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
@@ -29,7 +41,7 @@ func TestApiServer_CreateRoomSuccess(t *testing.T) {
 
 	expectedClaimsResult := &model.UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			Id: "7815f165-6e48-452d-8fe9-b4075b35e194",
+			Subject: "7815f165-6e48-452d-8fe9-b4075b35e194",
 		},
 	}
 	expectedResponse := proto.CreateRoomStatus{
@@ -52,7 +64,7 @@ func TestApiServer_CreateRoomSuccess(t *testing.T) {
 	assert.Equal(t, expectedResponse.Name, res.Name)
 	assert.Equal(t, expectedResponse.Name, res.Name)
 	assert.Contains(t, res.Users, createRoomRequest.UserIds[0])
-	assert.Contains(t, res.Users, expectedClaimsResult.Id)
+	assert.Contains(t, res.Users, expectedClaimsResult.Subject)
 }
 
 func TestApiServer_ListRoomsFailsIfPageSizeExceedsLimit(t *testing.T) {
@@ -94,7 +106,7 @@ func TestApiServer_ListRoomsFailsIfInvalidUserId(t *testing.T) {
 	ctx := context.TODO()
 	userClaims := &model.UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			Id: "",
+			Subject: "",
 		},
 	}
 	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
@@ -120,7 +132,7 @@ func TestApiServer_ListRoomsFailsIfDatabaseFailsWithNoPageToken(t *testing.T) {
 	pageSize := 100
 	userClaims := &model.UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			Id: userId.String(),
+			Subject: userId.String(),
 		},
 		Username: "",
 		Role:     model.USER_ROLE,
@@ -142,22 +154,24 @@ func TestApiServer_ListRoomsFailsIfDatabaseFailsWithNoPageToken(t *testing.T) {
 func TestApiServer_ListRoomsFailsIfDatabaseFailsWithPageTokenPresent(t *testing.T) {
 	setupTest()
 
-	token := utils.EncodePageToken(utils.Now())
-	lastMessageTime, _ := utils.DecodePageToken(token)
 	expectedError := errors.New("some_error")
 
 	ctx := context.TODO()
 	userId := uuid.New()
+	roomId := uuid.New()
 	pageSize := 100
+	lastMessageTime := utils.Now().UTC().Truncate(time.Microsecond)
+	filter := model.NewCursorFilter("rooms", userId.String())
+	token := cursorCodec.Encode(model.NewCursor(filter, lastMessageTime.UnixMicro(), roomId, model.PAGE_OLDER))
 	userClaims := &model.UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			Id: userId.String(),
+			Subject: userId.String(),
 		},
 		Username: "",
 		Role:     model.USER_ROLE,
 	}
 	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
-	roomStoreMock.On("ListRooms", mock.Anything, userId, *lastMessageTime, pageSize).Return(nil, expectedError)
+	roomStoreMock.On("ListRooms", mock.Anything, userId, lastMessageTime, roomId, pageSize).Return(nil, expectedError)
 
 	res, err := apiServer.ListRooms(
 		ctx,
@@ -182,7 +196,7 @@ func TestApiServer_ListRoomsFailsIfInvalidPageToken(t *testing.T) {
 	pageSize := 100
 	userClaims := &model.UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			Id: userId.String(),
+			Subject: userId.String(),
 		},
 		Username: "",
 		Role:     model.USER_ROLE,
@@ -201,7 +215,37 @@ func TestApiServer_ListRoomsFailsIfInvalidPageToken(t *testing.T) {
 	)
 
 	assert.Nil(t, res)
-	assert.ErrorIs(t, err, status.Errorf(codes.InvalidArgument, "cannot parse next token: %v", "illegal base64 data at input byte 4"))
+	assert.ErrorIs(t, err, status.Errorf(codes.InvalidArgument, "cannot parse next token: %v", service.ErrInvalidCursor))
+}
+
+func TestApiServer_ListRoomsFailsIfPageTokenIsOfAnotherUser(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	filter := model.NewCursorFilter("rooms", uuid.New().String())
+	token := cursorCodec.Encode(model.NewCursor(filter, utils.Now().UnixMicro(), uuid.New(), model.PAGE_OLDER))
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+
+	res, err := apiServer.ListRooms(
+		ctx,
+		&proto.ListRoomsRequest{
+			NextToken: &wrapperspb.StringValue{
+				Value: token,
+			},
+			PageSize: 10,
+		},
+	)
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Errorf(codes.InvalidArgument, "cannot parse next token: %v", service.ErrInvalidCursor))
+	roomStoreMock.AssertNotCalled(t, "ListRooms", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestApiServer_ListRoomsSuccess(t *testing.T) {
@@ -212,7 +256,7 @@ func TestApiServer_ListRoomsSuccess(t *testing.T) {
 	pageSize := 2
 	userClaims := &model.UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			Id: userId.String(),
+			Subject: userId.String(),
 		},
 		Username: "",
 		Role:     model.USER_ROLE,
@@ -231,9 +275,10 @@ func TestApiServer_ListRoomsSuccess(t *testing.T) {
 		},
 	)
 
+	filter := model.NewCursorFilter("rooms", userId.String())
 	nextToken := res1.NextToken.Value
-	lastMessageTime, _ := utils.DecodePageToken(nextToken)
-	roomStoreMock.On("ListRooms", mock.Anything, userId, *lastMessageTime, pageSize).Return([]model.Room{
+	cursor, _ := cursorCodec.Decode(nextToken, filter)
+	roomStoreMock.On("ListRooms", mock.Anything, userId, time.UnixMicro(cursor.SortKey).UTC(), room.Id, pageSize).Return([]model.Room{
 		room,
 	}, nil)
 
@@ -267,8 +312,2068 @@ func TestApiServer_ListRoomsSuccess(t *testing.T) {
 			Rooms: []*proto.Room{
 				room.PbRoom(),
 			},
+			PrevToken: &wrapperspb.StringValue{
+				Value: cursorCodec.Encode(model.NewCursor(filter, room.LastMessageTime.UnixMicro(), room.Id, model.PAGE_NEWER)),
+			},
 		},
 		res2,
 	)
 	assert.Nil(t, err)
 }
+
+func TestApiServer_EditMessageSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	otherUserId := uuid.New()
+	message := model.NewMessage(userId, uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	messageStoreMock.On("EditMessage", ctx, message.Id, "edited", utils.Now()).Return(nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId, otherUserId}, nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MESSAGE_EDITED &&
+			delivery.Message.Text == "edited" &&
+			len(delivery.UserIds) == 2
+	})).Return(nil)
+
+	res, err := apiServer.EditMessage(ctx, &proto.EditMessageRequest{
+		MessageId: message.Id.String(),
+		Text:      "edited",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "edited", res.Message.Text)
+	assert.NotNil(t, res.Message.EditedAt)
+	messageStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertExpectations(t)
+}
+
+func TestApiServer_EditMessageFailsIfNotAuthor(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId, message.UserId}, nil)
+
+	res, err := apiServer.EditMessage(ctx, &proto.EditMessageRequest{
+		MessageId: message.Id.String(),
+		Text:      "edited",
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, "only author can edit message"))
+	messageStoreMock.AssertNotCalled(t, "EditMessage", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApiServer_EditMessageFailsIfNotMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(userId, uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	// Author has left the room
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{uuid.New()}, nil)
+
+	res, err := apiServer.EditMessage(ctx, &proto.EditMessageRequest{
+		MessageId: message.Id.String(),
+		Text:      "edited",
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, ""))
+	messageStoreMock.AssertNotCalled(t, "EditMessage", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_EditMessageFailsIfDeleted(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(userId, uuid.New(), "")
+	deletedAt := utils.Now()
+	message.DeletedAt = &deletedAt
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId}, nil)
+
+	res, err := apiServer.EditMessage(ctx, &proto.EditMessageRequest{
+		MessageId: message.Id.String(),
+		Text:      "edited",
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.FailedPrecondition, "message is deleted"))
+}
+
+func TestApiServer_EditMessageFailsIfDeletedConcurrently(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(userId, uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId}, nil)
+	messageStoreMock.On("EditMessage", ctx, message.Id, "edited", utils.Now()).Return(repository.ErrMessageDeleted)
+
+	res, err := apiServer.EditMessage(ctx, &proto.EditMessageRequest{
+		MessageId: message.Id.String(),
+		Text:      "edited",
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.FailedPrecondition, "message is deleted"))
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_DeleteMessageForEveryoneSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(userId, uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	messageStoreMock.On("DeleteMessage", ctx, message.Id, utils.Now()).Return(nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId}, nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MESSAGE_DELETED &&
+			delivery.Message.Text == "" &&
+			delivery.Message.DeletedAt != nil
+	})).Return(nil)
+
+	res, err := apiServer.DeleteMessage(ctx, &proto.DeleteMessageRequest{
+		MessageId:   message.Id.String(),
+		ForEveryone: true,
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	messageStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertExpectations(t)
+}
+
+func TestApiServer_DeleteMessageForEveryoneFailsIfNotAuthor(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId, message.UserId}, nil)
+	roomStoreMock.On("Members", ctx, message.RoomId).Return(roomMembers(message.RoomId, message.UserId, userId), nil)
+
+	res, err := apiServer.DeleteMessage(ctx, &proto.DeleteMessageRequest{
+		MessageId:   message.Id.String(),
+		ForEveryone: true,
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, "only author can delete message for everyone"))
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_DeleteMessageForMeSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	messageStoreMock.On("HideMessage", ctx, message.Id, userId).Return(nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId, message.UserId}, nil)
+
+	res, err := apiServer.DeleteMessage(ctx, &proto.DeleteMessageRequest{
+		MessageId: message.Id.String(),
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	messageStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_MarkReadSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	otherUserId := uuid.New()
+	message := model.NewMessage(otherUserId, uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	expectedMarker := model.NewReadMarker(userId, message)
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId, otherUserId}, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("MarkRead", ctx, expectedMarker).Return(true, nil)
+	eventProducerMock.On("Produce", &proto.MessageDelivery{
+		UserIds:     []string{userId.String(), otherUserId.String()},
+		EventType:   proto.EventType_MESSAGE_READ,
+		ReadReceipt: expectedMarker.ToPbReadReceipt(),
+	}).Return(nil)
+
+	res, err := apiServer.MarkRead(ctx, &proto.MarkReadRequest{
+		RoomId:    message.RoomId.String(),
+		MessageId: message.Id.String(),
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	roomStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertExpectations(t)
+}
+
+func TestApiServer_MarkReadDoesNotPublishIfMarkerDidNotMove(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId, message.UserId}, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("MarkRead", ctx, model.NewReadMarker(userId, message)).Return(false, nil)
+
+	res, err := apiServer.MarkRead(ctx, &proto.MarkReadRequest{
+		RoomId:    message.RoomId.String(),
+		MessageId: message.Id.String(),
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_MarkReadFailsIfMessageIsFromAnotherRoom(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	roomId := uuid.New()
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{userId}, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+
+	res, err := apiServer.MarkRead(ctx, &proto.MarkReadRequest{
+		RoomId:    roomId.String(),
+		MessageId: message.Id.String(),
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.InvalidArgument, "message does not belong to room"))
+	roomStoreMock.AssertNotCalled(t, "MarkRead", mock.Anything, mock.Anything)
+}
+
+func TestApiServer_SetTypingSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	otherUserId := uuid.New()
+	roomId := uuid.New()
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{userId, otherUserId}, nil)
+	eventProducerMock.On("Produce", &proto.MessageDelivery{
+		UserIds:   []string{userId.String(), otherUserId.String()},
+		EventType: proto.EventType_TYPING_STARTED,
+		Typing: &proto.TypingIndicator{
+			RoomId:    roomId.String(),
+			UserId:    userId.String(),
+			ExpiresAt: timestamppb.New(utils.Now().Add(service.TypingTimeout)),
+		},
+	}).Return(nil)
+
+	res, err := apiServer.SetTyping(ctx, &proto.SetTypingRequest{
+		RoomId: roomId.String(),
+		Typing: true,
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	eventProducerMock.AssertExpectations(t)
+	messageStoreMock.AssertNotCalled(t, "SendMessage", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApiServer_SetTypingFailsIfNotRoomMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	roomId := uuid.New()
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uuid.New().String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{uuid.New()}, nil)
+
+	res, err := apiServer.SetTyping(ctx, &proto.SetTypingRequest{
+		RoomId: roomId.String(),
+		Typing: true,
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, ""))
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_AddMembersSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	memberId := uuid.New()
+	newMemberId := uuid.New()
+	room := model.NewRoom("room", false, actorId, memberId)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, actorId, memberId), nil)
+	roomStoreMock.On("FindByIds", ctx, []uuid.UUID{actorId, newMemberId}).Return([]model.User{
+		{Id: actorId, Username: "alice"},
+		{Id: newMemberId, Username: "bob"},
+	}, nil)
+	roomStoreMock.On("AddMembers", ctx, room.Id, []uuid.UUID{newMemberId}, mock.MatchedBy(func(message *model.Message) bool {
+		return message.System && message.Text == "alice added bob" && message.UserId == actorId
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MESSAGE_CREATED && delivery.Message.System
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MEMBERS_CHANGED &&
+			len(delivery.UserIds) == 3 &&
+			delivery.Membership.AddedUserIds[0] == newMemberId.String()
+	})).Return(nil)
+
+	res, err := apiServer.AddMembers(ctx, &proto.AddMembersRequest{
+		RoomId: room.Id.String(),
+		// Already member is skipped
+		UserIds: []string{memberId.String(), newMemberId.String()},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{actorId.String(), memberId.String(), newMemberId.String()}, res.UserIds)
+	roomStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertExpectations(t)
+}
+
+func TestApiServer_AddMembersFailsForDialogRoom(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	room := model.NewRoom("", true, actorId, uuid.New())
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+
+	res, err := apiServer.AddMembers(ctx, &proto.AddMembersRequest{
+		RoomId:  room.Id.String(),
+		UserIds: []string{uuid.New().String()},
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.FailedPrecondition, "members of dialog room cannot be changed"))
+}
+
+func TestApiServer_RemoveMemberSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	memberId := uuid.New()
+	room := model.NewRoom("room", false, actorId, memberId)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, actorId, memberId), nil)
+	roomStoreMock.On("FindByIds", ctx, []uuid.UUID{actorId, memberId}).Return([]model.User{
+		{Id: actorId, Username: "alice"},
+		{Id: memberId, Username: "bob"},
+	}, nil)
+	roomStoreMock.On("RemoveMember", ctx, room.Id, memberId, mock.MatchedBy(func(message *model.Message) bool {
+		return message.System && message.Text == "alice removed bob"
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MESSAGE_CREATED
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MEMBERS_CHANGED &&
+			utils.ArrayContains(delivery.UserIds, memberId.String()) &&
+			delivery.Membership.RemovedUserIds[0] == memberId.String()
+	})).Return(nil)
+
+	res, err := apiServer.RemoveMember(ctx, &proto.RemoveMemberRequest{
+		RoomId: room.Id.String(),
+		UserId: memberId.String(),
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	roomStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertExpectations(t)
+}
+
+func TestApiServer_RemoveMemberFailsIfNotMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	room := model.NewRoom("room", false, actorId)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, actorId), nil)
+
+	res, err := apiServer.RemoveMember(ctx, &proto.RemoveMemberRequest{
+		RoomId: room.Id.String(),
+		UserId: uuid.New().String(),
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.NotFound, "user is not a member of room"))
+}
+
+func TestApiServer_LeaveRoomSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	room := model.NewRoom("room", false, actorId)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, actorId), nil)
+	roomStoreMock.On("FindByIds", ctx, []uuid.UUID{actorId}).Return([]model.User{
+		{Id: actorId, Username: "alice"},
+	}, nil)
+	roomStoreMock.On("RemoveMember", ctx, room.Id, actorId, mock.MatchedBy(func(message *model.Message) bool {
+		return message.System && message.Text == "alice left the room"
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.Anything).Return(nil)
+
+	res, err := apiServer.LeaveRoom(ctx, &proto.LeaveRoomRequest{
+		RoomId: room.Id.String(),
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	roomStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertNumberOfCalls(t, "Produce", 2)
+}
+
+// roomMembers makes [ownerId] owner of room and every other user its member.
+func roomMembers(roomId, ownerId uuid.UUID, userIds ...uuid.UUID) []model.RoomMember {
+	members := []model.RoomMember{
+		{RoomId: roomId, UserId: ownerId, Role: model.ROOM_OWNER_ROLE},
+	}
+	for _, userId := range userIds {
+		members = append(members, model.RoomMember{RoomId: roomId, UserId: userId, Role: model.ROOM_MEMBER_ROLE})
+	}
+
+	return members
+}
+
+func TestApiServer_DeleteMessageForEveryoneByModeratorSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	moderatorId := uuid.New()
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	members := roomMembers(message.RoomId, uuid.New(), message.UserId, moderatorId)
+	members[2].Role = model.ROOM_MODERATOR_ROLE
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: moderatorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{message.UserId, moderatorId}, nil)
+	roomStoreMock.On("Members", ctx, message.RoomId).Return(members, nil)
+	messageStoreMock.On("DeleteMessage", ctx, message.Id, utils.Now()).Return(nil)
+	eventProducerMock.On("Produce", mock.Anything).Return(nil)
+
+	res, err := apiServer.DeleteMessage(ctx, &proto.DeleteMessageRequest{
+		MessageId:   message.Id.String(),
+		ForEveryone: true,
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	messageStoreMock.AssertExpectations(t)
+}
+
+func TestApiServer_RemoveMemberFailsIfNotModerator(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	memberId := uuid.New()
+	room := model.NewRoom("room", false)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, uuid.New(), actorId, memberId), nil)
+
+	res, err := apiServer.RemoveMember(ctx, &proto.RemoveMemberRequest{
+		RoomId: room.Id.String(),
+		UserId: memberId.String(),
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, "not allowed to remove this member"))
+	roomStoreMock.AssertNotCalled(t, "RemoveMember", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApiServer_LeaveRoomFailsIfOwnerHasNotTransferredOwnership(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	room := model.NewRoom("room", false)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, actorId, uuid.New()), nil)
+
+	res, err := apiServer.LeaveRoom(ctx, &proto.LeaveRoomRequest{
+		RoomId: room.Id.String(),
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.FailedPrecondition, "owner must transfer ownership before leaving"))
+}
+
+func TestApiServer_RenameRoomSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	room := model.NewRoom("room", false)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, actorId), nil)
+	roomStoreMock.On("FindByIds", ctx, []uuid.UUID{actorId}).Return([]model.User{
+		{Id: actorId, Username: "alice"},
+	}, nil)
+	roomStoreMock.On("Rename", ctx, room.Id, "new name", mock.MatchedBy(func(message *model.Message) bool {
+		return message.System && message.Text == "alice renamed the room to new name"
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.Anything).Return(nil)
+
+	res, err := apiServer.RenameRoom(ctx, &proto.RenameRoomRequest{
+		RoomId: room.Id.String(),
+		Name:   "new name",
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	roomStoreMock.AssertExpectations(t)
+}
+
+func TestApiServer_RenameRoomFailsIfMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	room := model.NewRoom("room", false)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, uuid.New(), actorId), nil)
+
+	res, err := apiServer.RenameRoom(ctx, &proto.RenameRoomRequest{
+		RoomId: room.Id.String(),
+		Name:   "new name",
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, "only owner and moderators can rename room"))
+}
+
+func TestApiServer_SetMemberRoleSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	memberId := uuid.New()
+	room := model.NewRoom("room", false)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, actorId, memberId), nil)
+	roomStoreMock.On("FindByIds", ctx, []uuid.UUID{actorId, memberId}).Return([]model.User{
+		{Id: actorId, Username: "alice"},
+		{Id: memberId, Username: "bob"},
+	}, nil)
+	roomStoreMock.On("SetMemberRole", ctx, room.Id, memberId, model.ROOM_MODERATOR_ROLE, mock.MatchedBy(func(message *model.Message) bool {
+		return message.System && message.Text == "alice made bob moderator"
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MESSAGE_CREATED
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MEMBERS_CHANGED &&
+			delivery.Membership.ChangedRoles[0].UserId == memberId.String() &&
+			delivery.Membership.ChangedRoles[0].Role == model.ROOM_MODERATOR_ROLE
+	})).Return(nil)
+
+	res, err := apiServer.SetMemberRole(ctx, &proto.SetMemberRoleRequest{
+		RoomId: room.Id.String(),
+		UserId: memberId.String(),
+		Role:   model.ROOM_MODERATOR_ROLE,
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	roomStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertExpectations(t)
+}
+
+func TestApiServer_SetMemberRoleFailsIfNotOwner(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	memberId := uuid.New()
+	room := model.NewRoom("room", false)
+	members := roomMembers(room.Id, uuid.New(), actorId, memberId)
+	members[1].Role = model.ROOM_MODERATOR_ROLE
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(members, nil)
+
+	res, err := apiServer.SetMemberRole(ctx, &proto.SetMemberRoleRequest{
+		RoomId: room.Id.String(),
+		UserId: memberId.String(),
+		Role:   model.ROOM_MODERATOR_ROLE,
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, "only owner can change roles"))
+}
+
+func TestApiServer_TransferOwnershipSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	memberId := uuid.New()
+	room := model.NewRoom("room", false)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, actorId, memberId), nil)
+	roomStoreMock.On("FindByIds", ctx, []uuid.UUID{actorId, memberId}).Return([]model.User{
+		{Id: actorId, Username: "alice"},
+		{Id: memberId, Username: "bob"},
+	}, nil)
+	roomStoreMock.On("TransferOwnership", ctx, room.Id, actorId, memberId, mock.MatchedBy(func(message *model.Message) bool {
+		return message.System && message.Text == "alice transferred ownership to bob"
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.Anything).Return(nil)
+
+	res, err := apiServer.TransferOwnership(ctx, &proto.TransferOwnershipRequest{
+		RoomId: room.Id.String(),
+		UserId: memberId.String(),
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	roomStoreMock.AssertExpectations(t)
+}
+
+func TestApiServer_CreateInviteSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	actorId := uuid.New()
+	room := model.NewRoom("room", false)
+	expiresAt := utils.Now().Add(time.Hour)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: actorId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, actorId), nil)
+	inviteStoreMock.On("Add", ctx, mock.MatchedBy(func(invite *model.Invite) bool {
+		return invite.RoomId == room.Id && invite.CreatedBy == actorId && invite.MaxUses == 5 &&
+			invite.ExpiresAt.Equal(expiresAt) && invite.Code != ""
+	})).Return(nil)
+
+	res, err := apiServer.CreateInvite(ctx, &proto.CreateInviteRequest{
+		RoomId:    room.Id.String(),
+		MaxUses:   5,
+		ExpiresAt: timestamppb.New(expiresAt),
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, room.Id.String(), res.RoomId)
+	assert.Equal(t, int32(5), res.MaxUses)
+	inviteStoreMock.AssertExpectations(t)
+}
+
+func TestApiServer_CreateInviteFailsIfMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	memberId := uuid.New()
+	room := model.NewRoom("room", false)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: memberId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("Get", ctx, room.Id).Return(room, nil)
+	roomStoreMock.On("Members", ctx, room.Id).Return(roomMembers(room.Id, uuid.New(), memberId), nil)
+
+	res, err := apiServer.CreateInvite(ctx, &proto.CreateInviteRequest{
+		RoomId: room.Id.String(),
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, "only owner and moderators can manage invites"))
+	inviteStoreMock.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+}
+
+func TestApiServer_JoinByInviteSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	ownerId := uuid.New()
+	userId := uuid.New()
+	invite, _ := model.NewInvite(uuid.New(), ownerId, 1, nil)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	inviteStoreMock.On("Get", ctx, invite.Code).Return(invite, nil)
+	roomStoreMock.On("Members", ctx, invite.RoomId).Return(roomMembers(invite.RoomId, ownerId), nil)
+	roomStoreMock.On("FindByIds", ctx, []uuid.UUID{userId}).Return([]model.User{
+		{Id: userId, Username: "bob"},
+	}, nil)
+	inviteStoreMock.On("Join", ctx, invite.Code, userId, mock.MatchedBy(func(message *model.Message) bool {
+		return message.System && message.Text == "bob joined via invite link" && message.UserId == userId
+	})).Return(true, nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MESSAGE_CREATED && delivery.Message.System
+	})).Return(nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_MEMBERS_CHANGED &&
+			len(delivery.UserIds) == 2 &&
+			delivery.Membership.AddedUserIds[0] == userId.String()
+	})).Return(nil)
+
+	res, err := apiServer.JoinByInvite(ctx, &proto.JoinByInviteRequest{
+		Code: invite.Code,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ownerId.String(), userId.String()}, res.UserIds)
+	roomStoreMock.AssertExpectations(t)
+	inviteStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertExpectations(t)
+}
+
+func TestApiServer_JoinByInviteDoesNotUseInviteIfAlreadyMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	ownerId := uuid.New()
+	userId := uuid.New()
+	invite, _ := model.NewInvite(uuid.New(), ownerId, 1, nil)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	inviteStoreMock.On("Get", ctx, invite.Code).Return(invite, nil)
+	roomStoreMock.On("Members", ctx, invite.RoomId).Return(roomMembers(invite.RoomId, ownerId, userId), nil)
+
+	res, err := apiServer.JoinByInvite(ctx, &proto.JoinByInviteRequest{
+		Code: invite.Code,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ownerId.String(), userId.String()}, res.UserIds)
+	inviteStoreMock.AssertNotCalled(t, "Join", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApiServer_JoinByInviteDoesNotAnnounceIfJoinedConcurrently(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	ownerId := uuid.New()
+	userId := uuid.New()
+	invite, _ := model.NewInvite(uuid.New(), ownerId, 0, nil)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	inviteStoreMock.On("Get", ctx, invite.Code).Return(invite, nil)
+	roomStoreMock.On("Members", ctx, invite.RoomId).Return(roomMembers(invite.RoomId, ownerId), nil).Once()
+	roomStoreMock.On("FindByIds", ctx, []uuid.UUID{userId}).Return([]model.User{
+		{Id: userId, Username: "bob"},
+	}, nil)
+	inviteStoreMock.On("Join", ctx, invite.Code, userId, mock.Anything).Return(false, nil)
+	roomStoreMock.On("Members", ctx, invite.RoomId).Return(roomMembers(invite.RoomId, ownerId, userId), nil).Once()
+
+	res, err := apiServer.JoinByInvite(ctx, &proto.JoinByInviteRequest{
+		Code: invite.Code,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{ownerId.String(), userId.String()}, res.UserIds)
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_JoinByInviteFailsIfExpired(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	expiresAt := utils.Now().Add(-time.Minute)
+	invite, _ := model.NewInvite(uuid.New(), uuid.New(), 0, &expiresAt)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uuid.New().String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	inviteStoreMock.On("Get", ctx, invite.Code).Return(invite, nil)
+
+	res, err := apiServer.JoinByInvite(ctx, &proto.JoinByInviteRequest{
+		Code: invite.Code,
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.FailedPrecondition, "invite is revoked, expired or used up"))
+	inviteStoreMock.AssertNotCalled(t, "Join", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApiServer_JoinByInviteFailsIfRevoked(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	revokedAt := utils.Now()
+	invite, _ := model.NewInvite(uuid.New(), uuid.New(), 0, nil)
+	invite.RevokedAt = &revokedAt
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uuid.New().String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	inviteStoreMock.On("Get", ctx, invite.Code).Return(invite, nil)
+
+	res, err := apiServer.JoinByInvite(ctx, &proto.JoinByInviteRequest{
+		Code: invite.Code,
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.FailedPrecondition, "invite is revoked, expired or used up"))
+}
+
+func TestApiServer_SendMessageToUserCreatesDialogRoom(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	recipientId := uuid.New()
+	room := model.NewRoom("", true, senderId, recipientId)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("AddAndSendMessage", ctx, mock.Anything, mock.MatchedBy(func(message *model.Message) bool {
+		return message.Text == "text"
+	}), []uuid.UUID{recipientId}).Return(room, nil)
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Message:   "text",
+		Recipient: &proto.MessageRequest_UserId{UserId: recipientId.String()},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, room.Id.String(), res.RoomId)
+	roomStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+	outboxNotifierMock.AssertCalled(t, "Notify")
+}
+
+func TestApiServer_SendMessageToUserFailsIfRoomStoreFails(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	recipientId := uuid.New()
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("AddAndSendMessage", ctx, mock.Anything, mock.Anything, []uuid.UUID{recipientId}).Return(nil, errors.New("db is down"))
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Message:   "text",
+		Recipient: &proto.MessageRequest_UserId{UserId: recipientId.String()},
+	})
+
+	assert.Nil(t, res)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	outboxNotifierMock.AssertNotCalled(t, "Notify")
+}
+
+func TestApiServer_SendMessageToUserConcurrentRetryReturnsOriginalMessage(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	recipientId := uuid.New()
+	clientMessageId := "key-1"
+	original := model.NewMessage(senderId, uuid.New(), "text")
+	original.ClientMessageId = &clientMessageId
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	// Other request creates dialog room with message between lookup and insert
+	messageStoreMock.On("GetMessageByClientId", ctx, senderId, clientMessageId).Return(nil, sql.ErrNoRows).Once()
+	messageStoreMock.On("GetMessageByClientId", ctx, senderId, clientMessageId).Return(original, nil)
+	roomStoreMock.On("AddAndSendMessage", ctx, mock.Anything, mock.Anything, []uuid.UUID{recipientId}).Return(nil, repository.ErrDuplicateMessage)
+	attachmentStoreMock.On("ListByMessages", ctx, mock.Anything).Return([]model.Attachment{}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, senderId, mock.Anything).Return([]model.ReactionCount{}, nil)
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Message:         "text",
+		Recipient:       &proto.MessageRequest_UserId{UserId: recipientId.String()},
+		ClientMessageId: clientMessageId,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, original.Id.String(), res.Message.Id)
+	assert.Equal(t, original.RoomId.String(), res.RoomId)
+	outboxNotifierMock.AssertNotCalled(t, "Notify")
+}
+
+func TestApiServer_SendMessageReplySuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	root := model.NewMessage(uuid.New(), uuid.New(), "root")
+	parent := model.NewMessage(uuid.New(), root.RoomId, "reply")
+	parent.ReplyTo(root)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, root.RoomId).Return([]uuid.UUID{senderId, root.UserId}, nil)
+	messageStoreMock.On("GetMessage", ctx, parent.Id).Return(parent, nil)
+	messageStoreMock.On("SendMessage", ctx, mock.MatchedBy(func(message *model.Message) bool {
+		return message.ReplyToId.UUID == parent.Id && message.ThreadRootId.UUID == root.Id
+	}), []uuid.UUID{senderId, root.UserId}).Return(nil)
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Message:          "text",
+		Recipient:        &proto.MessageRequest_RoomId{RoomId: root.RoomId.String()},
+		ReplyToMessageId: parent.Id.String(),
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, parent.Id.String(), res.Message.ReplyToId)
+	assert.Equal(t, root.Id.String(), res.Message.ThreadRootId)
+	messageStoreMock.AssertExpectations(t)
+	outboxNotifierMock.AssertCalled(t, "Notify")
+}
+
+func TestApiServer_SendMessageReplyFailsIfParentInOtherRoom(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	roomId := uuid.New()
+	parent := model.NewMessage(senderId, uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{senderId}, nil)
+	messageStoreMock.On("GetMessage", ctx, parent.Id).Return(parent, nil)
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Message:          "text",
+		Recipient:        &proto.MessageRequest_RoomId{RoomId: roomId.String()},
+		ReplyToMessageId: parent.Id.String(),
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.InvalidArgument, "cannot reply to message from other room"))
+	messageStoreMock.AssertNotCalled(t, "SendMessage", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApiServer_ListThreadSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	root := model.NewMessage(userId, uuid.New(), "root")
+	root.ReplyCount = 2
+	replies := []model.Message{
+		*model.NewMessage(uuid.New(), root.RoomId, "second"),
+		*model.NewMessage(uuid.New(), root.RoomId, "first"),
+	}
+	for i := range replies {
+		replies[i].ReplyTo(root)
+		replies[i].Seq = int64(len(replies) - i + 1)
+	}
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	// Thread is found by any of its replies
+	messageStoreMock.On("GetMessage", ctx, replies[0].Id).Return(&replies[0], nil)
+	messageStoreMock.On("GetMessage", ctx, root.Id).Return(root, nil)
+	roomStoreMock.On("UsersInRoom", ctx, root.RoomId).Return([]uuid.UUID{userId}, nil)
+	messageStoreMock.On("ListThreadFirst", ctx, root.Id, userId, 2).Return(replies, nil)
+	attachmentStoreMock.On("ListByMessages", ctx, mock.Anything).Return([]model.Attachment{}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, userId, []uuid.UUID{root.Id, replies[0].Id, replies[1].Id}).Return([]model.ReactionCount{
+		{MessageId: root.Id, Emoji: "👍", Count: 3, Reacted: true},
+	}, nil)
+
+	res, err := apiServer.ListThread(ctx, &proto.ListThreadRequest{
+		MessageId: replies[0].Id.String(),
+		PageSize:  2,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, root.Id.String(), res.Root.Id)
+	assert.Equal(t, uint32(2), res.Root.ReplyCount)
+	assert.Equal(t, "👍", res.Root.Reactions[0].Emoji)
+	assert.Empty(t, res.Messages[0].Reactions)
+	assert.Len(t, res.Messages, 2)
+	filter := model.NewCursorFilter("thread", root.Id.String(), userId.String())
+	assert.Equal(t, cursorCodec.Encode(model.NewCursor(filter, replies[1].Seq, replies[1].Id, model.PAGE_OLDER)), res.NextToken.Value)
+}
+
+func TestApiServer_ListThreadFailsIfNotRoomMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	root := model.NewMessage(uuid.New(), uuid.New(), "root")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uuid.New().String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, root.Id).Return(root, nil)
+	roomStoreMock.On("UsersInRoom", ctx, root.RoomId).Return([]uuid.UUID{root.UserId}, nil)
+
+	res, err := apiServer.ListThread(ctx, &proto.ListThreadRequest{
+		MessageId: root.Id.String(),
+		PageSize:  10,
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, ""))
+	messageStoreMock.AssertNotCalled(t, "ListThreadFirst", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApiServer_AddReactionSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId, message.UserId}, nil)
+	messageStoreMock.On("AddReaction", ctx, mock.MatchedBy(func(reaction *model.Reaction) bool {
+		return reaction.MessageId == message.Id && reaction.UserId == userId && reaction.Emoji == "🎉"
+	})).Return(true, nil)
+	eventProducerMock.On("Produce", mock.MatchedBy(func(delivery *proto.MessageDelivery) bool {
+		return delivery.EventType == proto.EventType_REACTION_ADDED &&
+			len(delivery.UserIds) == 2 &&
+			delivery.Reaction.RoomId == message.RoomId.String() &&
+			delivery.Reaction.Emoji == "🎉"
+	})).Return(nil)
+
+	res, err := apiServer.AddReaction(ctx, &proto.ReactionRequest{
+		MessageId: message.Id.String(),
+		Emoji:     "🎉",
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	messageStoreMock.AssertExpectations(t)
+	eventProducerMock.AssertExpectations(t)
+}
+
+func TestApiServer_AddReactionTwiceDoesNotPublish(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(userId, uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{userId}, nil)
+	messageStoreMock.On("AddReaction", ctx, mock.Anything).Return(false, nil)
+
+	res, err := apiServer.AddReaction(ctx, &proto.ReactionRequest{
+		MessageId: message.Id.String(),
+		Emoji:     "🎉",
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_RemoveReactionFailsIfNotRoomMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uuid.New().String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{message.UserId}, nil)
+
+	res, err := apiServer.RemoveReaction(ctx, &proto.ReactionRequest{
+		MessageId: message.Id.String(),
+		Emoji:     "🎉",
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, ""))
+	messageStoreMock.AssertNotCalled(t, "RemoveReaction", mock.Anything, mock.Anything)
+}
+
+func TestApiServer_ListMessagesWithReactionsAndAttachments(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	roomId := uuid.New()
+	messages := []model.Message{
+		*model.NewMessage(userId, roomId, "second"),
+		*model.NewMessage(userId, roomId, "first"),
+	}
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{userId}, nil)
+	messageStoreMock.On("ListMessagesFirst", ctx, roomId, userId, 10).Return(messages, nil)
+	attachment := model.NewAttachment(userId, roomId, "notes.txt")
+	attachment.MessageId = uuid.NullUUID{UUID: messages[0].Id, Valid: true}
+	attachmentStoreMock.On("ListByMessages", ctx, []uuid.UUID{messages[0].Id, messages[1].Id}).Return([]model.Attachment{*attachment}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, userId, []uuid.UUID{messages[0].Id, messages[1].Id}).Return([]model.ReactionCount{
+		{MessageId: messages[1].Id, Emoji: "👍", Count: 2},
+		{MessageId: messages[1].Id, Emoji: "🎉", Count: 1, Reacted: true},
+	}, nil)
+
+	res, err := apiServer.ListMessages(ctx, &proto.ListMessagesRequest{
+		ChatId:   roomId.String(),
+		PageSize: 10,
+	})
+
+	assert.Nil(t, err)
+	assert.Empty(t, res.Messages[0].Reactions)
+	assert.Equal(t, attachment.Id.String(), res.Messages[0].Attachments[0].Id)
+	assert.Empty(t, res.Messages[1].Attachments)
+	assert.Equal(t, []*proto.ReactionCount{
+		{Emoji: "👍", Count: 2},
+		{Emoji: "🎉", Count: 1, Reacted: true},
+	}, res.Messages[1].Reactions)
+}
+
+func TestApiServer_ListMessagesPagesOlder(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	roomId := uuid.New()
+	messages := []model.Message{
+		*model.NewMessage(userId, roomId, "fourth"),
+		*model.NewMessage(userId, roomId, "third"),
+	}
+	messages[0].Seq = 4
+	messages[1].Seq = 3
+	filter := model.NewCursorFilter("messages", roomId.String(), userId.String())
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{userId}, nil)
+	messageStoreMock.On("ListMessages", ctx, roomId, userId, int64(5), 2).Return(messages, nil)
+	attachmentStoreMock.On("ListByMessages", ctx, mock.Anything).Return([]model.Attachment{}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, userId, mock.Anything).Return([]model.ReactionCount{}, nil)
+
+	res, err := apiServer.ListMessages(ctx, &proto.ListMessagesRequest{
+		ChatId:    roomId.String(),
+		PageSize:  2,
+		NextToken: &wrapperspb.StringValue{Value: cursorCodec.Encode(model.NewCursor(filter, 5, uuid.New(), model.PAGE_OLDER))},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), res.Messages[0].Seq)
+	assert.Equal(t, cursorCodec.Encode(model.NewCursor(filter, 3, messages[1].Id, model.PAGE_OLDER)), res.NextToken.Value)
+	assert.Equal(t, cursorCodec.Encode(model.NewCursor(filter, 4, messages[0].Id, model.PAGE_NEWER)), res.PrevToken.Value)
+}
+
+func TestApiServer_ListMessagesPagesNewer(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	roomId := uuid.New()
+	// Newer messages are listed nearest first
+	messages := []model.Message{
+		*model.NewMessage(userId, roomId, "sixth"),
+		*model.NewMessage(userId, roomId, "seventh"),
+	}
+	messages[0].Seq = 6
+	messages[1].Seq = 7
+	filter := model.NewCursorFilter("messages", roomId.String(), userId.String())
+	expectedNextToken := cursorCodec.Encode(model.NewCursor(filter, 6, messages[0].Id, model.PAGE_OLDER))
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{userId}, nil)
+	messageStoreMock.On("ListMessagesNewer", ctx, roomId, userId, int64(5), 3).Return(messages, nil)
+	attachmentStoreMock.On("ListByMessages", ctx, mock.Anything).Return([]model.Attachment{}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, userId, mock.Anything).Return([]model.ReactionCount{}, nil)
+
+	res, err := apiServer.ListMessages(ctx, &proto.ListMessagesRequest{
+		ChatId:    roomId.String(),
+		PageSize:  3,
+		NextToken: &wrapperspb.StringValue{Value: cursorCodec.Encode(model.NewCursor(filter, 5, uuid.New(), model.PAGE_NEWER))},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{7, 6}, []int64{res.Messages[0].Seq, res.Messages[1].Seq})
+	assert.Equal(t, expectedNextToken, res.NextToken.Value)
+	// Page is not full, so there are no newer messages yet
+	assert.Nil(t, res.PrevToken)
+}
+
+func TestApiServer_ListMessagesAroundMessage(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	roomId := uuid.New()
+	messages := make([]model.Message, 4)
+	for i := range messages {
+		messages[i] = *model.NewMessage(userId, roomId, "text")
+		messages[i].Seq = int64(i + 1)
+	}
+	target := messages[2]
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{userId}, nil)
+	messageStoreMock.On("GetMessage", ctx, target.Id).Return(&target, nil)
+	messageStoreMock.On("ListMessagesNewer", ctx, roomId, userId, int64(3), 2).Return([]model.Message{messages[3]}, nil)
+	messageStoreMock.On("ListMessages", ctx, roomId, userId, int64(4), 3).Return([]model.Message{messages[2], messages[1], messages[0]}, nil)
+	attachmentStoreMock.On("ListByMessages", ctx, mock.Anything).Return([]model.Attachment{}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, userId, mock.Anything).Return([]model.ReactionCount{}, nil)
+
+	res, err := apiServer.ListMessages(ctx, &proto.ListMessagesRequest{
+		ChatId:          roomId.String(),
+		PageSize:        5,
+		AroundMessageId: target.Id.String(),
+	})
+
+	assert.Nil(t, err)
+	seqs := []int64{}
+	for _, message := range res.Messages {
+		seqs = append(seqs, message.Seq)
+	}
+	assert.Equal(t, []int64{4, 3, 2, 1}, seqs)
+	filter := model.NewCursorFilter("messages", roomId.String(), userId.String())
+	assert.Equal(t, cursorCodec.Encode(model.NewCursor(filter, 1, messages[0].Id, model.PAGE_OLDER)), res.NextToken.Value)
+	assert.Nil(t, res.PrevToken)
+}
+
+func TestApiServer_ListMessagesFailsIfPageTokenIsOfAnotherRoom(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	roomId := uuid.New()
+	filter := model.NewCursorFilter("messages", uuid.New().String(), userId.String())
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{userId}, nil)
+
+	res, err := apiServer.ListMessages(ctx, &proto.ListMessagesRequest{
+		ChatId:    roomId.String(),
+		PageSize:  2,
+		NextToken: &wrapperspb.StringValue{Value: cursorCodec.Encode(model.NewCursor(filter, 5, uuid.New(), model.PAGE_OLDER))},
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Errorf(codes.InvalidArgument, "cannot parse next token: %v", service.ErrInvalidCursor))
+	messageStoreMock.AssertNotCalled(t, "ListMessages", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func newUploadStreamMock(ctx context.Context, requests ...*proto.UploadAttachmentRequest) *mocks.UploadAttachmentServerMock {
+	stream := new(mocks.UploadAttachmentServerMock)
+	stream.On("Context").Return(ctx)
+	for _, req := range requests {
+		stream.On("Recv").Return(req, nil).Once()
+	}
+	stream.On("Recv").Return(nil, io.EOF)
+
+	return stream
+}
+
+func TestApiServer_UploadAttachmentSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	uploaderId := uuid.New()
+	roomId := uuid.New()
+	var content bytes.Buffer
+	err := png.Encode(&content, image.NewRGBA(image.Rect(0, 0, 3, 2)))
+	assert.Nil(t, err)
+	sum := sha256.Sum256(content.Bytes())
+
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uploaderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{uploaderId}, nil)
+	attachmentStoreMock.On("CountUnsent", ctx, uploaderId).Return(0, nil)
+	blobStoreMock.On("Put", ctx, mock.Anything, content.Bytes()).Return(content.Len(), nil)
+	blobStoreMock.On("Get", ctx, mock.Anything).Return(io.NopCloser(bytes.NewReader(content.Bytes())), nil)
+	attachmentStoreMock.On("Add", ctx, mock.MatchedBy(func(attachment *model.Attachment) bool {
+		return attachment.RoomId == roomId &&
+			attachment.UploaderId == uploaderId &&
+			attachment.FileName == "pixel.png" &&
+			attachment.MimeType == "image/png" &&
+			attachment.Size == int64(content.Len()) &&
+			attachment.Sha256 == hex.EncodeToString(sum[:]) &&
+			attachment.Width == 3 && attachment.Height == 2
+	})).Return(nil)
+
+	half := content.Len() / 2
+	stream := newUploadStreamMock(ctx,
+		// Declared type is ignored, content is recognized
+		&proto.UploadAttachmentRequest{Data: &proto.UploadAttachmentRequest_Info{Info: &proto.AttachmentInfo{
+			RoomId:   roomId.String(),
+			FileName: "pixel.png",
+			MimeType: "text/plain",
+		}}},
+		&proto.UploadAttachmentRequest{Data: &proto.UploadAttachmentRequest_Chunk{Chunk: content.Bytes()[:half]}},
+		&proto.UploadAttachmentRequest{Data: &proto.UploadAttachmentRequest_Chunk{Chunk: content.Bytes()[half:]}},
+	)
+	stream.On("SendAndClose", mock.MatchedBy(func(attachment *proto.Attachment) bool {
+		return attachment.MimeType == "image/png" && attachment.Width == 3 && attachment.MessageId == ""
+	})).Return(nil)
+
+	err = apiServer.UploadAttachment(stream)
+
+	assert.Nil(t, err)
+	attachmentStoreMock.AssertExpectations(t)
+	stream.AssertExpectations(t)
+}
+
+func TestApiServer_UploadAttachmentFailsForNotAllowedType(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	uploaderId := uuid.New()
+	roomId := uuid.New()
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uploaderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{uploaderId}, nil)
+	attachmentStoreMock.On("CountUnsent", ctx, uploaderId).Return(0, nil)
+
+	stream := newUploadStreamMock(ctx,
+		&proto.UploadAttachmentRequest{Data: &proto.UploadAttachmentRequest_Info{Info: &proto.AttachmentInfo{
+			RoomId:   roomId.String(),
+			FileName: "page.html",
+			MimeType: "image/png",
+		}}},
+		&proto.UploadAttachmentRequest{Data: &proto.UploadAttachmentRequest_Chunk{Chunk: []byte("<!DOCTYPE html><html></html>")}},
+	)
+
+	err := apiServer.UploadAttachment(stream)
+
+	assert.ErrorIs(t, err, status.Error(codes.InvalidArgument, "attachments of type text/html are not allowed"))
+	blobStoreMock.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+	attachmentStoreMock.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+}
+
+func TestApiServer_UploadAttachmentDeletesBlobOfBrokenImage(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	uploaderId := uuid.New()
+	roomId := uuid.New()
+	// PNG signature followed by garbage
+	content := append([]byte("\x89PNG\r\n\x1a\n"), []byte("garbage")...)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uploaderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{uploaderId}, nil)
+	attachmentStoreMock.On("CountUnsent", ctx, uploaderId).Return(0, nil)
+	blobStoreMock.On("Put", ctx, mock.Anything, content).Return(len(content), nil)
+	blobStoreMock.On("Get", ctx, mock.Anything).Return(io.NopCloser(bytes.NewReader(content)), nil)
+	blobStoreMock.On("Delete", ctx, mock.Anything).Return(nil)
+
+	stream := newUploadStreamMock(ctx,
+		&proto.UploadAttachmentRequest{Data: &proto.UploadAttachmentRequest_Info{Info: &proto.AttachmentInfo{
+			RoomId:   roomId.String(),
+			FileName: "broken.png",
+		}}},
+		&proto.UploadAttachmentRequest{Data: &proto.UploadAttachmentRequest_Chunk{Chunk: content}},
+	)
+
+	err := apiServer.UploadAttachment(stream)
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	blobStoreMock.AssertExpectations(t)
+	attachmentStoreMock.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+}
+
+func TestApiServer_UploadAttachmentFailsIfTooManyUnsent(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	uploaderId := uuid.New()
+	roomId := uuid.New()
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uploaderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{uploaderId}, nil)
+	attachmentStoreMock.On("CountUnsent", ctx, uploaderId).Return(maxUnsentAttachments, nil)
+
+	stream := newUploadStreamMock(ctx,
+		&proto.UploadAttachmentRequest{Data: &proto.UploadAttachmentRequest_Info{Info: &proto.AttachmentInfo{
+			RoomId:   roomId.String(),
+			FileName: "pixel.png",
+		}}},
+	)
+
+	err := apiServer.UploadAttachment(stream)
+
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	blobStoreMock.AssertNotCalled(t, "Put", mock.Anything, mock.Anything, mock.Anything)
+	attachmentStoreMock.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+}
+
+func TestApiServer_DownloadAttachmentSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	message := model.NewMessage(uuid.New(), uuid.New(), "")
+	attachment := model.NewAttachment(message.UserId, message.RoomId, "notes.txt")
+	attachment.MessageId = uuid.NullUUID{UUID: message.Id, Valid: true}
+	content := bytes.Repeat([]byte("a"), attachmentChunkSize+1)
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	attachmentStoreMock.On("Get", ctx, attachment.Id).Return(attachment, nil)
+	roomStoreMock.On("UsersInRoom", ctx, message.RoomId).Return([]uuid.UUID{message.UserId, userId}, nil)
+	messageStoreMock.On("GetMessage", ctx, message.Id).Return(message, nil)
+	blobStoreMock.On("Get", ctx, attachment.BlobKey()).Return(io.NopCloser(bytes.NewReader(content)), nil)
+
+	stream := new(mocks.DownloadAttachmentServerMock)
+	stream.On("Context").Return(ctx)
+	var received []byte
+	var chunks int
+	stream.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		res := args.Get(0).(*proto.DownloadAttachmentResponse)
+		if chunk := res.GetChunk(); chunk != nil {
+			received = append(received, chunk...)
+			chunks++
+		}
+	}).Return(nil)
+
+	err := apiServer.DownloadAttachment(&proto.DownloadAttachmentRequest{
+		AttachmentId: attachment.Id.String(),
+	}, stream)
+
+	assert.Nil(t, err)
+	assert.Equal(t, content, received)
+	assert.Equal(t, 2, chunks)
+	stream.AssertNumberOfCalls(t, "Send", 3)
+}
+
+func TestApiServer_DownloadAttachmentFailsIfNotRoomMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	attachment := model.NewAttachment(uuid.New(), uuid.New(), "notes.txt")
+	attachment.MessageId = uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uuid.New().String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	attachmentStoreMock.On("Get", ctx, attachment.Id).Return(attachment, nil)
+	roomStoreMock.On("UsersInRoom", ctx, attachment.RoomId).Return([]uuid.UUID{attachment.UploaderId}, nil)
+
+	stream := new(mocks.DownloadAttachmentServerMock)
+	stream.On("Context").Return(ctx)
+
+	err := apiServer.DownloadAttachment(&proto.DownloadAttachmentRequest{
+		AttachmentId: attachment.Id.String(),
+	}, stream)
+
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, ""))
+	blobStoreMock.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+	stream.AssertNotCalled(t, "Send", mock.Anything)
+}
+
+func TestApiServer_SendMessageWithAttachmentsSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	roomId := uuid.New()
+	attachment := model.NewAttachment(senderId, roomId, "notes.txt")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{senderId}, nil)
+	attachmentStoreMock.On("GetMany", ctx, []uuid.UUID{attachment.Id}).Return([]model.Attachment{*attachment}, nil)
+	messageStoreMock.On("SendMessage", ctx, mock.MatchedBy(func(message *model.Message) bool {
+		return len(message.Attachments) == 1 && message.Attachments[0].Id == attachment.Id
+	}), []uuid.UUID{senderId}).Return(nil)
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Recipient:     &proto.MessageRequest_RoomId{RoomId: roomId.String()},
+		AttachmentIds: []string{attachment.Id.String()},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, attachment.Id.String(), res.Message.Attachments[0].Id)
+	messageStoreMock.AssertExpectations(t)
+}
+
+func TestApiServer_SendMessageFailsWithAttachmentOfOtherUser(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	roomId := uuid.New()
+	attachment := model.NewAttachment(uuid.New(), roomId, "notes.txt")
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{senderId, attachment.UploaderId}, nil)
+	attachmentStoreMock.On("GetMany", ctx, []uuid.UUID{attachment.Id}).Return([]model.Attachment{*attachment}, nil)
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Recipient:     &proto.MessageRequest_RoomId{RoomId: roomId.String()},
+		AttachmentIds: []string{attachment.Id.String()},
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Errorf(codes.InvalidArgument, "attachment %s not found", attachment.Id))
+	messageStoreMock.AssertNotCalled(t, "SendMessage", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestApiServer_SearchMessagesSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	roomId := uuid.New()
+	senderId := uuid.New()
+	from := utils.Now().Add(-time.Hour)
+	results := []model.SearchResult{
+		{Message: *model.NewMessage(senderId, roomId, "deploy is done"), Snippet: "<mark>deploy</mark> is done", Rank: 0.2},
+		{Message: *model.NewMessage(senderId, roomId, "deploy failed, deploy again"), Snippet: "<mark>deploy</mark> failed", Rank: 0.1},
+	}
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{userId, senderId}, nil)
+	messageStoreMock.On("SearchMessages", ctx, mock.MatchedBy(func(search *model.MessageSearch) bool {
+		return search.UserId == userId &&
+			search.Query == "deploy" &&
+			search.RoomId.UUID == roomId &&
+			search.SenderId.UUID == senderId &&
+			search.From.Equal(from) && search.To == nil &&
+			search.After == nil &&
+			search.PageSize == 2
+	})).Return(results, nil)
+	attachmentStoreMock.On("ListByMessages", ctx, mock.Anything).Return([]model.Attachment{}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, userId, mock.Anything).Return([]model.ReactionCount{}, nil)
+
+	res, err := apiServer.SearchMessages(ctx, &proto.SearchMessagesRequest{
+		Query:    " deploy ",
+		RoomId:   roomId.String(),
+		SenderId: senderId.String(),
+		From:     timestamppb.New(from),
+		PageSize: 2,
+	})
+
+	assert.Nil(t, err)
+	assert.Len(t, res.Results, 2)
+	assert.Equal(t, "<mark>deploy</mark> is done", res.Results[0].Snippet)
+	assert.Equal(t, results[0].Id.String(), res.Results[0].Message.Id)
+
+	search := &model.MessageSearch{
+		UserId:   userId,
+		Query:    "deploy",
+		RoomId:   uuid.NullUUID{UUID: roomId, Valid: true},
+		SenderId: uuid.NullUUID{UUID: senderId, Valid: true},
+		From:     &from,
+	}
+	cursor, err := cursorCodec.Decode(res.NextToken.Value, search.CursorFilter())
+	assert.Nil(t, err)
+	assert.Equal(t, results[1].Id, cursor.Id)
+	assert.Equal(t, 0.1, model.NewSearchCursor(cursor).Rank)
+}
+
+func TestApiServer_SearchMessagesNextPage(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	messageId := uuid.New()
+	search := &model.MessageSearch{UserId: userId, Query: "deploy"}
+	token := cursorCodec.Encode(model.NewCursor(search.CursorFilter(), 50000, messageId, model.PAGE_OLDER))
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("SearchMessages", ctx, mock.MatchedBy(func(search *model.MessageSearch) bool {
+		return !search.RoomId.Valid &&
+			search.After.MessageId == messageId &&
+			search.After.Rank == 0.05 &&
+			search.PageSize == defaultSearchPageSize
+	})).Return([]model.SearchResult{}, nil)
+	attachmentStoreMock.On("ListByMessages", ctx, mock.Anything).Return([]model.Attachment{}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, userId, mock.Anything).Return([]model.ReactionCount{}, nil)
+
+	res, err := apiServer.SearchMessages(ctx, &proto.SearchMessagesRequest{
+		Query:     "deploy",
+		NextToken: &wrapperspb.StringValue{Value: token},
+	})
+
+	assert.Nil(t, err)
+	assert.Empty(t, res.Results)
+	assert.Nil(t, res.NextToken)
+	messageStoreMock.AssertExpectations(t)
+}
+
+func TestApiServer_SearchMessagesFailsIfTokenIsOfAnotherSearch(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	search := &model.MessageSearch{UserId: userId, Query: "release"}
+	token := cursorCodec.Encode(model.NewCursor(search.CursorFilter(), 50000, uuid.New(), model.PAGE_OLDER))
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+
+	res, err := apiServer.SearchMessages(ctx, &proto.SearchMessagesRequest{
+		Query:     "deploy",
+		NextToken: &wrapperspb.StringValue{Value: token},
+	})
+
+	assert.Nil(t, res)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	messageStoreMock.AssertNotCalled(t, "SearchMessages", mock.Anything, mock.Anything)
+}
+
+func TestApiServer_SearchMessagesFailsIfNotRoomMember(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	roomId := uuid.New()
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: uuid.New().String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{uuid.New()}, nil)
+
+	res, err := apiServer.SearchMessages(ctx, &proto.SearchMessagesRequest{
+		Query:  "deploy",
+		RoomId: roomId.String(),
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, ""))
+	messageStoreMock.AssertNotCalled(t, "SearchMessages", mock.Anything, mock.Anything)
+}
+
+func TestApiServer_SearchMessagesFailsForEmptyQuery(t *testing.T) {
+	setupTest()
+
+	res, err := apiServer.SearchMessages(context.TODO(), &proto.SearchMessagesRequest{
+		Query: "   ",
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Errorf(codes.InvalidArgument, "query must be from 1 to %d characters long", maxSearchQueryLength))
+}
+
+func TestApiServer_SendMessageWithClientMessageIdSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	roomId := uuid.New()
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessageByClientId", ctx, senderId, "key-1").Return(nil, sql.ErrNoRows)
+	roomStoreMock.On("UsersInRoom", ctx, roomId).Return([]uuid.UUID{senderId}, nil)
+	messageStoreMock.On("SendMessage", ctx, mock.MatchedBy(func(message *model.Message) bool {
+		return *message.ClientMessageId == "key-1"
+	}), []uuid.UUID{senderId}).Return(nil)
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Message:         "text",
+		Recipient:       &proto.MessageRequest_RoomId{RoomId: roomId.String()},
+		ClientMessageId: "key-1",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "key-1", res.Message.ClientMessageId)
+	messageStoreMock.AssertExpectations(t)
+	// Message is delivered by outbox relay, not by request itself
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_SendMessageRetryReturnsOriginalMessage(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	clientMessageId := "key-1"
+	original := model.NewMessage(senderId, uuid.New(), "text")
+	original.ClientMessageId = &clientMessageId
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	messageStoreMock.On("GetMessageByClientId", ctx, senderId, clientMessageId).Return(original, nil)
+	attachmentStoreMock.On("ListByMessages", ctx, []uuid.UUID{original.Id}).Return([]model.Attachment{}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, senderId, []uuid.UUID{original.Id}).Return([]model.ReactionCount{}, nil)
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Message:         "text",
+		Recipient:       &proto.MessageRequest_RoomId{RoomId: original.RoomId.String()},
+		ClientMessageId: clientMessageId,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, original.Id.String(), res.Message.Id)
+	assert.Equal(t, original.RoomId.String(), res.RoomId)
+	messageStoreMock.AssertNotCalled(t, "SendMessage", mock.Anything, mock.Anything, mock.Anything)
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestApiServer_SendMessageConcurrentRetryReturnsOriginalMessage(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	senderId := uuid.New()
+	clientMessageId := "key-1"
+	original := model.NewMessage(senderId, uuid.New(), "text")
+	original.ClientMessageId = &clientMessageId
+	userClaims := &model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: senderId.String(),
+		},
+		Role: model.USER_ROLE,
+	}
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(userClaims, nil)
+	// Other request stores message between lookup and insert
+	messageStoreMock.On("GetMessageByClientId", ctx, senderId, clientMessageId).Return(nil, sql.ErrNoRows).Once()
+	messageStoreMock.On("GetMessageByClientId", ctx, senderId, clientMessageId).Return(original, nil)
+	roomStoreMock.On("UsersInRoom", ctx, original.RoomId).Return([]uuid.UUID{senderId}, nil)
+	messageStoreMock.On("SendMessage", ctx, mock.Anything, mock.Anything).Return(repository.ErrDuplicateMessage)
+	attachmentStoreMock.On("ListByMessages", ctx, mock.Anything).Return([]model.Attachment{}, nil)
+	messageStoreMock.On("ReactionCounts", ctx, senderId, mock.Anything).Return([]model.ReactionCount{}, nil)
+
+	res, err := apiServer.SendMessage(ctx, &proto.MessageRequest{
+		Message:         "text",
+		Recipient:       &proto.MessageRequest_RoomId{RoomId: original.RoomId.String()},
+		ClientMessageId: clientMessageId,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, original.Id.String(), res.Message.Id)
+	eventProducerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
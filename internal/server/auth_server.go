@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"errors"
+	"time"
 	"unicode/utf8"
 
 	"github.com/ArtyomArtamonov/msg/internal/model"
@@ -13,6 +15,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type AuthServer struct {
@@ -112,6 +115,11 @@ func (s *AuthServer) Refresh(ctx context.Context, req *pb.RefreshRequest) (*pb.T
 		return nil, status.Error(codes.Unauthenticated, "refresh token does not exists")
 	}
 
+	if token.UsedAt != nil {
+		s.revokeReusedFamily(ctx, token)
+		return nil, status.Error(codes.Unauthenticated, "refresh token is already used")
+	}
+
 	if token.ExpiresAt.Unix() < utils.Now().Unix() {
 		if err := s.refreshTokenStore.Delete(ctx, refreshUUID); err != nil {
 			logrus.Errorf("could not delete old refresh token: %v", err)
@@ -129,13 +137,16 @@ func (s *AuthServer) Refresh(ctx context.Context, req *pb.RefreshRequest) (*pb.T
 	if err != nil {
 		return nil, status.Error(codes.Internal, "could not generate token pair")
 	}
+	tokenPair.RefreshToken.FamilyId = token.FamilyId
 
-	if err := s.refreshTokenStore.Delete(ctx, refreshUUID); err != nil {
-		return nil, status.Errorf(codes.Internal, "could not delete old refresh token: %v", err)
+	err = s.refreshTokenStore.Rotate(ctx, refreshUUID, tokenPair.RefreshToken)
+	if errors.Is(err, repository.ErrRefreshTokenReused) {
+		// Token was used concurrently by someone else
+		s.revokeReusedFamily(ctx, token)
+		return nil, status.Error(codes.Unauthenticated, "refresh token is already used")
 	}
-
-	if err := s.refreshTokenStore.Add(ctx, tokenPair.RefreshToken); err != nil {
-		return nil, status.Errorf(codes.Internal, "could not save new refresh token: %v", err)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not rotate refresh token: %v", err)
 	}
 
 	return &pb.TokenResponse{
@@ -145,3 +156,121 @@ func (s *AuthServer) Refresh(ctx context.Context, req *pb.RefreshRequest) (*pb.T
 		},
 	}, nil
 }
+
+func (s *AuthServer) Logout(ctx context.Context, req *pb.LogoutRequest) (*emptypb.Empty, error) {
+	refreshUUID, err := uuid.Parse(req.RefreshToken)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse refresh token")
+	}
+
+	token, err := s.refreshTokenStore.Get(ctx, refreshUUID)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "refresh token does not exists")
+	}
+
+	if err := s.revokeFamily(ctx, token.FamilyId); err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AuthServer) LogoutAll(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error) {
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot parse uuid: %v", err)
+	}
+
+	tokens, err := s.refreshTokenStore.ListByUser(ctx, userId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get refresh tokens: %v", err)
+	}
+
+	// Access token of current request could be issued with refresh token
+	// which was already rotated, so it is revoked explicitly
+	if jti, err := uuid.Parse(claims.Id); err == nil {
+		tokens = append(tokens, model.RefreshToken{
+			Token:    jti,
+			UserId:   userId,
+			IssuedAt: time.Unix(claims.IssuedAt, 0),
+		})
+	}
+
+	for i := range tokens {
+		if err := s.jwtManager.Revoke(ctx, &tokens[i]); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not revoke access token: %v", err)
+		}
+	}
+
+	if err := s.refreshTokenStore.DeleteByUser(ctx, userId); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not delete refresh tokens: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *AuthServer) GetJwks(ctx context.Context, req *emptypb.Empty) (*pb.JwksResponse, error) {
+	keys := []*pb.Jwk{}
+	for _, jwk := range s.jwtManager.JWKS() {
+		keys = append(keys, &pb.Jwk{
+			Kty: jwk.Kty,
+			Kid: jwk.Kid,
+			Use: jwk.Use,
+			Alg: jwk.Alg,
+			N:   jwk.N,
+			E:   jwk.E,
+			Crv: jwk.Crv,
+			X:   jwk.X,
+		})
+	}
+
+	return &pb.JwksResponse{
+		Keys: keys,
+	}, nil
+}
+
+// revokeFamily
+//
+// Revokes every access token issued within the token family and deletes
+// family refresh tokens.
+func (s *AuthServer) revokeFamily(ctx context.Context, familyId uuid.UUID) error {
+	tokens, err := s.refreshTokenStore.ListByFamily(ctx, familyId)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not get refresh tokens: %v", err)
+	}
+
+	for i := range tokens {
+		if err := s.jwtManager.Revoke(ctx, &tokens[i]); err != nil {
+			return status.Errorf(codes.Internal, "could not revoke access token: %v", err)
+		}
+	}
+
+	if err := s.refreshTokenStore.DeleteFamily(ctx, familyId); err != nil {
+		return status.Errorf(codes.Internal, "could not delete refresh tokens: %v", err)
+	}
+
+	return nil
+}
+
+// revokeReusedFamily
+//
+// Already rotated refresh token was presented, which means it has leaked
+// and either the attacker or the user holds a stolen branch of the family.
+// We cannot tell which one, so the whole family is revoked.
+func (s *AuthServer) revokeReusedFamily(ctx context.Context, token *model.RefreshToken) {
+	logrus.WithFields(logrus.Fields{
+		"event":     "refresh_token_reuse",
+		"user_id":   token.UserId.String(),
+		"family_id": token.FamilyId.String(),
+		"token":     token.Token.String(),
+	}).Warn("refresh token reuse detected, revoking token family")
+
+	if err := s.revokeFamily(ctx, token.FamilyId); err != nil {
+		logrus.Errorf("could not revoke reused refresh token family: %v", err)
+	}
+}
@@ -7,13 +7,16 @@ import (
 	"time"
 
 	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/repository"
 	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
 	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func TestAuthServer_RegisterFailsIfUserAlreadyExists(t *testing.T) {
@@ -448,11 +451,20 @@ func TestAuthServer_RefreshFailsIfJWTFails(t *testing.T) {
 	assert.ErrorIs(t, err, status.Error(codes.Internal, "could not generate token pair"))
 }
 
-func TestAuthServer_RefreshFailsIfDeletingTokenFails(t *testing.T) {
+func TestAuthServer_RefreshFailsIfRotatingTokenFails(t *testing.T) {
 	setupTest()
 
 	refreshTokenUuid := uuid.New()
 	userUuid := uuid.New()
+	tokenPair := &model.TokenPair{
+		JwtToken: "",
+		RefreshToken: &model.RefreshToken{
+			Token:     uuid.New(),
+			UserId:    userUuid,
+			ExpiresAt: utils.Now(),
+			IssuedAt:  utils.Now(),
+		},
+	}
 	refreshToken := &model.RefreshToken{
 		Token:     refreshTokenUuid,
 		UserId:    userUuid,
@@ -463,8 +475,8 @@ func TestAuthServer_RefreshFailsIfDeletingTokenFails(t *testing.T) {
 
 	refreshTokenStoreMock.On("Get", mock.Anything, refreshTokenUuid).Return(refreshToken, nil)
 	userStoreMock.On("Find", mock.Anything, userUuid).Return(nil, nil)
-	jwtManagerMock.On("Generate", mock.Anything, mock.Anything).Return(nil, nil)
-	refreshTokenStoreMock.On("Delete", mock.Anything, refreshTokenUuid).Return(expectedError)
+	jwtManagerMock.On("Generate", mock.Anything, mock.Anything).Return(tokenPair, nil)
+	refreshTokenStoreMock.On("Rotate", mock.Anything, refreshTokenUuid, tokenPair.RefreshToken).Return(expectedError)
 
 	res, err := authServer.Refresh(
 		context.TODO(),
@@ -473,39 +485,76 @@ func TestAuthServer_RefreshFailsIfDeletingTokenFails(t *testing.T) {
 		})
 
 	assert.Nil(t, res)
-	assert.ErrorContains(t, err, "could not delete old refresh token")
+	assert.ErrorContains(t, err, "could not rotate refresh token")
 }
 
-func TestAuthServer_RefreshFailsIfCreatingTokenFails(t *testing.T) {
+func TestAuthServer_RefreshRevokesFamilyIfTokenIsReused(t *testing.T) {
+	setupTest()
+
+	usedAt := utils.Now()
+	familyTokens := []model.RefreshToken{
+		{Token: uuid.New(), UserId: uuid.New(), ExpiresAt: utils.Now(), IssuedAt: utils.Now(), UsedAt: &usedAt},
+		{Token: uuid.New(), UserId: uuid.New(), ExpiresAt: utils.Now(), IssuedAt: utils.Now()},
+	}
+	refreshToken := &familyTokens[0]
+	refreshToken.FamilyId = uuid.New()
+
+	refreshTokenStoreMock.On("Get", mock.Anything, refreshToken.Token).Return(refreshToken, nil)
+	refreshTokenStoreMock.On("ListByFamily", mock.Anything, refreshToken.FamilyId).Return(familyTokens, nil)
+	jwtManagerMock.On("Revoke", mock.Anything, mock.Anything).Return(nil)
+	refreshTokenStoreMock.On("DeleteFamily", mock.Anything, refreshToken.FamilyId).Return(nil)
+
+	res, err := authServer.Refresh(
+		context.TODO(),
+		&pb.RefreshRequest{
+			RefreshToken: refreshToken.Token.String(),
+		})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.Unauthenticated, "refresh token is already used"))
+	jwtManagerMock.AssertNumberOfCalls(t, "Revoke", len(familyTokens))
+	jwtManagerMock.AssertNotCalled(t, "Generate", mock.Anything)
+	refreshTokenStoreMock.AssertExpectations(t)
+}
+
+func TestAuthServer_RefreshRevokesFamilyIfTokenIsReusedConcurrently(t *testing.T) {
 	setupTest()
 
-	refreshTokenUuid := uuid.New()
 	userUuid := uuid.New()
+	refreshToken := &model.RefreshToken{
+		Token:     uuid.New(),
+		UserId:    userUuid,
+		FamilyId:  uuid.New(),
+		ExpiresAt: utils.Now(),
+		IssuedAt:  utils.Now(),
+	}
 	tokenPair := &model.TokenPair{
 		JwtToken: "",
 		RefreshToken: &model.RefreshToken{
-			Token:     refreshTokenUuid,
+			Token:     uuid.New(),
 			UserId:    userUuid,
+			FamilyId:  uuid.New(),
 			ExpiresAt: utils.Now(),
 			IssuedAt:  utils.Now(),
 		},
 	}
-	expectedError := errors.New("some_error")
 
-	refreshTokenStoreMock.On("Get", mock.Anything, refreshTokenUuid).Return(tokenPair.RefreshToken, nil)
+	refreshTokenStoreMock.On("Get", mock.Anything, refreshToken.Token).Return(refreshToken, nil)
 	userStoreMock.On("Find", mock.Anything, userUuid).Return(nil, nil)
-	jwtManagerMock.On("Generate", mock.Anything, mock.Anything).Return(tokenPair, nil)
-	refreshTokenStoreMock.On("Delete", mock.Anything, refreshTokenUuid).Return(nil)
-	refreshTokenStoreMock.On("Add", mock.Anything, mock.Anything).Return(expectedError)
+	jwtManagerMock.On("Generate", mock.Anything).Return(tokenPair, nil)
+	refreshTokenStoreMock.On("Rotate", mock.Anything, refreshToken.Token, tokenPair.RefreshToken).Return(repository.ErrRefreshTokenReused)
+	refreshTokenStoreMock.On("ListByFamily", mock.Anything, refreshToken.FamilyId).Return([]model.RefreshToken{}, nil)
+	refreshTokenStoreMock.On("DeleteFamily", mock.Anything, refreshToken.FamilyId).Return(nil)
 
 	res, err := authServer.Refresh(
 		context.TODO(),
 		&pb.RefreshRequest{
-			RefreshToken: refreshTokenUuid.String(),
+			RefreshToken: refreshToken.Token.String(),
 		})
 
 	assert.Nil(t, res)
-	assert.ErrorContains(t, err, "could not save new refresh token")
+	assert.ErrorIs(t, err, status.Error(codes.Unauthenticated, "refresh token is already used"))
+	refreshTokenStoreMock.AssertExpectations(t)
 }
 
 func TestAuthServer_RefreshSuccess(t *testing.T) {
@@ -526,8 +575,7 @@ func TestAuthServer_RefreshSuccess(t *testing.T) {
 	refreshTokenStoreMock.On("Get", mock.Anything, refreshTokenUuid).Return(tokenPair.RefreshToken, nil)
 	userStoreMock.On("Find", mock.Anything, userUuid).Return(nil, nil)
 	jwtManagerMock.On("Generate", mock.Anything, mock.Anything).Return(tokenPair, nil)
-	refreshTokenStoreMock.On("Delete", mock.Anything, refreshTokenUuid).Return(nil)
-	refreshTokenStoreMock.On("Add", mock.Anything, mock.Anything).Return(nil)
+	refreshTokenStoreMock.On("Rotate", mock.Anything, refreshTokenUuid, tokenPair.RefreshToken).Return(nil)
 
 	res, err := authServer.Refresh(
 		context.TODO(),
@@ -547,3 +595,133 @@ func TestAuthServer_RefreshSuccess(t *testing.T) {
 	)
 	assert.Nil(t, err)
 }
+
+func TestAuthServer_LogoutFailsIfInvalidRefresh(t *testing.T) {
+	setupTest()
+
+	res, err := authServer.Logout(
+		context.TODO(),
+		&pb.LogoutRequest{
+			RefreshToken: "invalid_token",
+		})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.InvalidArgument, "could not parse refresh token"))
+}
+
+func TestAuthServer_LogoutFailsIfRefreshTokenDoesNotExist(t *testing.T) {
+	setupTest()
+
+	refreshTokenUuid := uuid.New()
+
+	refreshTokenStoreMock.On("Get", mock.Anything, refreshTokenUuid).Return(nil, errors.New("some_error"))
+
+	res, err := authServer.Logout(
+		context.TODO(),
+		&pb.LogoutRequest{
+			RefreshToken: refreshTokenUuid.String(),
+		})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.Unauthenticated, "refresh token does not exists"))
+}
+
+func TestAuthServer_LogoutSuccess(t *testing.T) {
+	setupTest()
+
+	refreshToken := &model.RefreshToken{
+		Token:     uuid.New(),
+		UserId:    uuid.New(),
+		FamilyId:  uuid.New(),
+		ExpiresAt: utils.Now(),
+		IssuedAt:  utils.Now(),
+	}
+
+	refreshTokenStoreMock.On("Get", mock.Anything, refreshToken.Token).Return(refreshToken, nil)
+	refreshTokenStoreMock.On("ListByFamily", mock.Anything, refreshToken.FamilyId).Return([]model.RefreshToken{*refreshToken}, nil)
+	jwtManagerMock.On("Revoke", mock.Anything, refreshToken).Return(nil)
+	refreshTokenStoreMock.On("DeleteFamily", mock.Anything, refreshToken.FamilyId).Return(nil)
+
+	res, err := authServer.Logout(
+		context.TODO(),
+		&pb.LogoutRequest{
+			RefreshToken: refreshToken.Token.String(),
+		})
+
+	assert.Equal(t, &emptypb.Empty{}, res)
+	assert.Nil(t, err)
+	jwtManagerMock.AssertExpectations(t)
+	refreshTokenStoreMock.AssertExpectations(t)
+}
+
+func TestAuthServer_LogoutAllFailsIfRevokeFails(t *testing.T) {
+	setupTest()
+
+	userId := uuid.New()
+	expectedError := errors.New("some_error")
+
+	jwtManagerMock.On("GetAndVerifyClaims", mock.Anything).Return(&model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:      uuid.New().String(),
+			Subject: userId.String(),
+		},
+	}, nil)
+	refreshTokenStoreMock.On("ListByUser", mock.Anything, userId).Return([]model.RefreshToken{}, nil)
+	jwtManagerMock.On("Revoke", mock.Anything, mock.Anything).Return(expectedError)
+
+	res, err := authServer.LogoutAll(context.TODO(), &emptypb.Empty{})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Errorf(codes.Internal, "could not revoke access token: %v", expectedError))
+	refreshTokenStoreMock.AssertNotCalled(t, "DeleteByUser", mock.Anything, mock.Anything)
+}
+
+func TestAuthServer_LogoutAllSuccess(t *testing.T) {
+	setupTest()
+
+	userId := uuid.New()
+	jti := uuid.New()
+	refreshTokens := []model.RefreshToken{
+		{Token: uuid.New(), UserId: userId, ExpiresAt: utils.Now(), IssuedAt: utils.Now()},
+		{Token: uuid.New(), UserId: userId, ExpiresAt: utils.Now(), IssuedAt: utils.Now()},
+	}
+
+	jwtManagerMock.On("GetAndVerifyClaims", mock.Anything).Return(&model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:       jti.String(),
+			Subject:  userId.String(),
+			IssuedAt: utils.Now().Unix(),
+		},
+	}, nil)
+	refreshTokenStoreMock.On("ListByUser", mock.Anything, userId).Return(refreshTokens, nil)
+	jwtManagerMock.On("Revoke", mock.Anything, mock.Anything).Return(nil)
+	refreshTokenStoreMock.On("DeleteByUser", mock.Anything, userId).Return(nil)
+
+	res, err := authServer.LogoutAll(context.TODO(), &emptypb.Empty{})
+
+	assert.Equal(t, &emptypb.Empty{}, res)
+	assert.Nil(t, err)
+	jwtManagerMock.AssertNumberOfCalls(t, "Revoke", len(refreshTokens)+1)
+	refreshTokenStoreMock.AssertExpectations(t)
+}
+
+func TestAuthServer_GetJwksSuccess(t *testing.T) {
+	setupTest()
+
+	jwtManagerMock.On("JWKS").Return([]model.JWK{
+		{Kty: "OKP", Kid: "key", Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: "x"},
+	})
+
+	res, err := authServer.GetJwks(context.TODO(), &emptypb.Empty{})
+
+	assert.Equal(
+		t,
+		&pb.JwksResponse{
+			Keys: []*pb.Jwk{
+				{Kty: "OKP", Kid: "key", Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: "x"},
+			},
+		},
+		res,
+	)
+	assert.Nil(t, err)
+}
@@ -6,13 +6,38 @@ type EndpointRoles = map[string][]string
 
 func NewEndpointRoles(endpoints *Endpoints) EndpointRoles {
 	return EndpointRoles{
-		endpoints.ApiService.CreateRoom:      {model.ADMIN_ROLE, model.USER_ROLE},
-		endpoints.ApiService.ListRooms:       {model.ADMIN_ROLE, model.USER_ROLE},
-		endpoints.ApiService.SendMessage:     {model.ADMIN_ROLE, model.USER_ROLE},
-		endpoints.ApiService.ListMessages:    {model.ADMIN_ROLE, model.USER_ROLE},
-		endpoints.AuthService.Login:          nil,
-		endpoints.AuthService.Register:       nil,
-		endpoints.AuthService.Refresh:        nil,
-		endpoints.MessageService.GetMessages: {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.CreateRoom:                 {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.ListRooms:                  {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.SendMessage:                {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.ListMessages:               {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.EditMessage:                {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.DeleteMessage:              {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.MarkRead:                   {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.SetTyping:                  {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.AddMembers:                 {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.RemoveMember:               {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.LeaveRoom:                  {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.RenameRoom:                 {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.SetMemberRole:              {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.TransferOwnership:          {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.CreateInvite:               {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.ListInvites:                {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.RevokeInvite:               {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.JoinByInvite:               {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.ListThread:                 {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.SearchMessages:             {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.AddReaction:                {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.RemoveReaction:             {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.UploadAttachment:           {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.ApiService.DownloadAttachment:         {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.AuthService.Login:                     nil,
+		endpoints.AuthService.Register:                  nil,
+		endpoints.AuthService.Refresh:                   nil,
+		endpoints.AuthService.Logout:                    nil,
+		endpoints.AuthService.LogoutAll:                 {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.AuthService.GetJwks:                   nil,
+		endpoints.MessageService.GetMessages:            {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.MessageService.GetPresence:            {model.ADMIN_ROLE, model.USER_ROLE},
+		endpoints.MessageService.UpdatePresenceSettings: {model.ADMIN_ROLE, model.USER_ROLE},
 	}
 }
@@ -7,20 +7,45 @@ type Endpoints struct {
 }
 
 type apiServiceEndpoints struct {
-	CreateRoom   string
-	ListRooms    string
-	SendMessage  string
-	ListMessages string
+	CreateRoom         string
+	ListRooms          string
+	SendMessage        string
+	ListMessages       string
+	EditMessage        string
+	DeleteMessage      string
+	MarkRead           string
+	SetTyping          string
+	AddMembers         string
+	RemoveMember       string
+	LeaveRoom          string
+	RenameRoom         string
+	SetMemberRole      string
+	TransferOwnership  string
+	ListThread         string
+	SearchMessages     string
+	AddReaction        string
+	RemoveReaction     string
+	UploadAttachment   string
+	DownloadAttachment string
+	CreateInvite       string
+	ListInvites        string
+	RevokeInvite       string
+	JoinByInvite       string
 }
 
 type authServiceEndpoints struct {
-	Login    string
-	Register string
-	Refresh  string
+	Login     string
+	Register  string
+	Refresh   string
+	Logout    string
+	LogoutAll string
+	GetJwks   string
 }
 
 type messageServiceEndpoints struct {
-	GetMessages string
+	GetMessages            string
+	GetPresence            string
+	UpdatePresenceSettings string
 }
 
 func NewEndpoints() *Endpoints {
@@ -29,18 +54,43 @@ func NewEndpoints() *Endpoints {
 	messageServicePath := "/message.MessageService/"
 	return &Endpoints{
 		ApiService: apiServiceEndpoints{
-			CreateRoom:   apiServicePath + "CreateRoom",
-			ListRooms:    apiServicePath + "ListRooms",
-			SendMessage:  messageServicePath + "SendMessage",
-			ListMessages: messageServicePath + "ListMessages",
+			CreateRoom:         apiServicePath + "CreateRoom",
+			ListRooms:          apiServicePath + "ListRooms",
+			SendMessage:        messageServicePath + "SendMessage",
+			ListMessages:       messageServicePath + "ListMessages",
+			EditMessage:        apiServicePath + "EditMessage",
+			DeleteMessage:      apiServicePath + "DeleteMessage",
+			MarkRead:           apiServicePath + "MarkRead",
+			SetTyping:          apiServicePath + "SetTyping",
+			AddMembers:         apiServicePath + "AddMembers",
+			RemoveMember:       apiServicePath + "RemoveMember",
+			LeaveRoom:          apiServicePath + "LeaveRoom",
+			RenameRoom:         apiServicePath + "RenameRoom",
+			SetMemberRole:      apiServicePath + "SetMemberRole",
+			TransferOwnership:  apiServicePath + "TransferOwnership",
+			ListThread:         apiServicePath + "ListThread",
+			SearchMessages:     apiServicePath + "SearchMessages",
+			AddReaction:        apiServicePath + "AddReaction",
+			RemoveReaction:     apiServicePath + "RemoveReaction",
+			UploadAttachment:   apiServicePath + "UploadAttachment",
+			DownloadAttachment: apiServicePath + "DownloadAttachment",
+			CreateInvite:       apiServicePath + "CreateInvite",
+			ListInvites:        apiServicePath + "ListInvites",
+			RevokeInvite:       apiServicePath + "RevokeInvite",
+			JoinByInvite:       apiServicePath + "JoinByInvite",
 		},
 		AuthService: authServiceEndpoints{
-			Login:    authServicePath + "Login",
-			Register: authServicePath + "Register",
-			Refresh:  authServicePath + "Refresh",
+			Login:     authServicePath + "Login",
+			Register:  authServicePath + "Register",
+			Refresh:   authServicePath + "Refresh",
+			Logout:    authServicePath + "Logout",
+			LogoutAll: authServicePath + "LogoutAll",
+			GetJwks:   authServicePath + "GetJwks",
 		},
 		MessageService: messageServiceEndpoints{
-			GetMessages: messageServicePath + "GetMessages",
+			GetMessages:            messageServicePath + "GetMessages",
+			GetPresence:            messageServicePath + "GetPresence",
+			UpdatePresenceSettings: messageServicePath + "UpdatePresenceSettings",
 		},
 	}
 }
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/config"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// NewGRPCServer creates grpc server with TLS and limits of [cfg] on top of
+// [options].
+func NewGRPCServer(cfg *config.Config, options ...grpc.ServerOption) (*grpc.Server, error) {
+	options = append(options,
+		grpc.MaxRecvMsgSize(cfg.Limits.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(cfg.Limits.MaxSendMsgSize),
+		grpc.MaxConcurrentStreams(uint32(cfg.Limits.MaxConcurrentStreams)),
+	)
+
+	if cfg.TLS.Enabled() {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+
+	return grpc.NewServer(options...), nil
+}
+
+// Serve
+//
+// Serves [lis] until SIGINT or SIGTERM is received. Running calls are given
+// [timeout] to finish, then they are cancelled.
+func Serve(grpcServer *grpc.Server, lis net.Listener, timeout time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if _, ok := <-signals; !ok {
+			return
+		}
+
+		logrus.Info("Shutting down grpc server")
+		timer := time.AfterFunc(timeout, grpcServer.Stop)
+		defer timer.Stop()
+		grpcServer.GracefulStop()
+	}()
+
+	err := grpcServer.Serve(lis)
+	if err != nil {
+		signal.Stop(signals)
+		close(signals)
+		return err
+	}
+
+	// Serve returns as soon as shutdown begins, running calls are waited for
+	<-stopped
+	return nil
+}
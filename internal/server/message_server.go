@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/ArtyomArtamonov/msg/internal/model"
@@ -8,62 +10,290 @@ import (
 
 	"github.com/ArtyomArtamonov/msg/internal/repository"
 	"github.com/ArtyomArtamonov/msg/internal/service"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
+// maxResumeMessages is the most messages replayed on resume, client which
+// missed more should reconnect without cursor and use ListMessages instead.
+const maxResumeMessages = 1000
+
+// maxPresenceUsers is the most users presence can be requested for at once.
+const maxPresenceUsers = 100
+
 type MessageServer struct {
 	pb.UnimplementedMessageServiceServer
 
-	sessionStore repository.SessionStore
-	jwtManager   *service.JWTManager
+	sessionStore    repository.SessionStore
+	messageStore    repository.MessageStore
+	roomStore       repository.RoomStore
+	presenceStore   repository.PresenceStore
+	presenceTracker service.PresenceTracker
+	jwtManager      service.JWTManagerProtol
 }
 
-func NewMessageServer(jwtManager *service.JWTManager, sessionStore repository.SessionStore) *MessageServer {
+func NewMessageServer(jwtManager service.JWTManagerProtol, sessionStore repository.SessionStore, messageStore repository.MessageStore, roomStore repository.RoomStore, presenceStore repository.PresenceStore, presenceTracker service.PresenceTracker) *MessageServer {
 	return &MessageServer{
-		sessionStore: sessionStore,
-		jwtManager:   jwtManager,
+		sessionStore:    sessionStore,
+		messageStore:    messageStore,
+		roomStore:       roomStore,
+		presenceStore:   presenceStore,
+		presenceTracker: presenceTracker,
+		jwtManager:      jwtManager,
 	}
 }
 
-func (s *MessageServer) GetMessages(req *emptypb.Empty, srv pb.MessageService_GetMessagesServer) error {
+func (s *MessageServer) GetMessages(req *pb.GetMessagesRequest, srv pb.MessageService_GetMessagesServer) error {
 	ctx := srv.Context()
 	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
 	if err != nil {
 		return err
 	}
 
-	id, err := uuid.Parse(claims.Id)
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
 		return status.Error(codes.InvalidArgument, "could not parse uuid")
 	}
 
-	done := make(chan error)
-	session := model.Session{
-		Connection: srv,
-		Id:         id,
-		Expires:    time.Duration(claims.ExpiresAt),
-		Done:       done,
+	// Live messages are buffered until missed ones are replayed
+	stream := &resumeStream{
+		MessageService_GetMessagesServer: srv,
+		replaying:                        req.ResumeFrom != nil,
 	}
-	err = s.sessionStore.Add(&session)
+
+	// Buffered, so session store never blocks on stream which has already ended
+	done := make(chan error, 1)
+	session := model.NewSession(id, deviceId(ctx), stream, time.Duration(claims.ExpiresAt), done)
+	err = s.sessionStore.Add(session)
 	if err != nil {
 		return status.Errorf(codes.Internal, err.Error())
 	}
+	defer s.sessionStore.Delete(id, session.Id)
+
+	if req.ResumeFrom != nil {
+		if err := s.replay(ctx, id, req, stream); err != nil {
+			return err
+		}
+	}
+
+	logrus.Info("Streaming started with id=", id, " session=", session.Id)
 
-	logrus.Info("Streaming started with id=", id)
-	
 	var doneErr error
-	
+
 	select {
 	case doneErr = <-done:
 	case <-ctx.Done():
 	}
-	s.sessionStore.Delete(id)
-	
-	logrus.Info("Streaming ended with id=", id)
-	
+
+	logrus.Info("Streaming ended with id=", id, " session=", session.Id)
+
 	return doneErr
 }
+
+// replay sends messages missed since resume cursor and switches stream to live delivery.
+func (s *MessageServer) replay(ctx context.Context, userId uuid.UUID, req *pb.GetMessagesRequest, stream *resumeStream) error {
+	roomSeqs := map[uuid.UUID]int64{}
+	for roomId, seq := range req.RoomSeqs {
+		id, err := uuid.Parse(roomId)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, "could not parse room uuid")
+		}
+		roomSeqs[id] = seq
+	}
+
+	messages, err := s.messageStore.ListMessagesSince(ctx, userId, req.ResumeFrom.AsTime(), roomSeqs, maxResumeMessages+1)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not get missed messages: %v", err)
+	}
+
+	if len(messages) > maxResumeMessages {
+		return status.Errorf(codes.OutOfRange, "more than %d messages were missed, list messages instead", maxResumeMessages)
+	}
+
+	replayed := map[string]bool{}
+	if req.LastMessageId != "" {
+		replayed[req.LastMessageId] = true
+	}
+	for _, message := range messages {
+		id := message.Id.String()
+		if replayed[id] {
+			continue
+		}
+		replayed[id] = true
+
+		err := stream.MessageService_GetMessagesServer.Send(&pb.MessageStreamResponse{
+			Message: message.ToPbMessage(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return stream.finishReplay(replayed)
+}
+
+// resumeStream
+//
+// Stream which holds live messages back while missed messages are replayed,
+// so that client receives every message once and in order.
+type resumeStream struct {
+	pb.MessageService_GetMessagesServer
+
+	mutex     sync.Mutex
+	replaying bool
+	buffer    []*pb.MessageStreamResponse
+}
+
+func (s *resumeStream) Send(message *pb.MessageStreamResponse) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.replaying {
+		s.buffer = append(s.buffer, message)
+		return nil
+	}
+
+	return s.MessageService_GetMessagesServer.Send(message)
+}
+
+// finishReplay sends live messages received during replay, except creation
+// of messages which were already replayed, and switches to live delivery.
+// Other events, edits and deletions of replayed messages included, are all
+// sent.
+func (s *resumeStream) finishReplay(replayed map[string]bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, message := range s.buffer {
+		id := message.Message.GetId()
+		if message.EventType == pb.EventType_MESSAGE_CREATED && id != "" && replayed[id] {
+			continue
+		}
+
+		if err := s.MessageService_GetMessagesServer.Send(message); err != nil {
+			return err
+		}
+	}
+
+	s.replaying = false
+	s.buffer = nil
+
+	return nil
+}
+
+// deviceId returns optional device-id metadata client identifies its device with.
+func deviceId(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("device-id")
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (s *MessageServer) GetPresence(ctx context.Context, req *pb.GetPresenceRequest) (*pb.GetPresenceResponse, error) {
+	if len(req.UserIds) > maxPresenceUsers {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot get presence of more than %d users", maxPresenceUsers)
+	}
+
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	viewerId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	userIds, err := utils.StringSliceToUUIDSlice(req.UserIds...)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	err = s.checkPresenceVisible(ctx, viewerId, userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.presenceStore.Get(ctx, userIds...)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not get presence: %v", err)
+	}
+
+	presenceByUser := map[uuid.UUID]model.Presence{}
+	for _, presence := range stored {
+		presenceByUser[presence.UserId] = presence
+	}
+
+	presences := []*pb.Presence{}
+	for _, userId := range userIds {
+		presence, ok := presenceByUser[userId]
+		if !ok {
+			presence = model.Presence{UserId: userId}
+		}
+		presences = append(presences, presence.ToPbPresence(s.presenceTracker.IsOnline(userId), viewerId))
+	}
+
+	return &pb.GetPresenceResponse{
+		Presences: presences,
+	}, nil
+}
+
+// checkPresenceVisible makes sure [viewerId] asks about self and users
+// sharing a room with them only, the same users presence events are sent to.
+func (s *MessageServer) checkPresenceVisible(ctx context.Context, viewerId uuid.UUID, userIds []uuid.UUID) error {
+	var visible map[uuid.UUID]bool
+	for _, userId := range userIds {
+		if userId == viewerId {
+			continue
+		}
+
+		if visible == nil {
+			mates, err := s.roomStore.RoomMates(ctx, viewerId)
+			if err != nil {
+				return status.Errorf(codes.Internal, "could not get room mates: %v", err)
+			}
+
+			visible = map[uuid.UUID]bool{}
+			for _, mate := range mates {
+				visible[mate] = true
+			}
+		}
+
+		if !visible[userId] {
+			return status.Error(codes.PermissionDenied, "can get presence of users sharing a room with you only")
+		}
+	}
+
+	return nil
+}
+
+func (s *MessageServer) UpdatePresenceSettings(ctx context.Context, req *pb.UpdatePresenceSettingsRequest) (*emptypb.Empty, error) {
+	claims, err := s.jwtManager.GetAndVerifyClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "could not parse uuid")
+	}
+
+	err = s.presenceStore.SetHideLastSeen(ctx, userId, req.HideLastSeen)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not update presence settings: %v", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
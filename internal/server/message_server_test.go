@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newEndedStreamMock(userId uuid.UUID) *mocks.GetMessagesServerMock {
+	// Stream context is already done, so GetMessages returns right after replay
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(&model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   userId.String(),
+			ExpiresAt: utils.Now().Add(time.Minute).Unix(),
+		},
+	}, nil)
+
+	stream := new(mocks.GetMessagesServerMock)
+	stream.On("Context").Return(ctx)
+	return stream
+}
+
+func TestMessageServer_GetMessagesReplaysMissedMessagesOnce(t *testing.T) {
+	setupTest()
+
+	userId := uuid.New()
+	resumeFrom := utils.Now().Add(-time.Hour)
+	lastSeen := *model.NewMessage(uuid.New(), uuid.New(), "seen")
+	missed := *model.NewMessage(uuid.New(), uuid.New(), "missed")
+	live := *model.NewMessage(uuid.New(), uuid.New(), "live")
+
+	stream := newEndedStreamMock(userId)
+	var sent []string
+	stream.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		sent = append(sent, args.Get(0).(*pb.MessageStreamResponse).Message.Text)
+	}).Return(nil)
+
+	sessionStoreMock.On("Add", mock.Anything).Run(func(args mock.Arguments) {
+		// Delivered live while missed messages are being fetched
+		session := args.Get(0).(*model.Session)
+		session.Connection.Send(&pb.MessageStreamResponse{Message: missed.ToPbMessage()})
+		session.Connection.Send(&pb.MessageStreamResponse{Message: live.ToPbMessage()})
+	}).Return(nil)
+	sessionStoreMock.On("Delete", userId, mock.Anything).Return()
+	messageStoreMock.On("ListMessagesSince", mock.Anything, userId, resumeFrom.UTC(), map[uuid.UUID]int64{}, maxResumeMessages+1).Return([]model.Message{
+		lastSeen,
+		missed,
+	}, nil)
+
+	err := messageServer.GetMessages(&pb.GetMessagesRequest{
+		ResumeFrom:    timestamppb.New(resumeFrom),
+		LastMessageId: lastSeen.Id.String(),
+	}, stream)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"missed", "live"}, sent)
+	sessionStoreMock.AssertExpectations(t)
+}
+
+func TestMessageServer_GetMessagesWithoutLastMessageIdKeepsBufferedEvents(t *testing.T) {
+	setupTest()
+
+	userId := uuid.New()
+	resumeFrom := utils.Now().Add(-time.Hour)
+	missed := *model.NewMessage(uuid.New(), uuid.New(), "missed")
+	typing := &pb.MessageStreamResponse{
+		EventType: pb.EventType_TYPING_STARTED,
+		Typing:    &pb.TypingIndicator{RoomId: missed.RoomId.String(), UserId: missed.UserId.String()},
+	}
+
+	stream := newEndedStreamMock(userId)
+	var sent []pb.EventType
+	stream.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		sent = append(sent, args.Get(0).(*pb.MessageStreamResponse).EventType)
+	}).Return(nil)
+	sessionStoreMock.On("Add", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*model.Session).Connection.Send(typing)
+	}).Return(nil)
+	sessionStoreMock.On("Delete", userId, mock.Anything).Return()
+	messageStoreMock.On("ListMessagesSince", mock.Anything, userId, resumeFrom.UTC(), map[uuid.UUID]int64{}, maxResumeMessages+1).Return([]model.Message{
+		missed,
+	}, nil)
+
+	err := messageServer.GetMessages(&pb.GetMessagesRequest{
+		ResumeFrom: timestamppb.New(resumeFrom),
+	}, stream)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []pb.EventType{pb.EventType_MESSAGE_CREATED, pb.EventType_TYPING_STARTED}, sent)
+}
+
+func TestMessageServer_GetMessagesSendsBufferedEditOfReplayedMessage(t *testing.T) {
+	setupTest()
+
+	userId := uuid.New()
+	resumeFrom := utils.Now().Add(-time.Hour)
+	missed := *model.NewMessage(uuid.New(), uuid.New(), "missed")
+	edited := missed
+	edited.Text = "edited"
+
+	stream := newEndedStreamMock(userId)
+	var sent []string
+	stream.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		sent = append(sent, args.Get(0).(*pb.MessageStreamResponse).Message.Text)
+	}).Return(nil)
+	sessionStoreMock.On("Add", mock.Anything).Run(func(args mock.Arguments) {
+		// Edited while missed messages are being fetched
+		args.Get(0).(*model.Session).Connection.Send(&pb.MessageStreamResponse{
+			EventType: pb.EventType_MESSAGE_EDITED,
+			Message:   edited.ToPbMessage(),
+		})
+	}).Return(nil)
+	sessionStoreMock.On("Delete", userId, mock.Anything).Return()
+	messageStoreMock.On("ListMessagesSince", mock.Anything, userId, resumeFrom.UTC(), map[uuid.UUID]int64{}, maxResumeMessages+1).Return([]model.Message{
+		missed,
+	}, nil)
+
+	err := messageServer.GetMessages(&pb.GetMessagesRequest{
+		ResumeFrom: timestamppb.New(resumeFrom),
+	}, stream)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"missed", "edited"}, sent)
+}
+
+func TestMessageServer_GetMessagesResumesRoomsBySeq(t *testing.T) {
+	setupTest()
+
+	userId := uuid.New()
+	roomId := uuid.New()
+	resumeFrom := utils.Now().Add(-time.Hour)
+	missed := *model.NewMessage(uuid.New(), roomId, "missed")
+	missed.Seq = 8
+
+	stream := newEndedStreamMock(userId)
+	var sent []int64
+	stream.On("Send", mock.Anything).Run(func(args mock.Arguments) {
+		sent = append(sent, args.Get(0).(*pb.MessageStreamResponse).Message.Seq)
+	}).Return(nil)
+	sessionStoreMock.On("Add", mock.Anything).Return(nil)
+	sessionStoreMock.On("Delete", userId, mock.Anything).Return()
+	messageStoreMock.On("ListMessagesSince", mock.Anything, userId, resumeFrom.UTC(), map[uuid.UUID]int64{roomId: 7}, maxResumeMessages+1).Return([]model.Message{
+		missed,
+	}, nil)
+
+	err := messageServer.GetMessages(&pb.GetMessagesRequest{
+		ResumeFrom: timestamppb.New(resumeFrom),
+		RoomSeqs:   map[string]int64{roomId.String(): 7},
+	}, stream)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{8}, sent)
+}
+
+func TestMessageServer_GetMessagesFailsIfInvalidRoomSeqs(t *testing.T) {
+	setupTest()
+
+	userId := uuid.New()
+
+	stream := newEndedStreamMock(userId)
+	sessionStoreMock.On("Add", mock.Anything).Return(nil)
+	sessionStoreMock.On("Delete", userId, mock.Anything).Return()
+
+	err := messageServer.GetMessages(&pb.GetMessagesRequest{
+		ResumeFrom: timestamppb.New(utils.Now()),
+		RoomSeqs:   map[string]int64{"invalid": 7},
+	}, stream)
+
+	assert.ErrorIs(t, err, status.Error(codes.InvalidArgument, "could not parse room uuid"))
+	messageStoreMock.AssertNotCalled(t, "ListMessagesSince", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMessageServer_GetMessagesFailsIfTooManyMessagesMissed(t *testing.T) {
+	setupTest()
+
+	userId := uuid.New()
+	resumeFrom := utils.Now().Add(-time.Hour)
+
+	stream := newEndedStreamMock(userId)
+	sessionStoreMock.On("Add", mock.Anything).Return(nil)
+	sessionStoreMock.On("Delete", userId, mock.Anything).Return()
+	messageStoreMock.On("ListMessagesSince", mock.Anything, userId, resumeFrom.UTC(), map[uuid.UUID]int64{}, maxResumeMessages+1).Return(
+		make([]model.Message, maxResumeMessages+1), nil)
+
+	err := messageServer.GetMessages(&pb.GetMessagesRequest{
+		ResumeFrom: timestamppb.New(resumeFrom),
+	}, stream)
+
+	assert.ErrorIs(t, err, status.Errorf(codes.OutOfRange, "more than %d messages were missed, list messages instead", maxResumeMessages))
+	stream.AssertNotCalled(t, "Send", mock.Anything)
+	sessionStoreMock.AssertExpectations(t)
+}
+
+func TestMessageServer_GetMessagesWithoutCursorDoesNotReplay(t *testing.T) {
+	setupTest()
+
+	userId := uuid.New()
+
+	stream := newEndedStreamMock(userId)
+	sessionStoreMock.On("Add", mock.Anything).Return(nil)
+	sessionStoreMock.On("Delete", userId, mock.Anything).Return()
+
+	err := messageServer.GetMessages(&pb.GetMessagesRequest{}, stream)
+
+	assert.Nil(t, err)
+	messageStoreMock.AssertNotCalled(t, "ListMessagesSince", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMessageServer_GetPresenceSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	viewerId := uuid.New()
+	onlineUserId := uuid.New()
+	hiddenUserId := uuid.New()
+	offlineUserId := uuid.New()
+	lastSeenAt := utils.Now().Add(-time.Hour)
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(&model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: viewerId.String(),
+		},
+	}, nil)
+	roomStoreMock.On("RoomMates", ctx, viewerId).Return([]uuid.UUID{onlineUserId, hiddenUserId, offlineUserId}, nil)
+	presenceStoreMock.On("Get", ctx, []uuid.UUID{onlineUserId, hiddenUserId, offlineUserId}).Return([]model.Presence{
+		{UserId: onlineUserId, LastSeenAt: &lastSeenAt},
+		{UserId: hiddenUserId, LastSeenAt: &lastSeenAt, HideLastSeen: true},
+		{UserId: offlineUserId, LastSeenAt: &lastSeenAt},
+	}, nil)
+	presenceTrackerMock.On("IsOnline", onlineUserId).Return(true)
+	presenceTrackerMock.On("IsOnline", hiddenUserId).Return(false)
+	presenceTrackerMock.On("IsOnline", offlineUserId).Return(false)
+
+	res, err := messageServer.GetPresence(ctx, &pb.GetPresenceRequest{
+		UserIds: []string{onlineUserId.String(), hiddenUserId.String(), offlineUserId.String()},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*pb.Presence{
+		{UserId: onlineUserId.String(), Online: true},
+		{UserId: hiddenUserId.String()},
+		{UserId: offlineUserId.String(), LastSeenAt: timestamppb.New(lastSeenAt)},
+	}, res.Presences)
+}
+
+func TestMessageServer_GetPresenceShowsOwnHiddenLastSeen(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	lastSeenAt := utils.Now().Add(-time.Hour)
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(&model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+	}, nil)
+	presenceStoreMock.On("Get", ctx, []uuid.UUID{userId}).Return([]model.Presence{
+		{UserId: userId, LastSeenAt: &lastSeenAt, HideLastSeen: true},
+	}, nil)
+	presenceTrackerMock.On("IsOnline", userId).Return(false)
+
+	res, err := messageServer.GetPresence(ctx, &pb.GetPresenceRequest{
+		UserIds: []string{userId.String()},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, timestamppb.New(lastSeenAt), res.Presences[0].LastSeenAt)
+	roomStoreMock.AssertNotCalled(t, "RoomMates", mock.Anything, mock.Anything)
+}
+
+func TestMessageServer_GetPresenceFailsIfNoSharedRoom(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	viewerId := uuid.New()
+	mateId := uuid.New()
+	strangerId := uuid.New()
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(&model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: viewerId.String(),
+		},
+	}, nil)
+	roomStoreMock.On("RoomMates", ctx, viewerId).Return([]uuid.UUID{mateId}, nil)
+
+	res, err := messageServer.GetPresence(ctx, &pb.GetPresenceRequest{
+		UserIds: []string{mateId.String(), strangerId.String()},
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.PermissionDenied, "can get presence of users sharing a room with you only"))
+	presenceStoreMock.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
+
+func TestMessageServer_GetPresenceFailsIfTooManyUsers(t *testing.T) {
+	setupTest()
+
+	userIds := make([]string, maxPresenceUsers+1)
+
+	res, err := messageServer.GetPresence(context.TODO(), &pb.GetPresenceRequest{
+		UserIds: userIds,
+	})
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Errorf(codes.InvalidArgument, "cannot get presence of more than %d users", maxPresenceUsers))
+}
+
+func TestMessageServer_UpdatePresenceSettingsSuccess(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	userId := uuid.New()
+	jwtManagerMock.On("GetAndVerifyClaims", ctx).Return(&model.UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: userId.String(),
+		},
+	}, nil)
+	presenceStoreMock.On("SetHideLastSeen", ctx, userId, true).Return(nil)
+
+	res, err := messageServer.UpdatePresenceSettings(ctx, &pb.UpdatePresenceSettingsRequest{
+		HideLastSeen: true,
+	})
+
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	presenceStoreMock.AssertExpectations(t)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/service"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// pageBounds tells whether listing may continue past either end of page.
+type pageBounds struct {
+	hasOlder bool
+	hasNewer bool
+}
+
+// firstPage starts with the newest item, so listing continues towards older
+// items only when page is full.
+func firstPage(pageSize, listed int) pageBounds {
+	return pageBounds{
+		hasOlder: listed == pageSize,
+	}
+}
+
+// pageAfter is page listed from cursor in [direction], listing continues
+// back to where cursor points.
+func pageAfter(direction model.PageDirection, pageSize, listed int) pageBounds {
+	if direction == model.PAGE_NEWER {
+		return pageBounds{
+			hasOlder: true,
+			hasNewer: listed == pageSize,
+		}
+	}
+
+	return pageBounds{
+		hasOlder: listed == pageSize,
+		hasNewer: true,
+	}
+}
+
+// token encodes [cursor] if listing continues in its direction.
+func (b pageBounds) token(codec *service.CursorCodec, cursor *model.Cursor) *wrapperspb.StringValue {
+	if cursor.Direction == model.PAGE_NEWER && !b.hasNewer || cursor.Direction == model.PAGE_OLDER && !b.hasOlder {
+		return nil
+	}
+
+	return &wrapperspb.StringValue{Value: codec.Encode(cursor)}
+}
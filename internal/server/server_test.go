@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	"github.com/ArtyomArtamonov/msg/internal/service"
 	"github.com/ArtyomArtamonov/msg/internal/utils"
 )
 
@@ -10,9 +11,18 @@ var refreshTokenStoreMock *mocks.RefreshTokenStoreMock
 var roomStoreMock *mocks.RoomStoreMock
 var userStoreMock *mocks.UserStoreMock
 var messageStoreMock *mocks.MessageStoreMock
-var amqpProducerMock *mocks.AMQPProducerMock
+var inviteStoreMock *mocks.InviteStoreMock
+var attachmentStoreMock *mocks.AttachmentStoreMock
+var blobStoreMock *mocks.BlobStoreMock
+var eventProducerMock *mocks.EventProducerMock
+var outboxNotifierMock *mocks.OutboxNotifierMock
+var sessionStoreMock *mocks.SessionStoreMock
+var presenceStoreMock *mocks.PresenceStoreMock
+var presenceTrackerMock *mocks.PresenceTrackerMock
+var cursorCodec *service.CursorCodec
 var apiServer *ApiServer
 var authServer *AuthServer
+var messageServer *MessageServer
 
 func setupTest() {
 	utils.MockNow(utils.DefaultMockTime)
@@ -21,8 +31,18 @@ func setupTest() {
 	roomStoreMock = new(mocks.RoomStoreMock)
 	userStoreMock = new(mocks.UserStoreMock)
 	messageStoreMock = new(mocks.MessageStoreMock)
-	amqpProducerMock = new(mocks.AMQPProducerMock)
-	apiServer = NewApiServer(jwtManagerMock, roomStoreMock, messageStoreMock, amqpProducerMock)
+	inviteStoreMock = new(mocks.InviteStoreMock)
+	attachmentStoreMock = new(mocks.AttachmentStoreMock)
+	blobStoreMock = new(mocks.BlobStoreMock)
+	eventProducerMock = new(mocks.EventProducerMock)
+	outboxNotifierMock = new(mocks.OutboxNotifierMock)
+	outboxNotifierMock.On("Notify").Return().Maybe()
+	sessionStoreMock = new(mocks.SessionStoreMock)
+	presenceStoreMock = new(mocks.PresenceStoreMock)
+	presenceTrackerMock = new(mocks.PresenceTrackerMock)
+	cursorCodec = service.NewCursorCodec("cursor secret")
+	apiServer = NewApiServer(jwtManagerMock, roomStoreMock, messageStoreMock, inviteStoreMock, attachmentStoreMock, blobStoreMock, cursorCodec, eventProducerMock, outboxNotifierMock)
+	messageServer = NewMessageServer(jwtManagerMock, sessionStoreMock, messageStoreMock, roomStoreMock, presenceStoreMock, presenceTrackerMock)
 	authServer = &AuthServer{
 		userStore:         userStoreMock,
 		refreshTokenStore: refreshTokenStoreMock,
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// ErrAMQPDisconnected is returned while connection to message broker is
+// being restored.
+var ErrAMQPDisconnected = errors.New("message broker is not connected")
+
+const (
+	amqpReconnectDelay    = time.Second
+	amqpMaxReconnectDelay = 30 * time.Second
+)
+
+// AMQPSetup declares exchanges, queues and bindings on fresh channel and
+// starts consumers on it. Setups are run again after every reconnect.
+type AMQPSetup func(channel *amqp.Channel) error
+
+type amqpDialer func(url string) (*amqp.Connection, error)
+
+// AMQPConnection
+//
+// Connection to message broker which is restored when broker closes it.
+// Connection is redialed with exponential backoff, then every registered
+// setup is run on the new channel in order of registration.
+type AMQPConnection struct {
+	url  string
+	dial amqpDialer
+
+	mutex     sync.RWMutex
+	conn      *amqp.Connection
+	channel   *amqp.Channel
+	setups    []AMQPSetup
+	listeners []func(connected bool)
+	closed    bool
+}
+
+func NewAMQPConnection(url string) *AMQPConnection {
+	return &AMQPConnection{
+		url:  url,
+		dial: amqp.Dial,
+	}
+}
+
+// Start connects to broker, retrying until it succeeds, and keeps
+// connection restored in background afterwards.
+func (c *AMQPConnection) Start() {
+	closed := c.reconnect()
+	go c.watch(closed)
+}
+
+// OnConnect
+//
+// Registers [setup]. If connection is up already, setup is run on current
+// channel right away.
+func (c *AMQPConnection) OnConnect(setup AMQPSetup) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.setups = append(c.setups, setup)
+	if c.channel == nil {
+		return nil
+	}
+
+	return setup(c.channel)
+}
+
+// OnStateChange registers [listener] called with current state of
+// connection and whenever connection goes up or down.
+func (c *AMQPConnection) OnStateChange(listener func(connected bool)) {
+	c.mutex.Lock()
+	c.listeners = append(c.listeners, listener)
+	connected := c.channel != nil
+	c.mutex.Unlock()
+
+	listener(connected)
+}
+
+// Channel returns current channel or ErrAMQPDisconnected while connection is down.
+func (c *AMQPConnection) Channel() (*amqp.Channel, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.channel == nil {
+		return nil, ErrAMQPDisconnected
+	}
+
+	return c.channel, nil
+}
+
+func (c *AMQPConnection) IsConnected() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.channel != nil
+}
+
+// Close closes connection for good, it is not restored afterwards.
+func (c *AMQPConnection) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	c.channel = nil
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
+
+func (c *AMQPConnection) watch(closed chan *amqp.Error) {
+	for closed != nil {
+		err, ok := <-closed
+
+		c.mutex.Lock()
+		c.channel = nil
+		done := c.closed
+		listeners := c.listeners
+		c.mutex.Unlock()
+
+		if done {
+			return
+		}
+
+		if ok {
+			logrus.Warningf("connection to message broker is lost: %v", err)
+		} else {
+			logrus.Warning("connection to message broker is lost")
+		}
+		notifyStateListeners(listeners, false)
+
+		closed = c.reconnect()
+	}
+}
+
+// reconnect dials broker and runs setups until it succeeds, and returns
+// channel closing of connection is reported to.
+func (c *AMQPConnection) reconnect() chan *amqp.Error {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoffDelay(attempt-1, amqpReconnectDelay, amqpMaxReconnectDelay))
+		}
+
+		c.mutex.RLock()
+		done := c.closed
+		c.mutex.RUnlock()
+		if done {
+			return nil
+		}
+
+		closed, err := c.connect()
+		if err != nil {
+			logrus.Errorf("could not connect to message broker (attempt %d): %v", attempt+1, err)
+			continue
+		}
+
+		logrus.Info("Connected to message broker")
+		return closed
+	}
+}
+
+func (c *AMQPConnection) connect() (chan *amqp.Error, error) {
+	conn, err := c.dial(c.url)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Closing of channel alone is handled as closing of connection
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		if err, ok := <-channel.NotifyClose(make(chan *amqp.Error, 1)); ok {
+			logrus.Warningf("channel of message broker is closed: %v", err)
+			conn.Close()
+		}
+	}()
+
+	c.mutex.Lock()
+	for _, setup := range c.setups {
+		err = setup(channel)
+		if err != nil {
+			c.mutex.Unlock()
+			conn.Close()
+			return nil, err
+		}
+	}
+	c.conn = conn
+	c.channel = channel
+	listeners := c.listeners
+	c.mutex.Unlock()
+
+	notifyStateListeners(listeners, true)
+
+	return closed, nil
+}
+
+func notifyStateListeners(listeners []func(connected bool), connected bool) {
+	for _, listener := range listeners {
+		listener(connected)
+	}
+}
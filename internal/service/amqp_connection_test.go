@@ -0,0 +1,43 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAMQPConnection_IsDisconnectedUntilStarted(t *testing.T) {
+	connection := NewAMQPConnection("amqp://localhost")
+
+	called := false
+	err := connection.OnConnect(func(channel *amqp.Channel) error {
+		called = true
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.False(t, called)
+	assert.False(t, connection.IsConnected())
+
+	channel, err := connection.Channel()
+	assert.Nil(t, channel)
+	assert.ErrorIs(t, err, ErrAMQPDisconnected)
+}
+
+func TestAMQPConnection_StopsReconnectingWhenClosed(t *testing.T) {
+	connection := NewAMQPConnection("amqp://localhost")
+	dials := 0
+	connection.dial = func(url string) (*amqp.Connection, error) {
+		dials++
+		connection.Close()
+		return nil, errors.New("connection refused")
+	}
+
+	closed := connection.reconnect()
+
+	assert.Nil(t, closed)
+	assert.Equal(t, 1, dials)
+	assert.False(t, connection.IsConnected())
+}
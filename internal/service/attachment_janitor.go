@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/repository"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AttachmentCleanupInterval is how often janitor looks for attachments
+	// to delete.
+	AttachmentCleanupInterval = 10 * time.Minute
+	// UnsentAttachmentTTL is how long uploaded attachment is kept for
+	// before it is sent with a message.
+	UnsentAttachmentTTL = 24 * time.Hour
+	// AttachmentCleanupBatchSize is the most blobs janitor deletes at once.
+	AttachmentCleanupBatchSize = 100
+)
+
+// AttachmentJanitor
+//
+// Deletes attachments which were not sent within UnsentAttachmentTTL and
+// blobs of attachments deleted with their message, room or uploader.
+type AttachmentJanitor struct {
+	attachmentStore repository.AttachmentStore
+	blobStore       repository.BlobStore
+}
+
+func NewAttachmentJanitor(attachmentStore repository.AttachmentStore, blobStore repository.BlobStore) *AttachmentJanitor {
+	return &AttachmentJanitor{
+		attachmentStore: attachmentStore,
+		blobStore:       blobStore,
+	}
+}
+
+// Run cleans attachments up every AttachmentCleanupInterval until process
+// exits.
+func (j *AttachmentJanitor) Run() {
+	ticker := time.NewTicker(AttachmentCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		err := j.Clean(context.Background())
+		if err != nil {
+			logrus.Errorf("could not clean attachments up: %v", err)
+		}
+
+		<-ticker.C
+	}
+}
+
+// Clean deletes unsent attachments which are due, then blobs of all deleted
+// attachments. Blob is forgotten only once it is deleted from blob store,
+// so blob which fails to delete is retried next time.
+func (j *AttachmentJanitor) Clean(ctx context.Context) error {
+	deleted, err := j.attachmentStore.DeleteUnsent(ctx, utils.Now().Add(-UnsentAttachmentTTL))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		logrus.Debugf("deleted %d unsent attachments", deleted)
+	}
+
+	for {
+		keys, err := j.attachmentStore.ListDeletedBlobs(ctx, AttachmentCleanupBatchSize)
+		if err != nil {
+			return err
+		}
+
+		removed := make([]string, 0, len(keys))
+		for _, key := range keys {
+			err = j.blobStore.Delete(ctx, key)
+			if err != nil {
+				logrus.Errorf("could not delete attachment blob %s: %v", key, err)
+				continue
+			}
+			removed = append(removed, key)
+		}
+
+		if len(removed) > 0 {
+			err = j.attachmentStore.ForgetDeletedBlobs(ctx, removed...)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Blobs failing to delete are listed again, so batch with any of
+		// them is the last one this time
+		if len(keys) < AttachmentCleanupBatchSize || len(removed) < len(keys) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestAttachmentJanitor_CleanDeletesUnsentAttachmentsAndDeletedBlobs(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	attachmentStoreMock := new(mocks.AttachmentStoreMock)
+	blobStoreMock := new(mocks.BlobStoreMock)
+	janitor := NewAttachmentJanitor(attachmentStoreMock, blobStoreMock)
+
+	attachmentStoreMock.On("DeleteUnsent", ctx, utils.Now().Add(-UnsentAttachmentTTL)).Return(int64(2), nil)
+	attachmentStoreMock.On("ListDeletedBlobs", ctx, AttachmentCleanupBatchSize).Return([]string{"deleted", "failing"}, nil)
+	blobStoreMock.On("Delete", ctx, "deleted").Return(nil)
+	blobStoreMock.On("Delete", ctx, "failing").Return(errors.New("disk is gone"))
+	attachmentStoreMock.On("ForgetDeletedBlobs", ctx, []string{"deleted"}).Return(nil)
+
+	err := janitor.Clean(ctx)
+
+	assert.Nil(t, err)
+	attachmentStoreMock.AssertExpectations(t)
+	blobStoreMock.AssertExpectations(t)
+}
+
+func TestAttachmentJanitor_CleanFailsIfDeleteUnsentFails(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	attachmentStoreMock := new(mocks.AttachmentStoreMock)
+	blobStoreMock := new(mocks.BlobStoreMock)
+	janitor := NewAttachmentJanitor(attachmentStoreMock, blobStoreMock)
+
+	attachmentStoreMock.On("DeleteUnsent", ctx, utils.Now().Add(-UnsentAttachmentTTL)).Return(int64(0), errors.New("db is down"))
+
+	err := janitor.Clean(ctx)
+
+	assert.NotNil(t, err)
+	attachmentStoreMock.AssertNotCalled(t, "ListDeletedBlobs", ctx, AttachmentCleanupBatchSize)
+	blobStoreMock.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,15 @@
+package service
+
+import "time"
+
+// backoffDelay doubles [delay] with every failed attempt up to [maxDelay].
+func backoffDelay(attempts int, delay, maxDelay time.Duration) time.Duration {
+	for i := 0; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/google/uuid"
+)
+
+// cursorVersion is the first byte of every token, tokens of other versions
+// are rejected.
+const cursorVersion = 1
+
+// cursorMacSize is length of truncated HMAC-SHA256 token is signed with.
+const cursorMacSize = 16
+
+// cursorPayloadSize is version, direction, sort key, id and filter.
+const cursorPayloadSize = 1 + 1 + 8 + 16 + model.CursorFilterSize
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorCodec
+//
+// Encodes cursors into opaque page tokens signed with HMAC, so clients can
+// not forge them or reuse them with another listing.
+type CursorCodec struct {
+	secret []byte
+}
+
+func NewCursorCodec(secret string) *CursorCodec {
+	return &CursorCodec{
+		secret: []byte(secret),
+	}
+}
+
+func (c *CursorCodec) Encode(cursor *model.Cursor) string {
+	payload := make([]byte, cursorPayloadSize, cursorPayloadSize+cursorMacSize)
+	payload[0] = cursorVersion
+	payload[1] = byte(cursor.Direction)
+	binary.BigEndian.PutUint64(payload[2:10], uint64(cursor.SortKey))
+	copy(payload[10:26], cursor.Id[:])
+	copy(payload[26:], cursor.Filter[:])
+	payload = append(payload, c.mac(payload)...)
+
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// Decode
+//
+// Verifies token signature and returns cursor it encodes. Token issued for
+// listing other than [filter] fails with ErrInvalidCursor as forged one does.
+func (c *CursorCodec) Decode(token string, filter model.CursorFilter) (*model.Cursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) != cursorPayloadSize+cursorMacSize {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, mac := buf[:cursorPayloadSize], buf[cursorPayloadSize:]
+	if !hmac.Equal(mac, c.mac(payload)) || payload[0] != cursorVersion {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := &model.Cursor{
+		Direction: model.PageDirection(payload[1]),
+		SortKey:   int64(binary.BigEndian.Uint64(payload[2:10])),
+	}
+	cursor.Id, _ = uuid.FromBytes(payload[10:26])
+	copy(cursor.Filter[:], payload[26:])
+
+	if cursor.Filter != filter {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Direction != model.PAGE_OLDER && cursor.Direction != model.PAGE_NEWER {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+func (c *CursorCodec) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, c.secret)
+	h.Write(payload)
+
+	return h.Sum(nil)[:cursorMacSize]
+}
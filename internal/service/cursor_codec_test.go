@@ -0,0 +1,64 @@
+package service
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorCodec_EncodeDecodeSuccess(t *testing.T) {
+	codec := NewCursorCodec("secret")
+	filter := model.NewCursorFilter("messages", uuid.NewString())
+	cursor := model.NewCursor(filter, 42, uuid.New(), model.PAGE_NEWER)
+
+	decoded, err := codec.Decode(codec.Encode(cursor), filter)
+
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, decoded)
+}
+
+func TestCursorCodec_DecodeFailsIfTokenIsTampered(t *testing.T) {
+	codec := NewCursorCodec("secret")
+	filter := model.NewCursorFilter("messages")
+	token := codec.Encode(model.NewCursor(filter, 42, uuid.New(), model.PAGE_OLDER))
+
+	buf, _ := base64.RawURLEncoding.DecodeString(token)
+	buf[9]++
+
+	cursor, err := codec.Decode(base64.RawURLEncoding.EncodeToString(buf), filter)
+
+	assert.Nil(t, cursor)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestCursorCodec_DecodeFailsIfSignedWithAnotherSecret(t *testing.T) {
+	filter := model.NewCursorFilter("messages")
+	token := NewCursorCodec("other").Encode(model.NewCursor(filter, 42, uuid.New(), model.PAGE_OLDER))
+
+	cursor, err := NewCursorCodec("secret").Decode(token, filter)
+
+	assert.Nil(t, cursor)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestCursorCodec_DecodeFailsIfFilterDiffers(t *testing.T) {
+	codec := NewCursorCodec("secret")
+	token := codec.Encode(model.NewCursor(model.NewCursorFilter("messages", "a"), 42, uuid.New(), model.PAGE_OLDER))
+
+	cursor, err := codec.Decode(token, model.NewCursorFilter("messages", "b"))
+
+	assert.Nil(t, cursor)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestCursorCodec_DecodeFailsIfTokenIsMalformed(t *testing.T) {
+	codec := NewCursorCodec("secret")
+
+	cursor, err := codec.Decode("some_invalid_token", model.NewCursorFilter("messages"))
+
+	assert.Nil(t, cursor)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
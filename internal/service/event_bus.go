@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ArtyomArtamonov/msg/internal/repository"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrEventBusClosed = errors.New("event bus is closed")
+
+// EventProducer publishes deliveries to recipients whichever replica they
+// are connected to.
+type EventProducer interface {
+	Produce(*pb.MessageDelivery) error
+}
+
+// PartialDeliveryError is returned by producer which has published delivery
+// to some of recipients only, delivery is retried for [UserIds] left.
+type PartialDeliveryError struct {
+	UserIds []string
+	Err     error
+}
+
+func (e *PartialDeliveryError) Error() string {
+	return fmt.Sprintf("could not publish delivery to %d recipients: %v", len(e.UserIds), e.Err)
+}
+
+func (e *PartialDeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// producePerRecipient
+//
+// Publishes separate delivery for every recipient with [publish]. Every
+// recipient is tried even if some fail, so delivery published to some of
+// recipients fails with PartialDeliveryError listing the rest.
+func producePerRecipient(delivery *pb.MessageDelivery, publish func(userId string, data []byte) error) error {
+	var failed []string
+	var firstErr error
+	for _, userId := range delivery.UserIds {
+		userDelivery := proto.Clone(delivery).(*pb.MessageDelivery)
+		userDelivery.UserIds = []string{userId}
+
+		data, err := proto.Marshal(userDelivery)
+		if err == nil {
+			err = publish(userId, data)
+		}
+		if err != nil {
+			failed = append(failed, userId)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+	if len(failed) == len(delivery.UserIds) {
+		return firstErr
+	}
+
+	return &PartialDeliveryError{UserIds: failed, Err: firstErr}
+}
+
+// EventConsumer
+//
+// Receives deliveries of users subscribed on this replica. Consume blocks
+// and hands deliveries to EventDispatcher.
+type EventConsumer interface {
+	Consume()
+	Subscribe(userId uuid.UUID) error
+	Unsubscribe(userId uuid.UUID) error
+}
+
+// EventBus
+//
+// Carries deliveries from api_service to message_service replicas and
+// presence updates between message_service replicas, regardless of broker
+// it is backed by. Bus created without dispatcher only publishes.
+type EventBus interface {
+	EventProducer
+	EventConsumer
+
+	// Broadcast sends presence update to every replica.
+	Broadcast(update *pb.PresenceUpdate) error
+	// OnPresence sets handler Consume passes presence updates to.
+	OnPresence(handler func(update *pb.PresenceUpdate))
+	// OnStateChange registers [listener] called with current state of
+	// connection to broker and whenever it changes.
+	OnStateChange(listener func(connected bool))
+	Close() error
+}
+
+// EventDispatcher hands deliveries received from event bus to sessions of
+// users connected to this replica.
+type EventDispatcher struct {
+	SessionStore repository.SessionStore
+	Typing       *TypingTracker
+}
+
+func NewEventDispatcher(sessionStore repository.SessionStore) *EventDispatcher {
+	return &EventDispatcher{
+		SessionStore: sessionStore,
+		Typing:       NewTypingTracker(sessionStore, TypingTimeout),
+	}
+}
+
+func (d *EventDispatcher) Dispatch(delivery *pb.MessageDelivery) {
+	response := &pb.MessageStreamResponse{
+		Message:     delivery.Message,
+		EventType:   delivery.EventType,
+		ReadReceipt: delivery.ReadReceipt,
+		Typing:      delivery.Typing,
+		Presence:    delivery.Presence,
+		Membership:  delivery.Membership,
+		Reaction:    delivery.Reaction,
+	}
+
+	for _, id := range delivery.UserIds {
+		if isOwnEvent(delivery, id) {
+			continue
+		}
+
+		id, err := uuid.Parse(id)
+		if err != nil {
+			logrus.Warningf("could not parse id: %v", err)
+			continue
+		}
+
+		if isTypingEvent(delivery.EventType) {
+			err = d.Typing.Deliver(id, response)
+		} else {
+			err = d.SessionStore.Send(id, response)
+		}
+		if err != nil {
+			continue
+		}
+	}
+}
+
+// isOwnEvent reports whether event should not be delivered back to user who
+// caused it. Edits, deletions and read receipts are propagated to other
+// devices of that user as well.
+func isOwnEvent(delivery *pb.MessageDelivery, userId string) bool {
+	switch delivery.EventType {
+	case pb.EventType_MESSAGE_CREATED:
+		return delivery.Message.GetUserId() == userId
+	case pb.EventType_TYPING_STARTED, pb.EventType_TYPING_STOPPED:
+		return delivery.Typing.GetUserId() == userId
+	default:
+		return false
+	}
+}
+
+func isTypingEvent(eventType pb.EventType) bool {
+	return eventType == pb.EventType_TYPING_STARTED || eventType == pb.EventType_TYPING_STOPPED
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProducePerRecipient_TriesEveryRecipientAndReportsFailedOnes(t *testing.T) {
+	delivery := &pb.MessageDelivery{UserIds: []string{"a", "b", "c"}}
+	brokerErr := errors.New("broker is down")
+
+	var published []string
+	err := producePerRecipient(delivery, func(userId string, data []byte) error {
+		if userId == "b" {
+			return brokerErr
+		}
+		published = append(published, userId)
+		return nil
+	})
+
+	var partial *PartialDeliveryError
+	assert.ErrorAs(t, err, &partial)
+	assert.ErrorIs(t, err, brokerErr)
+	assert.Equal(t, []string{"b"}, partial.UserIds)
+	assert.Equal(t, []string{"a", "c"}, published)
+}
+
+func TestProducePerRecipient_FailsWithCauseIfNoRecipientIsPublished(t *testing.T) {
+	delivery := &pb.MessageDelivery{UserIds: []string{"a", "b"}}
+	brokerErr := errors.New("broker is down")
+
+	err := producePerRecipient(delivery, func(string, []byte) error {
+		return brokerErr
+	})
+
+	assert.Equal(t, brokerErr, err)
+}
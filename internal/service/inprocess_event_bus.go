@@ -0,0 +1,140 @@
+package service
+
+import (
+	"sync"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+)
+
+// InProcessEventBufferSize is how many events are queued before Produce
+// blocks waiting for Consume.
+const InProcessEventBufferSize = 1024
+
+type inProcessEvent struct {
+	delivery *pb.MessageDelivery
+	presence *pb.PresenceUpdate
+}
+
+// InProcessEventBus
+//
+// Event bus for api_service and message_service running in one process
+// without broker. There is the only replica every user is connected to, so
+// subscriptions are not tracked.
+type InProcessEventBus struct {
+	Dispatcher *EventDispatcher
+
+	mutex    sync.RWMutex
+	events   chan inProcessEvent
+	done     chan struct{}
+	presence func(update *pb.PresenceUpdate)
+	closed   bool
+}
+
+func NewInProcessEventBus(dispatcher *EventDispatcher) *InProcessEventBus {
+	return &InProcessEventBus{
+		Dispatcher: dispatcher,
+		events:     make(chan inProcessEvent, InProcessEventBufferSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// Produce queues copy of [delivery], so it is not shared with producer just
+// like it would not be if it went through broker.
+func (b *InProcessEventBus) Produce(delivery *pb.MessageDelivery) error {
+	return b.queue(inProcessEvent{
+		delivery: proto.Clone(delivery).(*pb.MessageDelivery),
+	})
+}
+
+func (b *InProcessEventBus) Broadcast(update *pb.PresenceUpdate) error {
+	return b.queue(inProcessEvent{
+		presence: proto.Clone(update).(*pb.PresenceUpdate),
+	})
+}
+
+// queue waits for room in queue without holding lock, so bus can be closed
+// while producers wait for Consume which has stopped.
+func (b *InProcessEventBus) queue(event inProcessEvent) error {
+	b.mutex.RLock()
+	closed := b.closed
+	b.mutex.RUnlock()
+	if closed {
+		return ErrEventBusClosed
+	}
+
+	select {
+	case b.events <- event:
+		return nil
+	case <-b.done:
+		return ErrEventBusClosed
+	}
+}
+
+func (b *InProcessEventBus) Subscribe(userId uuid.UUID) error {
+	return nil
+}
+
+func (b *InProcessEventBus) Unsubscribe(userId uuid.UUID) error {
+	return nil
+}
+
+// Consume handles queued events until bus is closed. Events queued before
+// bus is closed are handled before Consume returns.
+func (b *InProcessEventBus) Consume() {
+	for {
+		select {
+		case event := <-b.events:
+			b.handle(event)
+		case <-b.done:
+			for {
+				select {
+				case event := <-b.events:
+					b.handle(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *InProcessEventBus) handle(event inProcessEvent) {
+	if event.delivery != nil {
+		b.Dispatcher.Dispatch(event.delivery)
+		return
+	}
+
+	b.mutex.RLock()
+	handler := b.presence
+	b.mutex.RUnlock()
+	if handler != nil {
+		handler(event.presence)
+	}
+}
+
+func (b *InProcessEventBus) OnPresence(handler func(update *pb.PresenceUpdate)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.presence = handler
+}
+
+// OnStateChange reports bus as connected right away, there is no broker to
+// lose connection to.
+func (b *InProcessEventBus) OnStateChange(listener func(connected bool)) {
+	listener(true)
+}
+
+func (b *InProcessEventBus) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+
+	return nil
+}
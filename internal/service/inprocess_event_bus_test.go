@@ -0,0 +1,93 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestInProcessEventBus_ConsumeDispatchesDeliveriesToRecipients(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	bus := NewInProcessEventBus(NewEventDispatcher(sessionStoreMock))
+
+	senderId := uuid.New()
+	recipientId := uuid.New()
+	delivery := &pb.MessageDelivery{
+		UserIds:   []string{senderId.String(), recipientId.String()},
+		EventType: pb.EventType_MESSAGE_CREATED,
+		Message:   &pb.Message{UserId: senderId.String(), Text: "text"},
+	}
+	sessionStoreMock.On("Send", recipientId, mock.MatchedBy(func(res *pb.MessageStreamResponse) bool {
+		return res.Message.GetText() == "text"
+	})).Return(nil)
+
+	err := bus.Produce(delivery)
+	assert.Nil(t, err)
+	bus.Close()
+	bus.Consume()
+
+	sessionStoreMock.AssertExpectations(t)
+	sessionStoreMock.AssertNotCalled(t, "Send", senderId, mock.Anything)
+}
+
+func TestInProcessEventBus_ConsumePassesPresenceToHandler(t *testing.T) {
+	setupTest()
+
+	bus := NewInProcessEventBus(NewEventDispatcher(new(mocks.SessionStoreMock)))
+	var received []*pb.PresenceUpdate
+	bus.OnPresence(func(update *pb.PresenceUpdate) {
+		received = append(received, update)
+	})
+
+	err := bus.Broadcast(&pb.PresenceUpdate{ReplicaId: "replica", Online: true})
+	assert.Nil(t, err)
+	bus.Close()
+	bus.Consume()
+
+	assert.Len(t, received, 1)
+	assert.Equal(t, "replica", received[0].ReplicaId)
+}
+
+func TestInProcessEventBus_ProduceFailsWhenClosed(t *testing.T) {
+	bus := NewInProcessEventBus(nil)
+	bus.Close()
+
+	err := bus.Produce(&pb.MessageDelivery{})
+
+	assert.ErrorIs(t, err, ErrEventBusClosed)
+}
+
+func TestInProcessEventBus_CloseReleasesProducerWaitingForFullQueue(t *testing.T) {
+	bus := NewInProcessEventBus(nil)
+	for i := 0; i < InProcessEventBufferSize; i++ {
+		assert.Nil(t, bus.Produce(&pb.MessageDelivery{}))
+	}
+
+	produced := make(chan error, 1)
+	go func() { produced <- bus.Produce(&pb.MessageDelivery{}) }()
+
+	closed := make(chan struct{})
+	go func() {
+		bus.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for producer")
+	}
+	select {
+	case err := <-produced:
+		assert.ErrorIs(t, err, ErrEventBusClosed)
+	case <-time.After(time.Second):
+		t.Fatal("producer was not released")
+	}
+}
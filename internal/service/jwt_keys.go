@@ -0,0 +1,203 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/golang-jwt/jwt"
+)
+
+// JWTKey
+//
+// Asymmetric key used to sign or verify access tokens. [PrivateKey] is nil
+// for verification-only keys.
+type JWTKey struct {
+	Id         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+// JWTKeySet
+//
+// Holds the key new tokens are signed with and every key tokens are
+// accepted from. Keeping previous keys in verification set allows
+// rotating signing key without invalidating already issued tokens.
+type JWTKeySet struct {
+	signingKey       *JWTKey
+	verificationKeys map[string]*JWTKey
+}
+
+func NewJWTKeySet(signingKey *JWTKey, verificationKeys ...*JWTKey) *JWTKeySet {
+	keys := make(map[string]*JWTKey)
+	for _, key := range verificationKeys {
+		keys[key.Id] = key
+	}
+	if signingKey != nil {
+		keys[signingKey.Id] = signingKey
+	}
+
+	return &JWTKeySet{
+		signingKey:       signingKey,
+		verificationKeys: keys,
+	}
+}
+
+// LoadJWTKeySet
+//
+// Loads signing key from [privateKeyPath] and verification keys from
+// [publicKeysDir], where every *.pem file is a public key with file name
+// being its key id. Both paths are optional, service which only verifies
+// tokens should not have access to private key at all.
+func LoadJWTKeySet(privateKeyPath, keyId, publicKeysDir string) (*JWTKeySet, error) {
+	var signingKey *JWTKey
+	if privateKeyPath != "" {
+		if keyId == "" {
+			return nil, fmt.Errorf("key id is required for signing key")
+		}
+
+		data, err := os.ReadFile(privateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read signing key: %v", err)
+		}
+
+		signingKey, err = ParseJWTPrivateKey(keyId, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	verificationKeys := []*JWTKey{}
+	if publicKeysDir != "" {
+		paths, err := filepath.Glob(filepath.Join(publicKeysDir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("could not read verification key: %v", err)
+			}
+
+			id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			key, err := ParseJWTPublicKey(id, data)
+			if err != nil {
+				return nil, err
+			}
+			verificationKeys = append(verificationKeys, key)
+		}
+	}
+
+	if signingKey == nil && len(verificationKeys) == 0 {
+		return nil, fmt.Errorf("no jwt keys found")
+	}
+
+	return NewJWTKeySet(signingKey, verificationKeys...), nil
+}
+
+// ParseJWTPrivateKey parses PEM encoded PKCS#1 RSA or PKCS#8 RSA/Ed25519 private key.
+func ParseJWTPrivateKey(id string, data []byte) (*JWTKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: invalid PEM", id)
+	}
+
+	var parsed interface{}
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", id, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &JWTKey{Id: id, Method: jwt.SigningMethodRS256, PrivateKey: key, PublicKey: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &JWTKey{Id: id, Method: jwt.SigningMethodEdDSA, PrivateKey: key, PublicKey: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", id, parsed)
+	}
+}
+
+// ParseJWTPublicKey parses PEM encoded PKIX RSA or Ed25519 public key.
+func ParseJWTPublicKey(id string, data []byte) (*JWTKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: invalid PEM", id)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", id, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PublicKey:
+		return &JWTKey{Id: id, Method: jwt.SigningMethodRS256, PublicKey: key}, nil
+	case ed25519.PublicKey:
+		return &JWTKey{Id: id, Method: jwt.SigningMethodEdDSA, PublicKey: key}, nil
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", id, parsed)
+	}
+}
+
+func (s *JWTKeySet) SigningKey() *JWTKey {
+	return s.signingKey
+}
+
+func (s *JWTKeySet) VerificationKey(id string) (*JWTKey, bool) {
+	key, ok := s.verificationKeys[id]
+	return key, ok
+}
+
+// JWKS returns public keys of every verification key, sorted by key id.
+func (s *JWTKeySet) JWKS() []model.JWK {
+	ids := []string{}
+	for id := range s.verificationKeys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := []model.JWK{}
+	for _, id := range ids {
+		key := s.verificationKeys[id]
+		jwk := model.JWK{
+			Kid: key.Id,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+
+		switch publicKey := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		default:
+			continue
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
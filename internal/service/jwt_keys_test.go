@@ -0,0 +1,89 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRSAKey(t *testing.T, id string) *JWTKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	return &JWTKey{Id: id, Method: jwt.SigningMethodRS256, PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+}
+
+func newEd25519Key(t *testing.T, id string) *JWTKey {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	return &JWTKey{Id: id, Method: jwt.SigningMethodEdDSA, PrivateKey: privateKey, PublicKey: publicKey}
+}
+
+func TestJWTKeySet_LoadSuccess(t *testing.T) {
+	setupTest()
+
+	dir := t.TempDir()
+	publicDir := filepath.Join(dir, "public")
+	assert.Nil(t, os.Mkdir(publicDir, 0o700))
+
+	signingKey := newEd25519Key(t, "new")
+	privateBytes, _ := x509.MarshalPKCS8PrivateKey(signingKey.PrivateKey)
+	privatePath := filepath.Join(dir, "private.pem")
+	assert.Nil(t, os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes}), 0o600))
+
+	oldKey := newRSAKey(t, "old")
+	publicBytes, _ := x509.MarshalPKIXPublicKey(oldKey.PublicKey)
+	assert.Nil(t, os.WriteFile(filepath.Join(publicDir, "old.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes}), 0o600))
+
+	keys, err := LoadJWTKeySet(privatePath, "new", publicDir)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "new", keys.SigningKey().Id)
+	assert.Equal(t, jwt.SigningMethodEdDSA, keys.SigningKey().Method)
+	oldLoaded, ok := keys.VerificationKey("old")
+	assert.True(t, ok)
+	assert.Equal(t, jwt.SigningMethodRS256, oldLoaded.Method)
+	assert.Nil(t, oldLoaded.PrivateKey)
+	_, ok = keys.VerificationKey("new")
+	assert.True(t, ok)
+}
+
+func TestJWTKeySet_LoadFailsWithoutKeyId(t *testing.T) {
+	setupTest()
+
+	keys, err := LoadJWTKeySet("private.pem", "", "")
+
+	assert.Nil(t, keys)
+	assert.ErrorContains(t, err, "key id is required")
+}
+
+func TestJWTKeySet_JWKSSuccess(t *testing.T) {
+	setupTest()
+
+	rsaKey := newRSAKey(t, "a")
+	edKey := newEd25519Key(t, "b")
+	keys := NewJWTKeySet(edKey, rsaKey)
+
+	res := keys.JWKS()
+
+	assert.Len(t, res, 2)
+	assert.Equal(t, "a", res[0].Kid)
+	assert.Equal(t, "RSA", res[0].Kty)
+	assert.Equal(t, "RS256", res[0].Alg)
+	assert.Equal(t, "AQAB", res[0].E)
+	assert.NotEmpty(t, res[0].N)
+	assert.Equal(t, "b", res[1].Kid)
+	assert.Equal(t, "OKP", res[1].Kty)
+	assert.Equal(t, "Ed25519", res[1].Crv)
+	assert.Equal(t, "EdDSA", res[1].Alg)
+	assert.NotEmpty(t, res[1].X)
+}
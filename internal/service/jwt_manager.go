@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/repository"
 	"github.com/ArtyomArtamonov/msg/internal/utils"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
@@ -19,43 +20,67 @@ type JWTManagerProtol interface {
 	Verify(accessToken string) (*model.UserClaims, error)
 	NewRefreshToken(userId uuid.UUID) *model.RefreshToken
 	GetAndVerifyClaims(ctx context.Context) (*model.UserClaims, error)
+	Revoke(ctx context.Context, refreshToken *model.RefreshToken) error
+	JWKS() []model.JWK
 }
 
 type JWTManager struct {
 	secretKey            string
+	keys                 *JWTKeySet
 	tokenDuration        time.Duration
 	refreshTokenDuration time.Duration
+	denylist             repository.TokenDenylist
 }
 
-func NewJWTManager(secretKey string, tokenDuration, refreshTokenDuration time.Duration) *JWTManager {
+func NewJWTManager(secretKey string, tokenDuration, refreshTokenDuration time.Duration, denylist repository.TokenDenylist) *JWTManager {
 	return &JWTManager{
 		secretKey:            secretKey,
 		tokenDuration:        tokenDuration,
 		refreshTokenDuration: refreshTokenDuration,
+		denylist:             denylist,
 	}
 }
 
+// NewJWTManagerWithKeys
+//
+// Creates manager which signs tokens with asymmetric signing key of [keys]
+// and verifies them with the key matching token kid. If [keys] has no
+// signing key, manager is only able to verify tokens.
+func NewJWTManagerWithKeys(keys *JWTKeySet, tokenDuration, refreshTokenDuration time.Duration, denylist repository.TokenDenylist) *JWTManager {
+	return &JWTManager{
+		keys:                 keys,
+		tokenDuration:        tokenDuration,
+		refreshTokenDuration: refreshTokenDuration,
+		denylist:             denylist,
+	}
+}
+
+// Generate
+//
+// Issues access token together with refresh token. Access token jti is
+// equal to refresh token, so revoking session also revokes access token
+// issued with it.
 func (m *JWTManager) Generate(user *model.User) (*model.TokenPair, error) {
+	refreshToken := m.NewRefreshToken(user.Id)
 	claims := model.UserClaims{
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: utils.Now().Add(m.tokenDuration).Unix(),
 			IssuedAt:  utils.Now().Unix(),
-			Id:        user.Id.String(),
+			Id:        refreshToken.Token.String(),
 			Subject:   user.Id.String(),
 		},
 		Username: user.Username,
 		Role:     user.Role,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenSigned, err := token.SignedString([]byte(m.secretKey))
+	tokenSigned, err := m.sign(claims)
 	if err != nil {
 		return nil, err
 	}
 
 	tokenPair := &model.TokenPair{
 		JwtToken:     tokenSigned,
-		RefreshToken: m.NewRefreshToken(user.Id),
+		RefreshToken: refreshToken,
 	}
 	return tokenPair, nil
 }
@@ -64,13 +89,7 @@ func (m *JWTManager) Verify(accessToken string) (*model.UserClaims, error) {
 	token, err := jwt.ParseWithClaims(
 		accessToken,
 		&model.UserClaims{},
-		func(t *jwt.Token) (interface{}, error) {
-			_, ok := t.Method.(*jwt.SigningMethodHMAC)
-			if !ok {
-				return nil, fmt.Errorf("unexpected token signing method")
-			}
-			return []byte(m.secretKey), nil
-		})
+		m.verificationKey)
 
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %v", err)
@@ -84,10 +103,63 @@ func (m *JWTManager) Verify(accessToken string) (*model.UserClaims, error) {
 	return claims, nil
 }
 
+func (m *JWTManager) sign(claims model.UserClaims) (string, error) {
+	if m.keys == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(m.secretKey))
+	}
+
+	key := m.keys.SigningKey()
+	if key == nil {
+		return "", fmt.Errorf("signing key is not provided")
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.Id
+	return token.SignedString(key.PrivateKey)
+}
+
+func (m *JWTManager) verificationKey(t *jwt.Token) (interface{}, error) {
+	if m.keys == nil {
+		_, ok := t.Method.(*jwt.SigningMethodHMAC)
+		if !ok {
+			return nil, fmt.Errorf("unexpected token signing method")
+		}
+		return []byte(m.secretKey), nil
+	}
+
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token key id is not provided")
+	}
+
+	key, ok := m.keys.VerificationKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown token key id %q", kid)
+	}
+
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected token signing method")
+	}
+
+	return key.PublicKey, nil
+}
+
+// JWKS returns public keys tokens are verified with. Shared secret is never
+// published, so manager without asymmetric keys returns nothing.
+func (m *JWTManager) JWKS() []model.JWK {
+	if m.keys == nil {
+		return []model.JWK{}
+	}
+
+	return m.keys.JWKS()
+}
+
 func (m *JWTManager) NewRefreshToken(userId uuid.UUID) *model.RefreshToken {
 	token := &model.RefreshToken{
 		Token:     uuid.New(),
 		UserId:    userId,
+		FamilyId:  uuid.New(),
 		ExpiresAt: utils.Now().Add(m.refreshTokenDuration),
 		IssuedAt:  utils.Now(),
 	}
@@ -110,5 +182,33 @@ func (m *JWTManager) GetAndVerifyClaims(ctx context.Context) (*model.UserClaims,
 	if err != nil {
 		return nil, status.Errorf(codes.Unauthenticated, "authorization token is invalid: %v", err)
 	}
+
+	if m.denylist != nil {
+		jti, err := uuid.Parse(claims.Id)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "authorization token is invalid: could not parse jti")
+		}
+
+		revoked, err := m.denylist.Contains(ctx, jti)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not check authorization token: %v", err)
+		}
+		if revoked {
+			return nil, status.Error(codes.Unauthenticated, "authorization token is revoked")
+		}
+	}
+
 	return claims, nil
 }
+
+// Revoke
+//
+// Denies access token issued together with [refreshToken] until it expires.
+// Refresh token itself should be deleted by caller.
+func (m *JWTManager) Revoke(ctx context.Context, refreshToken *model.RefreshToken) error {
+	if m.denylist == nil {
+		return nil
+	}
+
+	return m.denylist.Add(ctx, refreshToken.Token, refreshToken.IssuedAt.Add(m.tokenDuration))
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -106,7 +107,7 @@ func TestJWTManager_VerifySuccess(t *testing.T) {
 			StandardClaims: jwt.StandardClaims{
 				ExpiresAt: utils.Now().Add(jwtManager.tokenDuration).Unix(),
 				IssuedAt:  utils.Now().Unix(),
-				Id:        user.Id.String(),
+				Id:        tokenPair.RefreshToken.Token.String(),
 				Subject:   user.Id.String(),
 			},
 			Username: user.Username,
@@ -131,6 +132,7 @@ func TestJWTManager_NewRefreshTokenSuccess(t *testing.T) {
 	res := jwtManager.NewRefreshToken(userId)
 
 	assert.NotEmpty(t, res.Token)
+	assert.NotEmpty(t, res.FamilyId)
 	assert.Equal(t, userId, res.UserId)
 	assert.Equal(t, utils.Now().Add(jwtManager.refreshTokenDuration), res.ExpiresAt)
 	assert.Equal(t, utils.Now(), res.IssuedAt)
@@ -211,7 +213,7 @@ func TestJWTManager_GetAndVerifyClaimsSuccess(t *testing.T) {
 			StandardClaims: jwt.StandardClaims{
 				ExpiresAt: utils.Now().Add(jwtManager.tokenDuration).Unix(),
 				IssuedAt:  utils.Now().Unix(),
-				Id:        user.Id.String(),
+				Id:        tokenPair.RefreshToken.Token.String(),
 				Subject:   user.Id.String(),
 			},
 			Username: user.Username,
@@ -221,3 +223,129 @@ func TestJWTManager_GetAndVerifyClaimsSuccess(t *testing.T) {
 	)
 	assert.Nil(t, err)
 }
+
+func TestJWTManager_GetAndVerifyClaimsFailsIfTokenIsRevoked(t *testing.T) {
+	setupTest()
+
+	tokenDenylistMock := new(mocks.TokenDenylistMock)
+	jwtManager := &JWTManager{
+		secretKey:            "some_key",
+		tokenDuration:        tokenDuration,
+		refreshTokenDuration: tokenDuration,
+		denylist:             tokenDenylistMock,
+	}
+
+	user, _ := model.NewUser(
+		"admin",
+		"admin",
+		model.ADMIN_ROLE,
+	)
+	tokenPair, err := jwtManager.Generate(user)
+
+	contextMock := new(mocks.ContextMock)
+	contextMock.On("Value", mock.Anything).Return(metadata.MD{
+		"authorization": {tokenPair.JwtToken},
+	})
+	tokenDenylistMock.On("Contains", contextMock, tokenPair.RefreshToken.Token).Return(true, nil)
+
+	res, err := jwtManager.GetAndVerifyClaims(contextMock)
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, status.Error(codes.Unauthenticated, "authorization token is revoked"))
+}
+
+func TestJWTManager_RevokeSuccess(t *testing.T) {
+	setupTest()
+
+	tokenDenylistMock := new(mocks.TokenDenylistMock)
+	jwtManager := &JWTManager{
+		secretKey:            "some_key",
+		tokenDuration:        tokenDuration,
+		refreshTokenDuration: tokenDuration,
+		denylist:             tokenDenylistMock,
+	}
+
+	ctx := context.TODO()
+	refreshToken := jwtManager.NewRefreshToken(uuid.New())
+	tokenDenylistMock.On("Add", ctx, refreshToken.Token, utils.Now().Add(tokenDuration)).Return(nil)
+
+	err := jwtManager.Revoke(ctx, refreshToken)
+
+	assert.Nil(t, err)
+	tokenDenylistMock.AssertExpectations(t)
+}
+
+func TestJWTManager_VerifyWithKeysSuccess(t *testing.T) {
+	setupTest()
+
+	for _, key := range []*JWTKey{newRSAKey(t, "rsa"), newEd25519Key(t, "ed25519")} {
+		jwtManager := NewJWTManagerWithKeys(NewJWTKeySet(key), tokenDuration, tokenDuration, nil)
+		user, _ := model.NewUser(
+			"admin",
+			"admin",
+			model.ADMIN_ROLE,
+		)
+		tokenPair, err := jwtManager.Generate(user)
+		assert.Nil(t, err)
+
+		// Verification-only manager must accept token signed by issuer
+		verifier := NewJWTManagerWithKeys(NewJWTKeySet(nil, &JWTKey{Id: key.Id, Method: key.Method, PublicKey: key.PublicKey}), tokenDuration, tokenDuration, nil)
+		res, err := verifier.Verify(tokenPair.JwtToken)
+
+		assert.Nil(t, err)
+		assert.Equal(t, user.Id.String(), res.Subject)
+	}
+}
+
+func TestJWTManager_VerifyWithKeysFailsIfKeyIdIsUnknown(t *testing.T) {
+	setupTest()
+
+	issuer := NewJWTManagerWithKeys(NewJWTKeySet(newEd25519Key(t, "unknown")), tokenDuration, tokenDuration, nil)
+	user, _ := model.NewUser(
+		"admin",
+		"admin",
+		model.ADMIN_ROLE,
+	)
+	tokenPair, _ := issuer.Generate(user)
+
+	jwtManager := NewJWTManagerWithKeys(NewJWTKeySet(newEd25519Key(t, "known")), tokenDuration, tokenDuration, nil)
+	res, err := jwtManager.Verify(tokenPair.JwtToken)
+
+	assert.Nil(t, res)
+	assert.ErrorContains(t, err, "unknown token key id")
+}
+
+func TestJWTManager_VerifyWithKeysFailsIfTokenIsSignedWithSecret(t *testing.T) {
+	setupTest()
+
+	issuer := NewJWTManager("some_key", tokenDuration, tokenDuration, nil)
+	user, _ := model.NewUser(
+		"admin",
+		"admin",
+		model.ADMIN_ROLE,
+	)
+	tokenPair, _ := issuer.Generate(user)
+
+	jwtManager := NewJWTManagerWithKeys(NewJWTKeySet(newEd25519Key(t, "key")), tokenDuration, tokenDuration, nil)
+	res, err := jwtManager.Verify(tokenPair.JwtToken)
+
+	assert.Nil(t, res)
+	assert.ErrorContains(t, err, "token key id is not provided")
+}
+
+func TestJWTManager_GenerateFailsWithoutSigningKey(t *testing.T) {
+	setupTest()
+
+	key := newEd25519Key(t, "key")
+	jwtManager := NewJWTManagerWithKeys(NewJWTKeySet(nil, &JWTKey{Id: key.Id, Method: key.Method, PublicKey: key.PublicKey}), tokenDuration, tokenDuration, nil)
+	user, _ := model.NewUser(
+		"admin",
+		"admin",
+		model.ADMIN_ROLE,
+	)
+
+	res, err := jwtManager.Generate(user)
+
+	assert.Nil(t, res)
+	assert.ErrorContains(t, err, "signing key is not provided")
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrNATSDisconnected is returned while connection to NATS server is being
+// restored.
+var ErrNATSDisconnected = errors.New("nats server is not connected")
+
+// NATSDeliverySubjectPrefix
+//
+// Deliveries are published to subject made of this prefix and id of the
+// user delivery is meant for, so every message_service replica receives only
+// deliveries for users connected to it.
+const NATSDeliverySubjectPrefix = "msg.deliveries."
+
+// NATSPresenceSubject is subject message_service replicas share presence
+// through. Every replica receives every update.
+const NATSPresenceSubject = "msg.presence"
+
+const (
+	natsReconnectDelay    = time.Second
+	natsMaxReconnectDelay = 30 * time.Second
+	natsPingInterval      = 20 * time.Second
+	natsMaxPingsOut       = 2
+	natsWriteTimeout      = 5 * time.Second
+)
+
+// natsMessageBufferSize is how many received messages are queued for
+// Consume. Messages received while queue is full are dropped and reported,
+// reading from connection never waits for Consume.
+const natsMessageBufferSize = 1024
+
+type natsSubscriber struct {
+	sessions     int
+	subscription *nats.Subscription
+}
+
+// NATSEventBus
+//
+// Event bus backed by NATS core publish-subscribe. Connection is restored
+// with exponential backoff after it is lost and subscriptions are sent to
+// server again. Half-open connections are detected with pings and writes
+// time out, so stalled server does not hold producers up.
+type NATSEventBus struct {
+	Dispatcher *EventDispatcher
+
+	conn        *nats.Conn
+	messages    chan *nats.Msg
+	done        chan struct{}
+	mutex       sync.Mutex
+	subscribers map[uuid.UUID]*natsSubscriber
+	presence    func(update *pb.PresenceUpdate)
+	listeners   []func(connected bool)
+	connected   bool
+	closed      bool
+}
+
+// NewNATSEventBus
+//
+// Connects to NATS server at [url], nats://[user:password@]host[:port] or
+// tls:// for encrypted connection. Server which is not reachable yet is
+// retried in background. Consuming bus subscribes to presence updates.
+func NewNATSEventBus(url string, dispatcher *EventDispatcher) (*NATSEventBus, error) {
+	bus := &NATSEventBus{
+		Dispatcher:  dispatcher,
+		messages:    make(chan *nats.Msg, natsMessageBufferSize),
+		done:        make(chan struct{}),
+		subscribers: make(map[uuid.UUID]*natsSubscriber),
+	}
+
+	conn, err := nats.Connect(
+		url,
+		nats.Name("msg"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			return backoffDelay(attempts-1, natsReconnectDelay, natsMaxReconnectDelay)
+		}),
+		nats.PingInterval(natsPingInterval),
+		nats.MaxPingsOutstanding(natsMaxPingsOut),
+		nats.FlusherTimeout(natsWriteTimeout),
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			logrus.Warningf("connection to nats server is lost: %v", err)
+			bus.updateState(conn)
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			logrus.Info("Connected to nats server")
+			bus.updateState(conn)
+		}),
+		nats.ClosedHandler(bus.updateState),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			logrus.Errorf("nats error: %v", err)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	bus.conn = conn
+	bus.updateState(conn)
+
+	if dispatcher != nil {
+		_, err = conn.ChanSubscribe(NATSPresenceSubject, bus.messages)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return bus, nil
+}
+
+// Produce publishes separate delivery for every recipient to subject of the
+// recipient. Fails with ErrNATSDisconnected while connection is down.
+func (b *NATSEventBus) Produce(delivery *pb.MessageDelivery) error {
+	return producePerRecipient(delivery, func(userId string, data []byte) error {
+		return b.publish(NATSDeliverySubjectPrefix+userId, data)
+	})
+}
+
+func (b *NATSEventBus) Broadcast(update *pb.PresenceUpdate) error {
+	data, err := proto.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	return b.publish(NATSPresenceSubject, data)
+}
+
+// publish fails instead of buffering messages while connection is down, so
+// callers such as outbox relay retry them. Messages larger than server
+// accepts fail with nats.ErrMaxPayload.
+func (b *NATSEventBus) publish(subject string, data []byte) error {
+	if !b.conn.IsConnected() {
+		return ErrNATSDisconnected
+	}
+
+	return b.conn.Publish(subject, data)
+}
+
+// Subscribe
+//
+// Subscribes to deliveries of user. Every session of user subscribes,
+// subject is subscribed to only once.
+func (b *NATSEventBus) Subscribe(userId uuid.UUID) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscriber, ok := b.subscribers[userId]
+	if ok {
+		subscriber.sessions++
+		return nil
+	}
+
+	subscription, err := b.conn.ChanSubscribe(NATSDeliverySubjectPrefix+userId.String(), b.messages)
+	if err != nil {
+		return err
+	}
+	b.subscribers[userId] = &natsSubscriber{sessions: 1, subscription: subscription}
+
+	return nil
+}
+
+// Unsubscribe
+//
+// Unsubscribes from deliveries of user once last session of user is gone.
+func (b *NATSEventBus) Unsubscribe(userId uuid.UUID) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	subscriber, ok := b.subscribers[userId]
+	if !ok {
+		return nil
+	}
+
+	if subscriber.sessions > 1 {
+		subscriber.sessions--
+		return nil
+	}
+
+	delete(b.subscribers, userId)
+	err := subscriber.subscription.Unsubscribe()
+	if errors.Is(err, nats.ErrConnectionClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// Consume hands received messages over until bus is closed.
+func (b *NATSEventBus) Consume() {
+	for {
+		select {
+		case message := <-b.messages:
+			b.handle(message)
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *NATSEventBus) handle(message *nats.Msg) {
+	if message.Subject == NATSPresenceSubject {
+		var update pb.PresenceUpdate
+		err := proto.Unmarshal(message.Data, &update)
+		if err != nil {
+			logrus.Errorf("could not unmarshal presence update: %v", err)
+			return
+		}
+
+		b.mutex.Lock()
+		handler := b.presence
+		b.mutex.Unlock()
+		if handler != nil {
+			handler(&update)
+		}
+		return
+	}
+
+	if !strings.HasPrefix(message.Subject, NATSDeliverySubjectPrefix) {
+		logrus.Warningf("unexpected nats subject %s", message.Subject)
+		return
+	}
+
+	var delivery pb.MessageDelivery
+	err := proto.Unmarshal(message.Data, &delivery)
+	if err != nil {
+		logrus.Errorf("could not unmarshal delivery: %v", err)
+		return
+	}
+
+	b.Dispatcher.Dispatch(&delivery)
+}
+
+func (b *NATSEventBus) OnPresence(handler func(update *pb.PresenceUpdate)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.presence = handler
+}
+
+func (b *NATSEventBus) OnStateChange(listener func(connected bool)) {
+	b.mutex.Lock()
+	b.listeners = append(b.listeners, listener)
+	connected := b.connected
+	b.mutex.Unlock()
+
+	listener(connected)
+}
+
+// updateState notifies listeners if state of [conn] differs from the one
+// they were told about. State is read under lock, so notifications racing
+// each other cannot leave listeners with stale state.
+func (b *NATSEventBus) updateState(conn *nats.Conn) {
+	b.mutex.Lock()
+	connected := conn.IsConnected()
+	changed := b.connected != connected
+	b.connected = connected
+	listeners := b.listeners
+	b.mutex.Unlock()
+
+	if changed {
+		notifyStateListeners(listeners, connected)
+	}
+}
+
+// Close closes connection for good and makes Consume return.
+func (b *NATSEventBus) Close() error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	b.mutex.Unlock()
+
+	b.conn.Close()
+
+	return nil
+}
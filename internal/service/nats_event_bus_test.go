@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeNATSServer accepts single connection, completes handshake and passes
+// protocol lines client sends afterwards to lines. Payload of PUB is passed
+// as separate line.
+func fakeNATSServer(t *testing.T, listener net.Listener, lines chan<- string) net.Conn {
+	conn, err := listener.Accept()
+	assert.Nil(t, err)
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("INFO {\"server_id\":\"test\",\"proto\":1,\"max_payload\":1048576}\r\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		if strings.TrimSpace(line) == "PING" {
+			break
+		}
+		assert.True(t, strings.HasPrefix(line, "CONNECT "))
+	}
+	conn.Write([]byte("PONG\r\n"))
+
+	go func() {
+		defer close(lines)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines <- line
+
+			if strings.HasPrefix(line, "PUB ") {
+				fields := strings.Fields(line)
+				size, _ := strconv.Atoi(fields[len(fields)-1])
+				payload := make([]byte, size+2)
+				_, err = io.ReadFull(reader, payload)
+				if err != nil {
+					return
+				}
+				lines <- string(payload[:size])
+			}
+		}
+	}()
+
+	return conn
+}
+
+func nextLine(t *testing.T, lines <-chan string) string {
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("nats client did not send anything")
+		return ""
+	}
+}
+
+func TestNATSEventBus_PublishesPerRecipientAndDispatchesSubscribedDeliveries(t *testing.T) {
+	setupTest()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	lines := make(chan string, 10)
+	served := make(chan net.Conn, 1)
+	go func() { served <- fakeNATSServer(t, listener, lines) }()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	bus, err := NewNATSEventBus("nats://"+listener.Addr().String(), NewEventDispatcher(sessionStoreMock))
+	assert.Nil(t, err)
+	server := <-served
+	consumed := make(chan struct{})
+	go func() {
+		bus.Consume()
+		close(consumed)
+	}()
+
+	userId := uuid.New()
+	err = bus.Subscribe(userId)
+	assert.Nil(t, err)
+	assert.Equal(t, "SUB msg.presence  1", nextLine(t, lines))
+	assert.Equal(t, fmt.Sprintf("SUB msg.deliveries.%s  2", userId), nextLine(t, lines))
+
+	delivery := &pb.MessageDelivery{
+		UserIds:   []string{userId.String()},
+		EventType: pb.EventType_MESSAGE_CREATED,
+		Message:   &pb.Message{Text: "text"},
+	}
+	err = bus.Produce(delivery)
+	assert.Nil(t, err)
+	data, _ := proto.Marshal(delivery)
+	assert.Equal(t, fmt.Sprintf("PUB msg.deliveries.%s %d", userId, len(data)), nextLine(t, lines))
+	assert.Equal(t, string(data), nextLine(t, lines))
+
+	sent := make(chan struct{})
+	sessionStoreMock.On("Send", userId, mock.MatchedBy(func(res *pb.MessageStreamResponse) bool {
+		return res.Message.GetText() == "text"
+	})).Return(nil).Run(func(mock.Arguments) { close(sent) })
+	server.Write([]byte(fmt.Sprintf("MSG msg.deliveries.%s 2 %d\r\n%s\r\n", userId, len(data), data)))
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("delivery was not dispatched")
+	}
+
+	bus.Close()
+	select {
+	case <-consumed:
+	case <-time.After(time.Second):
+		t.Fatal("Consume did not return after Close")
+	}
+}
+
+func TestNATSEventBus_ProduceFailsWhileDisconnected(t *testing.T) {
+	setupTest()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	bus, err := NewNATSEventBus("nats://"+address, nil)
+	assert.Nil(t, err)
+	defer bus.Close()
+
+	var states []bool
+	bus.OnStateChange(func(connected bool) {
+		states = append(states, connected)
+	})
+
+	err = bus.Produce(&pb.MessageDelivery{UserIds: []string{uuid.New().String()}})
+	assert.ErrorIs(t, err, ErrNATSDisconnected)
+	assert.Equal(t, []bool{false}, states)
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/repository"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// OutboxPollInterval is how often relay looks for events to publish.
+	OutboxPollInterval = time.Second
+	// OutboxBatchSize is the most events relay claims at once.
+	OutboxBatchSize = 100
+	// OutboxLease is time claimed event is kept from other relays for.
+	OutboxLease = 30 * time.Second
+	// OutboxRetention is how long sent events are kept for.
+	OutboxRetention = 24 * time.Hour
+	// OutboxMaxAttempts is how many times event is tried to be published
+	// before it is marked dead.
+	OutboxMaxAttempts = 20
+)
+
+const (
+	outboxRetryDelay    = time.Second
+	outboxMaxRetryDelay = 5 * time.Minute
+)
+
+// OutboxNotifier is told about events written to outbox, so they are
+// published without waiting for next poll.
+type OutboxNotifier interface {
+	Notify()
+}
+
+// OutboxRelay
+//
+// Publishes events written to outbox to message broker. Event which fails
+// to publish is retried with exponential backoff, up to OutboxMaxAttempts
+// times. Events are delivered at least once: event published by relay which
+// has died before marking it sent is published again.
+type OutboxRelay struct {
+	outboxStore repository.OutboxStore
+	producer    EventProducer
+	wake        chan struct{}
+}
+
+func NewOutboxRelay(outboxStore repository.OutboxStore, producer EventProducer) *OutboxRelay {
+	return &OutboxRelay{
+		outboxStore: outboxStore,
+		producer:    producer,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Notify makes relay publish due events right away instead of on next
+// poll. It never blocks, notifications arriving while relay is busy are
+// merged into one.
+func (r *OutboxRelay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run relays events until process exits. Events written by other processes
+// are picked up every OutboxPollInterval, events this process is notified
+// about immediately.
+func (r *OutboxRelay) Run() {
+	ticker := time.NewTicker(OutboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx := context.Background()
+
+		select {
+		case <-r.wake:
+			r.relayDue(ctx)
+			continue
+		case <-ticker.C:
+		}
+
+		r.relayDue(ctx)
+
+		deleted, err := r.outboxStore.DeleteSent(ctx, utils.Now().Add(-OutboxRetention))
+		if err != nil {
+			logrus.Errorf("could not delete sent outbox events: %v", err)
+		} else if deleted > 0 {
+			logrus.Debugf("deleted %d sent outbox events", deleted)
+		}
+	}
+}
+
+// relayDue publishes due events, backlog is drained without waiting for
+// next tick.
+func (r *OutboxRelay) relayDue(ctx context.Context) {
+	for {
+		claimed, err := r.Relay(ctx)
+		if err != nil {
+			logrus.Errorf("could not relay outbox: %v", err)
+		}
+		if err != nil || claimed < OutboxBatchSize {
+			return
+		}
+	}
+}
+
+// Relay publishes one batch of due events and returns number of events claimed.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	now := utils.Now()
+	events, err := r.outboxStore.Claim(ctx, now, now.Add(OutboxLease), OutboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, event := range events {
+		delivery, err := event.PbDelivery()
+		if err != nil {
+			// Event which cannot be read never succeeds
+			err = r.markDead(ctx, &event, err)
+			if err != nil {
+				return len(events), err
+			}
+			continue
+		}
+
+		err = r.producer.Produce(delivery)
+		if err != nil {
+			err = r.markFailed(ctx, &event, delivery, err)
+		} else {
+			err = r.outboxStore.MarkSent(ctx, event.Id, utils.Now())
+		}
+		if err != nil {
+			return len(events), err
+		}
+	}
+
+	return len(events), nil
+}
+
+// markFailed schedules next attempt of [event], event failing
+// OutboxMaxAttempts times is marked dead instead. Delivery published to some
+// of recipients is retried for the rest only, so they do not receive it
+// twice.
+func (r *OutboxRelay) markFailed(ctx context.Context, event *model.OutboxEvent, delivery *pb.MessageDelivery, err error) error {
+	if event.Attempts+1 >= OutboxMaxAttempts {
+		return r.markDead(ctx, event, err)
+	}
+
+	logrus.WithField("event_id", event.Id).WithField("attempts", event.Attempts+1).
+		Warningf("could not publish outbox event: %v", err)
+
+	nextAttemptAt := utils.Now().Add(backoffDelay(event.Attempts, outboxRetryDelay, outboxMaxRetryDelay))
+
+	var partial *PartialDeliveryError
+	if errors.As(err, &partial) {
+		delivery.UserIds = partial.UserIds
+		data, e := proto.Marshal(delivery)
+		if e != nil {
+			return e
+		}
+
+		return r.outboxStore.MarkPartiallySent(ctx, event.Id, data, nextAttemptAt, err.Error())
+	}
+
+	return r.outboxStore.MarkFailed(ctx, event.Id, nextAttemptAt, err.Error())
+}
+
+func (r *OutboxRelay) markDead(ctx context.Context, event *model.OutboxEvent, err error) error {
+	logrus.WithField("event_id", event.Id).WithField("attempts", event.Attempts+1).
+		Errorf("giving up on outbox event: %v", err)
+
+	return r.outboxStore.MarkDead(ctx, event.Id, utils.Now(), err.Error())
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/proto"
+)
+
+func newOutboxEvent(t *testing.T, id int64, attempts int) (model.OutboxEvent, *pb.MessageDelivery) {
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	delivery := message.NewDelivery([]uuid.UUID{uuid.New()})
+	data, err := proto.Marshal(delivery)
+	assert.Nil(t, err)
+
+	return model.OutboxEvent{Id: id, Delivery: data, Attempts: attempts}, delivery
+}
+
+func TestOutboxRelay_RelayMarksPublishedEventsSent(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	outboxStoreMock := new(mocks.OutboxStoreMock)
+	producerMock := new(mocks.EventProducerMock)
+	relay := NewOutboxRelay(outboxStoreMock, producerMock)
+
+	event, delivery := newOutboxEvent(t, 1, 0)
+	now := utils.Now()
+	outboxStoreMock.On("Claim", ctx, now, now.Add(OutboxLease), OutboxBatchSize).Return([]model.OutboxEvent{event}, nil)
+	producerMock.On("Produce", mock.MatchedBy(func(published *pb.MessageDelivery) bool {
+		return proto.Equal(delivery, published)
+	})).Return(nil)
+	outboxStoreMock.On("MarkSent", ctx, int64(1), now).Return(nil)
+
+	claimed, err := relay.Relay(ctx)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, claimed)
+	outboxStoreMock.AssertExpectations(t)
+	producerMock.AssertExpectations(t)
+}
+
+func TestOutboxRelay_RelayRetriesFailedEventsWithBackoff(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	outboxStoreMock := new(mocks.OutboxStoreMock)
+	producerMock := new(mocks.EventProducerMock)
+	relay := NewOutboxRelay(outboxStoreMock, producerMock)
+
+	failed, _ := newOutboxEvent(t, 1, 3)
+	sent, _ := newOutboxEvent(t, 2, 0)
+	now := utils.Now()
+	outboxStoreMock.On("Claim", ctx, now, now.Add(OutboxLease), OutboxBatchSize).Return([]model.OutboxEvent{failed, sent}, nil)
+	producerMock.On("Produce", mock.Anything).Return(errors.New("broker is down")).Once()
+	producerMock.On("Produce", mock.Anything).Return(nil).Once()
+	outboxStoreMock.On("MarkFailed", ctx, int64(1), now.Add(8*time.Second), "broker is down").Return(nil)
+	outboxStoreMock.On("MarkSent", ctx, int64(2), now).Return(nil)
+
+	claimed, err := relay.Relay(ctx)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, claimed)
+	outboxStoreMock.AssertExpectations(t)
+}
+
+func TestOutboxRelay_RelayRetriesPartialDeliveryForFailedRecipientsOnly(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	outboxStoreMock := new(mocks.OutboxStoreMock)
+	producerMock := new(mocks.EventProducerMock)
+	relay := NewOutboxRelay(outboxStoreMock, producerMock)
+
+	message := model.NewMessage(uuid.New(), uuid.New(), "text")
+	sentId, failedId := uuid.New(), uuid.New()
+	delivery := message.NewDelivery([]uuid.UUID{sentId, failedId})
+	data, err := proto.Marshal(delivery)
+	assert.Nil(t, err)
+	event := model.OutboxEvent{Id: 1, Delivery: data}
+
+	now := utils.Now()
+	outboxStoreMock.On("Claim", ctx, now, now.Add(OutboxLease), OutboxBatchSize).Return([]model.OutboxEvent{event}, nil)
+	producerMock.On("Produce", mock.Anything).Return(&PartialDeliveryError{UserIds: []string{failedId.String()}, Err: errors.New("broker is down")})
+	outboxStoreMock.On("MarkPartiallySent", ctx, int64(1), mock.MatchedBy(func(data []byte) bool {
+		var retried pb.MessageDelivery
+		return proto.Unmarshal(data, &retried) == nil &&
+			assert.ObjectsAreEqual([]string{failedId.String()}, retried.UserIds) &&
+			retried.Message.GetText() == "text"
+	}), now.Add(outboxRetryDelay), mock.Anything).Return(nil)
+
+	claimed, err := relay.Relay(ctx)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, claimed)
+	outboxStoreMock.AssertExpectations(t)
+	outboxStoreMock.AssertNotCalled(t, "MarkFailed", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestOutboxRelay_RelayMarksUnreadableEventDead(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	outboxStoreMock := new(mocks.OutboxStoreMock)
+	producerMock := new(mocks.EventProducerMock)
+	relay := NewOutboxRelay(outboxStoreMock, producerMock)
+
+	event := model.OutboxEvent{Id: 1, Delivery: []byte("not a delivery")}
+	now := utils.Now()
+	outboxStoreMock.On("Claim", ctx, now, now.Add(OutboxLease), OutboxBatchSize).Return([]model.OutboxEvent{event}, nil)
+	outboxStoreMock.On("MarkDead", ctx, int64(1), now, mock.Anything).Return(nil)
+
+	claimed, err := relay.Relay(ctx)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, claimed)
+	outboxStoreMock.AssertExpectations(t)
+	producerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestOutboxRelay_RelayMarksEventDeadAfterMaxAttempts(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	outboxStoreMock := new(mocks.OutboxStoreMock)
+	producerMock := new(mocks.EventProducerMock)
+	relay := NewOutboxRelay(outboxStoreMock, producerMock)
+
+	event, _ := newOutboxEvent(t, 1, OutboxMaxAttempts-1)
+	now := utils.Now()
+	outboxStoreMock.On("Claim", ctx, now, now.Add(OutboxLease), OutboxBatchSize).Return([]model.OutboxEvent{event}, nil)
+	producerMock.On("Produce", mock.Anything).Return(errors.New("broker is down"))
+	outboxStoreMock.On("MarkDead", ctx, int64(1), now, "broker is down").Return(nil)
+
+	claimed, err := relay.Relay(ctx)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, claimed)
+	outboxStoreMock.AssertExpectations(t)
+	outboxStoreMock.AssertNotCalled(t, "MarkFailed", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestOutboxRelay_RelayFailsIfClaimFails(t *testing.T) {
+	setupTest()
+
+	ctx := context.TODO()
+	outboxStoreMock := new(mocks.OutboxStoreMock)
+	producerMock := new(mocks.EventProducerMock)
+	relay := NewOutboxRelay(outboxStoreMock, producerMock)
+
+	expectedError := errors.New("some_error")
+	outboxStoreMock.On("Claim", ctx, mock.Anything, mock.Anything, OutboxBatchSize).Return(nil, expectedError)
+
+	claimed, err := relay.Relay(ctx)
+
+	assert.ErrorIs(t, err, expectedError)
+	assert.Equal(t, 0, claimed)
+	producerMock.AssertNotCalled(t, "Produce", mock.Anything)
+}
+
+func TestBackoffDelayIsCapped(t *testing.T) {
+	assert.Equal(t, time.Second, backoffDelay(0, time.Second, time.Minute))
+	assert.Equal(t, 4*time.Second, backoffDelay(2, time.Second, time.Minute))
+	assert.Equal(t, time.Minute, backoffDelay(100, time.Second, time.Minute))
+}
+
+func TestOutboxRelay_NotifyRelaysWithoutWaitingForPoll(t *testing.T) {
+	setupTest()
+
+	outboxStoreMock := new(mocks.OutboxStoreMock)
+	relay := NewOutboxRelay(outboxStoreMock, new(mocks.EventProducerMock))
+
+	claimed := make(chan struct{}, 1)
+	outboxStoreMock.On("Claim", mock.Anything, mock.Anything, mock.Anything, OutboxBatchSize).Return([]model.OutboxEvent{}, nil).
+		Run(func(mock.Arguments) { claimed <- struct{}{} })
+
+	go relay.Run()
+	relay.Notify()
+
+	select {
+	case <-claimed:
+	case <-time.After(OutboxPollInterval / 2):
+		t.Fatal("relay did not wake up on notification")
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/repository"
+	"github.com/google/uuid"
+)
+
+// PresenceSessionStore
+//
+// Session store which reports sessions to presence tracker, so user is
+// online while they have at least one session.
+type PresenceSessionStore struct {
+	repository.SessionStore
+
+	presence PresenceTracker
+}
+
+func NewPresenceSessionStore(sessionStore repository.SessionStore, presence PresenceTracker) *PresenceSessionStore {
+	return &PresenceSessionStore{
+		SessionStore: sessionStore,
+		presence:     presence,
+	}
+}
+
+func (s *PresenceSessionStore) Add(session *model.Session) error {
+	if err := s.SessionStore.Add(session); err != nil {
+		return err
+	}
+
+	s.presence.Connect(session.UserId)
+	return nil
+}
+
+func (s *PresenceSessionStore) Delete(userId, sessionId uuid.UUID) {
+	s.SessionStore.Delete(userId, sessionId)
+	s.presence.Disconnect(userId)
+}
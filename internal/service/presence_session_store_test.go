@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresenceSessionStore_AddConnectsUser(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	presenceMock := new(mocks.PresenceTrackerMock)
+	store := NewPresenceSessionStore(sessionStoreMock, presenceMock)
+	session := model.NewSession(uuid.New(), "", nil, 0, nil)
+
+	sessionStoreMock.On("Add", session).Return(nil)
+	presenceMock.On("Connect", session.UserId).Return()
+
+	err := store.Add(session)
+
+	assert.Nil(t, err)
+	presenceMock.AssertExpectations(t)
+}
+
+func TestPresenceSessionStore_AddDoesNotConnectIfStoreFails(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	presenceMock := new(mocks.PresenceTrackerMock)
+	store := NewPresenceSessionStore(sessionStoreMock, presenceMock)
+	session := model.NewSession(uuid.New(), "", nil, 0, nil)
+	expectedError := errors.New("some_error")
+
+	sessionStoreMock.On("Add", session).Return(expectedError)
+
+	err := store.Add(session)
+
+	assert.ErrorIs(t, err, expectedError)
+	presenceMock.AssertNotCalled(t, "Connect", session.UserId)
+}
+
+func TestPresenceSessionStore_DeleteDisconnectsUser(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	presenceMock := new(mocks.PresenceTrackerMock)
+	store := NewPresenceSessionStore(sessionStoreMock, presenceMock)
+	userId := uuid.New()
+	sessionId := uuid.New()
+
+	sessionStoreMock.On("Delete", userId, sessionId).Return()
+	presenceMock.On("Disconnect", userId).Return()
+
+	store.Delete(userId, sessionId)
+
+	sessionStoreMock.AssertExpectations(t)
+	presenceMock.AssertExpectations(t)
+}
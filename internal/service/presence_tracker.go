@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/repository"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// PresenceHeartbeatInterval
+//
+// How often replica reports every user connected to it. Replica which has
+// not reported for three intervals is considered gone.
+const PresenceHeartbeatInterval = 30 * time.Second
+
+type PresenceTracker interface {
+	Connect(userId uuid.UUID)
+	Disconnect(userId uuid.UUID)
+	IsOnline(userId uuid.UUID) bool
+}
+
+// BusPresenceTracker
+//
+// Tracks users connected to this replica and learns about users of other
+// replicas from presence updates broadcast through event bus.
+type BusPresenceTracker struct {
+	Bus       EventBus
+	ReplicaId string
+	View      *PresenceView
+
+	presenceStore repository.PresenceStore
+	roomStore     repository.RoomStore
+
+	mutex sync.Mutex
+	local map[uuid.UUID]int
+}
+
+func NewBusPresenceTracker(bus EventBus, presenceStore repository.PresenceStore, roomStore repository.RoomStore) *BusPresenceTracker {
+	tracker := &BusPresenceTracker{
+		Bus:           bus,
+		ReplicaId:     uuid.New().String(),
+		View:          NewPresenceView(),
+		presenceStore: presenceStore,
+		roomStore:     roomStore,
+		local:         make(map[uuid.UUID]int),
+	}
+	bus.OnPresence(tracker.apply)
+
+	return tracker
+}
+
+// Connect
+//
+// Counts new session of user on this replica. First session announces user
+// to other replicas and notifies room mates if user was offline everywhere.
+func (t *BusPresenceTracker) Connect(userId uuid.UUID) {
+	t.mutex.Lock()
+	t.local[userId]++
+	first := t.local[userId] == 1
+	changed := first && t.View.Set(t.ReplicaId, userId, true, utils.Now())
+	if first {
+		t.publish(&pb.PresenceUpdate{
+			ReplicaId: t.ReplicaId,
+			UserIds:   []string{userId.String()},
+			Online:    true,
+		})
+	}
+	t.mutex.Unlock()
+
+	if changed {
+		t.notify(userId, true)
+	}
+}
+
+// Disconnect
+//
+// Counts closed session of user on this replica. Once last session is gone
+// last seen time is stored and room mates are notified if user is not
+// connected to other replicas.
+func (t *BusPresenceTracker) Disconnect(userId uuid.UUID) {
+	t.mutex.Lock()
+	count, ok := t.local[userId]
+	if !ok {
+		t.mutex.Unlock()
+		return
+	}
+	if count > 1 {
+		t.local[userId]--
+		t.mutex.Unlock()
+		return
+	}
+	delete(t.local, userId)
+	now := utils.Now()
+	changed := t.View.Set(t.ReplicaId, userId, false, now)
+	t.publish(&pb.PresenceUpdate{
+		ReplicaId: t.ReplicaId,
+		UserIds:   []string{userId.String()},
+		Online:    false,
+	})
+	t.mutex.Unlock()
+
+	err := t.presenceStore.SetLastSeen(context.Background(), userId, now)
+	if err != nil {
+		logrus.Errorf("could not store last seen of user %s: %v", userId, err)
+	}
+
+	if changed {
+		t.notify(userId, false)
+	}
+}
+
+func (t *BusPresenceTracker) IsOnline(userId uuid.UUID) bool {
+	return t.View.IsOnline(userId)
+}
+
+// Run reports local users periodically. Updates of other replicas are
+// applied while event bus is consumed.
+func (t *BusPresenceTracker) Run() {
+	t.heartbeat()
+}
+
+func (t *BusPresenceTracker) apply(update *pb.PresenceUpdate) {
+	if update.ReplicaId == t.ReplicaId {
+		return
+	}
+
+	userIds, err := utils.StringSliceToUUIDSlice(update.UserIds...)
+	if err != nil {
+		logrus.Warningf("could not parse id: %v", err)
+		return
+	}
+
+	if update.Snapshot {
+		t.View.Replace(update.ReplicaId, userIds, utils.Now())
+		return
+	}
+
+	for _, userId := range userIds {
+		t.View.Set(update.ReplicaId, userId, update.Online, utils.Now())
+	}
+}
+
+func (t *BusPresenceTracker) heartbeat() {
+	ticker := time.NewTicker(PresenceHeartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.mutex.Lock()
+		userIds := make([]string, 0, len(t.local))
+		for userId := range t.local {
+			userIds = append(userIds, userId.String())
+		}
+		t.publish(&pb.PresenceUpdate{
+			ReplicaId: t.ReplicaId,
+			UserIds:   userIds,
+			Snapshot:  true,
+		})
+		t.mutex.Unlock()
+
+		t.View.Expire(utils.Now().Add(-3*PresenceHeartbeatInterval), t.ReplicaId)
+	}
+}
+
+// publish broadcasts [update] of local users. It is called with mutex held,
+// so updates leave replica in order local users changed in and quick
+// reconnect is not overtaken by preceding disconnect.
+func (t *BusPresenceTracker) publish(update *pb.PresenceUpdate) {
+	err := t.Bus.Broadcast(update)
+	if err != nil {
+		logrus.Errorf("could not publish presence update: %v", err)
+	}
+}
+
+// notify sends PRESENCE_CHANGED event to every user sharing room with [userId].
+func (t *BusPresenceTracker) notify(userId uuid.UUID, online bool) {
+	ctx := context.Background()
+
+	mates, err := t.roomStore.RoomMates(ctx, userId)
+	if err != nil {
+		logrus.Errorf("could not get room mates of user %s: %v", userId, err)
+		return
+	}
+	if len(mates) == 0 {
+		return
+	}
+
+	presence := &model.Presence{UserId: userId}
+	stored, err := t.presenceStore.Get(ctx, userId)
+	if err != nil {
+		logrus.Errorf("could not get presence of user %s: %v", userId, err)
+		return
+	}
+	if len(stored) > 0 {
+		presence = &stored[0]
+	}
+
+	recipientUserIds := []string{}
+	for _, id := range mates {
+		recipientUserIds = append(recipientUserIds, id.String())
+	}
+
+	err = t.Bus.Produce(&pb.MessageDelivery{
+		UserIds:   recipientUserIds,
+		EventType: pb.EventType_PRESENCE_CHANGED,
+		Presence:  presence.ToPbPresence(online, uuid.Nil),
+	})
+	if err != nil {
+		logrus.Errorf("could not send presence to event bus: %v", err)
+	}
+}
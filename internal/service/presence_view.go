@@ -0,0 +1,102 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type replicaPresence struct {
+	users  map[uuid.UUID]bool
+	seenAt time.Time
+}
+
+// PresenceView
+//
+// Users online on every message_service replica as reported by replicas
+// themselves. User is online while at least one replica reports them.
+type PresenceView struct {
+	mutex    sync.Mutex
+	replicas map[string]*replicaPresence
+}
+
+func NewPresenceView() *PresenceView {
+	return &PresenceView{
+		replicas: make(map[string]*replicaPresence),
+	}
+}
+
+// Set marks user online or offline on replica and reports whether it
+// changed user presence across all replicas.
+func (v *PresenceView) Set(replicaId string, userId uuid.UUID, online bool, at time.Time) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	wasOnline := v.isOnline(userId)
+
+	replica := v.replica(replicaId, at)
+	if online {
+		replica.users[userId] = true
+	} else {
+		delete(replica.users, userId)
+	}
+
+	return wasOnline != v.isOnline(userId)
+}
+
+// Replace sets every user online on replica at once.
+func (v *PresenceView) Replace(replicaId string, userIds []uuid.UUID, at time.Time) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	replica := v.replica(replicaId, at)
+	replica.users = make(map[uuid.UUID]bool, len(userIds))
+	for _, userId := range userIds {
+		replica.users[userId] = true
+	}
+}
+
+// Expire forgets replicas which have not reported since [before], except
+// [keepReplicaId]. Users of replica which went away without telling are
+// considered offline from then on.
+func (v *PresenceView) Expire(before time.Time, keepReplicaId string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for replicaId, replica := range v.replicas {
+		if replicaId != keepReplicaId && replica.seenAt.Before(before) {
+			delete(v.replicas, replicaId)
+		}
+	}
+}
+
+func (v *PresenceView) IsOnline(userId uuid.UUID) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.isOnline(userId)
+}
+
+func (v *PresenceView) isOnline(userId uuid.UUID) bool {
+	for _, replica := range v.replicas {
+		if replica.users[userId] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *PresenceView) replica(replicaId string, at time.Time) *replicaPresence {
+	replica, ok := v.replicas[replicaId]
+	if !ok {
+		replica = &replicaPresence{
+			users: make(map[uuid.UUID]bool),
+		}
+		v.replicas[replicaId] = replica
+	}
+	replica.seenAt = at
+
+	return replica
+}
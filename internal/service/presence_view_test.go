@@ -0,0 +1,56 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresenceView_SetReportsChangeAcrossReplicas(t *testing.T) {
+	setupTest()
+
+	view := NewPresenceView()
+	userId := uuid.New()
+
+	assert.True(t, view.Set("first", userId, true, utils.Now()))
+	// Already online on first replica
+	assert.False(t, view.Set("second", userId, true, utils.Now()))
+	// Still online on second replica
+	assert.False(t, view.Set("first", userId, false, utils.Now()))
+	assert.True(t, view.IsOnline(userId))
+	assert.True(t, view.Set("second", userId, false, utils.Now()))
+	assert.False(t, view.IsOnline(userId))
+}
+
+func TestPresenceView_ReplaceOverridesReplicaUsers(t *testing.T) {
+	setupTest()
+
+	view := NewPresenceView()
+	staleUserId := uuid.New()
+	userId := uuid.New()
+
+	view.Set("replica", staleUserId, true, utils.Now())
+	view.Replace("replica", []uuid.UUID{userId}, utils.Now())
+
+	assert.False(t, view.IsOnline(staleUserId))
+	assert.True(t, view.IsOnline(userId))
+}
+
+func TestPresenceView_ExpireForgetsSilentReplicas(t *testing.T) {
+	setupTest()
+
+	view := NewPresenceView()
+	ownUserId := uuid.New()
+	remoteUserId := uuid.New()
+	old := utils.Now().Add(-time.Hour)
+
+	view.Set("own", ownUserId, true, old)
+	view.Set("remote", remoteUserId, true, old)
+	view.Expire(utils.Now(), "own")
+
+	assert.True(t, view.IsOnline(ownUserId))
+	assert.False(t, view.IsOnline(remoteUserId))
+}
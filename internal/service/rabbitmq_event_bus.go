@@ -0,0 +1,323 @@
+package service
+
+import (
+	"errors"
+	"sync"
+
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/proto"
+)
+
+// DeliveryExchange
+//
+// Direct exchange deliveries are routed through. Routing key is id of the
+// user delivery is meant for, so every message_service replica receives only
+// deliveries for users connected to it.
+const DeliveryExchange = "msg.deliveries"
+
+// PresenceExchange
+//
+// Fanout exchange message_service replicas share presence through. Every
+// replica receives every update.
+const PresenceExchange = "msg.presence"
+
+// RabbitMQEventBus
+//
+// Event bus backed by RabbitMQ. Every consuming replica declares exclusive
+// queue bound to deliveries of its subscribed users and to presence updates.
+type RabbitMQEventBus struct {
+	Connection *AMQPConnection
+	Dispatcher *EventDispatcher
+	QueueName  string
+
+	mutex       sync.Mutex
+	channel     *amqp.Channel
+	subscribers map[uuid.UUID]int
+	presence    func(update *pb.PresenceUpdate)
+	deliveries  chan (<-chan amqp.Delivery)
+}
+
+func NewRabbitMQEventBus(connection *AMQPConnection, dispatcher *EventDispatcher) *RabbitMQEventBus {
+	bus := &RabbitMQEventBus{
+		Connection:  connection,
+		Dispatcher:  dispatcher,
+		QueueName:   uuid.New().String(),
+		subscribers: make(map[uuid.UUID]int),
+		deliveries:  make(chan (<-chan amqp.Delivery), 1),
+	}
+
+	err := connection.OnConnect(bus.setup)
+	if err != nil {
+		logrus.Fatalf("could not set up event bus: %s", err.Error())
+	}
+
+	return bus
+}
+
+// setup
+//
+// Declares exchanges on fresh channel. Consuming bus also declares its queue,
+// binds it to deliveries of every subscribed user again and starts
+// consuming from it.
+func (b *RabbitMQEventBus) setup(channel *amqp.Channel) error {
+	err := declareDeliveryExchange(channel)
+	if err != nil {
+		return err
+	}
+
+	err = declarePresenceExchange(channel)
+	if err != nil {
+		return err
+	}
+
+	if b.Dispatcher == nil {
+		return nil
+	}
+
+	_, err = channel.QueueDeclare(
+		b.QueueName, // channelname
+		false,       // durable
+		false,       // delete when unused
+		true,        // exclusive
+		false,       // no-wait
+		nil,         // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	err = channel.QueueBind(b.QueueName, "", PresenceExchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	for userId := range b.subscribers {
+		err = b.bind(channel, userId)
+		if err != nil {
+			b.mutex.Unlock()
+			return err
+		}
+	}
+	b.channel = channel
+	b.mutex.Unlock()
+
+	deliveries, err := channel.Consume(
+		b.QueueName, // queue
+		"",          // consumer
+		true,        // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+	if err != nil {
+		return err
+	}
+
+	// Stream of previous channel is not consumed yet and is closed anyway
+	select {
+	case <-b.deliveries:
+	default:
+	}
+	b.deliveries <- deliveries
+
+	return nil
+}
+
+// Produce
+//
+// Publishes separate delivery for every recipient, routed by recipient id.
+// Fails with ErrAMQPDisconnected while connection to broker is down.
+func (b *RabbitMQEventBus) Produce(delivery *pb.MessageDelivery) error {
+	channel, err := b.Connection.Channel()
+	if err != nil {
+		return err
+	}
+
+	return producePerRecipient(delivery, func(userId string, data []byte) error {
+		return publish(channel, DeliveryExchange, userId, data)
+	})
+}
+
+func (b *RabbitMQEventBus) Broadcast(update *pb.PresenceUpdate) error {
+	channel, err := b.Connection.Channel()
+	if err != nil {
+		return err
+	}
+
+	data, err := proto.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	return publish(channel, PresenceExchange, "", data)
+}
+
+// Subscribe
+//
+// Binds queue to deliveries of user. Every session of user subscribes, queue
+// is bound only once. While connection is down subscription is only counted,
+// queue is bound once connection is restored.
+func (b *RabbitMQEventBus) Subscribe(userId uuid.UUID) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.subscribers[userId]++
+	if b.subscribers[userId] > 1 || b.channel == nil {
+		return nil
+	}
+
+	err := b.bind(b.channel, userId)
+	if err != nil && !errors.Is(err, amqp.ErrClosed) {
+		b.subscribers[userId]--
+		if b.subscribers[userId] == 0 {
+			delete(b.subscribers, userId)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Unsubscribe
+//
+// Unbinds queue from deliveries of user once last session of user is gone.
+func (b *RabbitMQEventBus) Unsubscribe(userId uuid.UUID) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	count, ok := b.subscribers[userId]
+	if !ok {
+		return nil
+	}
+
+	if count > 1 {
+		b.subscribers[userId]--
+		return nil
+	}
+
+	delete(b.subscribers, userId)
+	if b.channel == nil {
+		return nil
+	}
+
+	err := b.channel.QueueUnbind(
+		b.QueueName,      // queue name
+		userId.String(),  // routing key
+		DeliveryExchange, // exchange
+		nil,
+	)
+	if errors.Is(err, amqp.ErrClosed) {
+		// Queue is exclusive, it is gone together with connection
+		return nil
+	}
+
+	return err
+}
+
+func (b *RabbitMQEventBus) bind(channel *amqp.Channel, userId uuid.UUID) error {
+	return channel.QueueBind(
+		b.QueueName,      // queue name
+		userId.String(),  // routing key
+		DeliveryExchange, // exchange
+		false,
+		nil,
+	)
+}
+
+// Consume
+//
+// Handles deliveries of current channel. When connection is restored
+// deliveries of new channel are handled.
+func (b *RabbitMQEventBus) Consume() {
+	for deliveries := range b.deliveries {
+		for delivery := range deliveries {
+			b.handle(delivery)
+		}
+
+		logrus.Warning("deliveries of message broker stopped, waiting for reconnect")
+	}
+}
+
+func (b *RabbitMQEventBus) handle(delivery amqp.Delivery) {
+	if delivery.Exchange == PresenceExchange {
+		var update pb.PresenceUpdate
+		err := proto.Unmarshal(delivery.Body, &update)
+		if err != nil {
+			logrus.Errorf("could not unmarshal presence update: %v", err)
+			return
+		}
+
+		b.mutex.Lock()
+		handler := b.presence
+		b.mutex.Unlock()
+		if handler != nil {
+			handler(&update)
+		}
+		return
+	}
+
+	var messageDelivery pb.MessageDelivery
+	err := proto.Unmarshal(delivery.Body, &messageDelivery)
+	if err != nil {
+		logrus.Errorf("could not unmarshal delivery: %v", err)
+		return
+	}
+
+	b.Dispatcher.Dispatch(&messageDelivery)
+}
+
+func (b *RabbitMQEventBus) OnPresence(handler func(update *pb.PresenceUpdate)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.presence = handler
+}
+
+func (b *RabbitMQEventBus) OnStateChange(listener func(connected bool)) {
+	b.Connection.OnStateChange(listener)
+}
+
+func (b *RabbitMQEventBus) Close() error {
+	return b.Connection.Close()
+}
+
+func declareDeliveryExchange(channel *amqp.Channel) error {
+	return channel.ExchangeDeclare(
+		DeliveryExchange, // name
+		"direct",         // type
+		true,             // durable
+		false,            // auto-deleted
+		false,            // internal
+		false,            // no-wait
+		nil,              // arguments
+	)
+}
+
+func declarePresenceExchange(channel *amqp.Channel) error {
+	return channel.ExchangeDeclare(
+		PresenceExchange, // name
+		"fanout",         // type
+		true,             // durable
+		false,            // auto-deleted
+		false,            // internal
+		false,            // no-wait
+		nil,              // arguments
+	)
+}
+
+func publish(channel *amqp.Channel, exchange, routingKey string, data []byte) error {
+	return channel.Publish(
+		exchange,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqp.Publishing{
+			ContentType: "application/protobuf",
+			Body:        data,
+		})
+}
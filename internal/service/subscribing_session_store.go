@@ -0,0 +1,49 @@
+package service
+
+import (
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/ArtyomArtamonov/msg/internal/repository"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// SubscribingSessionStore
+//
+// Session store which subscribes consumer to deliveries of user while user
+// has at least one session. Consumer delivers to wrapped store directly.
+type SubscribingSessionStore struct {
+	repository.SessionStore
+
+	consumer EventConsumer
+}
+
+func NewSubscribingSessionStore(sessionStore repository.SessionStore, consumer EventConsumer) *SubscribingSessionStore {
+	return &SubscribingSessionStore{
+		SessionStore: sessionStore,
+		consumer:     consumer,
+	}
+}
+
+func (s *SubscribingSessionStore) Add(session *model.Session) error {
+	if err := s.consumer.Subscribe(session.UserId); err != nil {
+		return err
+	}
+
+	if err := s.SessionStore.Add(session); err != nil {
+		s.unsubscribe(session.UserId)
+		return err
+	}
+
+	return nil
+}
+
+func (s *SubscribingSessionStore) Delete(userId, sessionId uuid.UUID) {
+	s.SessionStore.Delete(userId, sessionId)
+	s.unsubscribe(userId)
+}
+
+func (s *SubscribingSessionStore) unsubscribe(userId uuid.UUID) {
+	if err := s.consumer.Unsubscribe(userId); err != nil {
+		logrus.Errorf("could not unsubscribe user %s from deliveries: %v", userId, err)
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	"github.com/ArtyomArtamonov/msg/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribingSessionStore_AddSubscribesUser(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	consumerMock := new(mocks.EventConsumerMock)
+	store := NewSubscribingSessionStore(sessionStoreMock, consumerMock)
+	session := model.NewSession(uuid.New(), "", nil, 0, nil)
+
+	consumerMock.On("Subscribe", session.UserId).Return(nil)
+	sessionStoreMock.On("Add", session).Return(nil)
+
+	err := store.Add(session)
+
+	assert.Nil(t, err)
+	consumerMock.AssertExpectations(t)
+	sessionStoreMock.AssertExpectations(t)
+}
+
+func TestSubscribingSessionStore_AddFailsIfSubscribeFails(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	consumerMock := new(mocks.EventConsumerMock)
+	store := NewSubscribingSessionStore(sessionStoreMock, consumerMock)
+	session := model.NewSession(uuid.New(), "", nil, 0, nil)
+	expectedError := errors.New("some_error")
+
+	consumerMock.On("Subscribe", session.UserId).Return(expectedError)
+
+	err := store.Add(session)
+
+	assert.ErrorIs(t, err, expectedError)
+	sessionStoreMock.AssertNotCalled(t, "Add", session)
+}
+
+func TestSubscribingSessionStore_AddUnsubscribesIfStoreFails(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	consumerMock := new(mocks.EventConsumerMock)
+	store := NewSubscribingSessionStore(sessionStoreMock, consumerMock)
+	session := model.NewSession(uuid.New(), "", nil, 0, nil)
+	expectedError := errors.New("some_error")
+
+	consumerMock.On("Subscribe", session.UserId).Return(nil)
+	sessionStoreMock.On("Add", session).Return(expectedError)
+	consumerMock.On("Unsubscribe", session.UserId).Return(nil)
+
+	err := store.Add(session)
+
+	assert.ErrorIs(t, err, expectedError)
+	consumerMock.AssertExpectations(t)
+}
+
+func TestSubscribingSessionStore_DeleteUnsubscribesUser(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	consumerMock := new(mocks.EventConsumerMock)
+	store := NewSubscribingSessionStore(sessionStoreMock, consumerMock)
+	userId := uuid.New()
+	sessionId := uuid.New()
+
+	sessionStoreMock.On("Delete", userId, sessionId).Return()
+	consumerMock.On("Unsubscribe", userId).Return(nil)
+
+	store.Delete(userId, sessionId)
+
+	sessionStoreMock.AssertExpectations(t)
+	consumerMock.AssertExpectations(t)
+}
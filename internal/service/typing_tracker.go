@@ -0,0 +1,90 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/repository"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+)
+
+// TypingTimeout
+//
+// How long typing indicator stays active unless client refreshes or stops it.
+const TypingTimeout = 6 * time.Second
+
+type typingKey struct {
+	roomId      string
+	userId      string
+	recipientId uuid.UUID
+}
+
+// TypingTracker
+//
+// Delivers typing events to local sessions and stops indicators which were
+// not refreshed in time, so recipients are not left with stale "typing"
+// state when client of typing user goes away without sending stop.
+type TypingTracker struct {
+	sessionStore repository.SessionStore
+	timeout      time.Duration
+
+	mutex  sync.Mutex
+	timers map[typingKey]*typingTimer
+}
+
+type typingTimer struct {
+	timer *time.Timer
+}
+
+func NewTypingTracker(sessionStore repository.SessionStore, timeout time.Duration) *TypingTracker {
+	return &TypingTracker{
+		sessionStore: sessionStore,
+		timeout:      timeout,
+		timers:       make(map[typingKey]*typingTimer),
+	}
+}
+
+func (t *TypingTracker) Deliver(recipientId uuid.UUID, response *pb.MessageStreamResponse) error {
+	typing := response.Typing
+	key := typingKey{
+		roomId:      typing.RoomId,
+		userId:      typing.UserId,
+		recipientId: recipientId,
+	}
+
+	t.mutex.Lock()
+	if current, ok := t.timers[key]; ok {
+		current.timer.Stop()
+		delete(t.timers, key)
+	}
+	if response.EventType == pb.EventType_TYPING_STARTED {
+		entry := &typingTimer{}
+		entry.timer = time.AfterFunc(t.timeout, func() {
+			t.expire(key, entry)
+		})
+		t.timers[key] = entry
+	}
+	t.mutex.Unlock()
+
+	return t.sessionStore.Send(recipientId, response)
+}
+
+func (t *TypingTracker) expire(key typingKey, entry *typingTimer) {
+	t.mutex.Lock()
+	// Indicator could be refreshed or stopped while timer was firing
+	if t.timers[key] != entry {
+		t.mutex.Unlock()
+		return
+	}
+	delete(t.timers, key)
+	t.mutex.Unlock()
+
+	t.sessionStore.Send(key.recipientId, &pb.MessageStreamResponse{
+		EventType: pb.EventType_TYPING_STOPPED,
+		Typing: &pb.TypingIndicator{
+			RoomId: key.roomId,
+			UserId: key.userId,
+		},
+	})
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ArtyomArtamonov/msg/internal/mocks"
+	pb "github.com/ArtyomArtamonov/msg/internal/server/msg-proto"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTypingTracker_DeliverStopsIndicatorAfterTimeout(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	tracker := NewTypingTracker(sessionStoreMock, 10*time.Millisecond)
+
+	recipientId := uuid.New()
+	started := &pb.MessageStreamResponse{
+		EventType: pb.EventType_TYPING_STARTED,
+		Typing: &pb.TypingIndicator{
+			RoomId: uuid.New().String(),
+			UserId: uuid.New().String(),
+		},
+	}
+	stopped := make(chan *pb.MessageStreamResponse, 1)
+	sessionStoreMock.On("Send", recipientId, started).Return(nil)
+	sessionStoreMock.On("Send", recipientId, mock.MatchedBy(func(res *pb.MessageStreamResponse) bool {
+		return res.EventType == pb.EventType_TYPING_STOPPED
+	})).Run(func(args mock.Arguments) {
+		stopped <- args.Get(1).(*pb.MessageStreamResponse)
+	}).Return(nil)
+
+	err := tracker.Deliver(recipientId, started)
+	assert.Nil(t, err)
+
+	select {
+	case res := <-stopped:
+		assert.Equal(t, started.Typing.RoomId, res.Typing.RoomId)
+		assert.Equal(t, started.Typing.UserId, res.Typing.UserId)
+	case <-time.After(time.Second):
+		t.Fatal("typing indicator was not stopped")
+	}
+}
+
+func TestTypingTracker_DeliverStopCancelsExpiry(t *testing.T) {
+	setupTest()
+
+	sessionStoreMock := new(mocks.SessionStoreMock)
+	tracker := NewTypingTracker(sessionStoreMock, 10*time.Millisecond)
+
+	recipientId := uuid.New()
+	typing := &pb.TypingIndicator{
+		RoomId: uuid.New().String(),
+		UserId: uuid.New().String(),
+	}
+	started := &pb.MessageStreamResponse{EventType: pb.EventType_TYPING_STARTED, Typing: typing}
+	stopped := &pb.MessageStreamResponse{EventType: pb.EventType_TYPING_STOPPED, Typing: typing}
+	sessionStoreMock.On("Send", recipientId, mock.Anything).Return(nil)
+
+	tracker.Deliver(recipientId, started)
+	tracker.Deliver(recipientId, stopped)
+	time.Sleep(50 * time.Millisecond)
+
+	sessionStoreMock.AssertNumberOfCalls(t, "Send", 2)
+	assert.Empty(t, tracker.timers)
+}
@@ -8,3 +8,9 @@ func ArrayContains[T comparable](array []T, element T) bool {
 	}
 	return false
 }
+
+func Reverse[T any](array []T) {
+	for i, j := 0, len(array)-1; i < j; i, j = i+1, j-1 {
+		array[i], array[j] = array[j], array[i]
+	}
+}
@@ -1,24 +0,0 @@
-package utils
-
-import (
-	"encoding/base64"
-	"time"
-)
-
-func EncodePageToken(lastMessageDate time.Time) string {
-	return base64.StdEncoding.EncodeToString([]byte(lastMessageDate.Format(time.RFC3339)))
-}
-
-func DecodePageToken(token string) (*time.Time, error) {
-	buf, err := base64.StdEncoding.DecodeString(token)
-	if err != nil {
-		return nil, err
-	}
-
-	t, err := time.Parse(time.RFC3339, string(buf))
-	if err != nil {
-		return nil, err
-	}
-
-	return &t, nil
-}
@@ -0,0 +1,3 @@
+DROP TABLE hidden_messages;
+ALTER TABLE messages DROP COLUMN deleted_at;
+ALTER TABLE messages DROP COLUMN edited_at;
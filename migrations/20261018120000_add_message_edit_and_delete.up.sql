@@ -0,0 +1,16 @@
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE TABLE hidden_messages (
+    message_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    PRIMARY KEY(message_id, user_id),
+    CONSTRAINT fk_message_id
+        FOREIGN KEY(message_id)
+            REFERENCES messages(id)
+            ON DELETE CASCADE,
+    CONSTRAINT fk_user_id
+        FOREIGN KEY(user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP INDEX messages_room_id_created_at_idx;
+DROP TABLE read_markers;
@@ -0,0 +1,18 @@
+CREATE TABLE read_markers (
+    room_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    message_id UUID NOT NULL,
+    message_created_at TIMESTAMP NOT NULL,
+    read_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY(room_id, user_id),
+    CONSTRAINT fk_user_in_room
+        FOREIGN KEY(room_id, user_id)
+            REFERENCES user_in_room(room_id, user_id)
+            ON DELETE CASCADE,
+    CONSTRAINT fk_message_id
+        FOREIGN KEY(message_id)
+            REFERENCES messages(id)
+            ON DELETE CASCADE
+);
+
+CREATE INDEX messages_room_id_created_at_idx ON messages(room_id, created_at);
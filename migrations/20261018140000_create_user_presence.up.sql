@@ -0,0 +1,9 @@
+CREATE TABLE user_presence (
+    user_id UUID NOT NULL PRIMARY KEY,
+    last_seen_at TIMESTAMP,
+    hide_last_seen BOOLEAN NOT NULL DEFAULT FALSE,
+    CONSTRAINT fk_user_id
+        FOREIGN KEY(user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+ALTER TABLE messages ADD COLUMN system BOOLEAN NOT NULL DEFAULT FALSE;
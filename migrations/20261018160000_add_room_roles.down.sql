@@ -0,0 +1,2 @@
+DROP INDEX user_in_room_single_owner_idx;
+ALTER TABLE user_in_room DROP COLUMN role;
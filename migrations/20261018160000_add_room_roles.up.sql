@@ -0,0 +1,19 @@
+ALTER TABLE user_in_room ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'member'
+    CONSTRAINT user_in_room_role_check CHECK (role IN ('owner', 'moderator', 'member'));
+
+CREATE UNIQUE INDEX user_in_room_single_owner_idx ON user_in_room(room_id) WHERE role='owner';
+
+-- Group rooms created earlier get an owner: author of the earliest message
+-- who is still a member, or any member if none of them wrote anything.
+UPDATE user_in_room SET role='owner'
+FROM (
+    SELECT DISTINCT ON (user_in_room.room_id) user_in_room.room_id, user_in_room.user_id
+    FROM user_in_room
+    JOIN rooms ON rooms.id=user_in_room.room_id AND NOT rooms.dialog_room
+    LEFT JOIN LATERAL (
+        SELECT MIN(messages.created_at) AS created_at FROM messages
+        WHERE messages.room_id=user_in_room.room_id AND messages.user_id=user_in_room.user_id
+    ) first_message ON TRUE
+    ORDER BY user_in_room.room_id, first_message.created_at ASC NULLS LAST, user_in_room.user_id
+) owners
+WHERE user_in_room.room_id=owners.room_id AND user_in_room.user_id=owners.user_id;
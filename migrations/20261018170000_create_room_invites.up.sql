@@ -0,0 +1,20 @@
+CREATE TABLE room_invites (
+    code VARCHAR(32) PRIMARY KEY,
+    room_id UUID NOT NULL,
+    created_by UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at TIMESTAMP,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    uses INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMP,
+    CONSTRAINT fk_room_id
+        FOREIGN KEY(room_id)
+            REFERENCES rooms(id)
+            ON DELETE CASCADE,
+    CONSTRAINT fk_created_by
+        FOREIGN KEY(created_by)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
+
+CREATE INDEX room_invites_room_id_idx ON room_invites(room_id);
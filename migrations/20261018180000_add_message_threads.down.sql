@@ -0,0 +1,5 @@
+DROP INDEX messages_thread_root_id_created_at_idx;
+
+ALTER TABLE messages
+    DROP COLUMN thread_root_id,
+    DROP COLUMN reply_to_id;
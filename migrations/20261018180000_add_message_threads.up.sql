@@ -0,0 +1,5 @@
+ALTER TABLE messages
+    ADD COLUMN reply_to_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    ADD COLUMN thread_root_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+
+CREATE INDEX messages_thread_root_id_created_at_idx ON messages(thread_root_id, created_at) WHERE thread_root_id IS NOT NULL;
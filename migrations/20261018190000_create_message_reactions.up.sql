@@ -0,0 +1,15 @@
+CREATE TABLE message_reactions (
+    message_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    emoji VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY(message_id, user_id, emoji),
+    CONSTRAINT fk_message_id
+        FOREIGN KEY(message_id)
+            REFERENCES messages(id)
+            ON DELETE CASCADE,
+    CONSTRAINT fk_user_id
+        FOREIGN KEY(user_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE
+);
@@ -0,0 +1,27 @@
+CREATE TABLE attachments (
+    id UUID PRIMARY KEY,
+    room_id UUID NOT NULL,
+    uploader_id UUID NOT NULL,
+    message_id UUID,
+    file_name VARCHAR(255) NOT NULL,
+    mime_type VARCHAR(127) NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    CONSTRAINT fk_room_id
+        FOREIGN KEY(room_id)
+            REFERENCES rooms(id)
+            ON DELETE CASCADE,
+    CONSTRAINT fk_uploader_id
+        FOREIGN KEY(uploader_id)
+            REFERENCES users(id)
+            ON DELETE CASCADE,
+    CONSTRAINT fk_message_id
+        FOREIGN KEY(message_id)
+            REFERENCES messages(id)
+            ON DELETE CASCADE
+);
+
+CREATE INDEX attachments_message_id_idx ON attachments(message_id) WHERE message_id IS NOT NULL;
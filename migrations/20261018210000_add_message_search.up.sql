@@ -0,0 +1,6 @@
+-- 'simple' configuration does no stemming, so messages in any language are
+-- searchable by exact words
+ALTER TABLE messages
+    ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (to_tsvector('simple', text)) STORED;
+
+CREATE INDEX messages_search_vector_idx ON messages USING GIN(search_vector);
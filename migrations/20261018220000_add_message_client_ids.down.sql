@@ -0,0 +1,3 @@
+DROP INDEX messages_user_id_client_message_id_idx;
+
+ALTER TABLE messages DROP COLUMN client_message_id;
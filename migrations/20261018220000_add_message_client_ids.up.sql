@@ -0,0 +1,3 @@
+ALTER TABLE messages ADD COLUMN client_message_id VARCHAR(64);
+
+CREATE UNIQUE INDEX messages_user_id_client_message_id_idx ON messages(user_id, client_message_id) WHERE client_message_id IS NOT NULL;
@@ -0,0 +1,16 @@
+ALTER TABLE read_markers ADD COLUMN message_created_at TIMESTAMP;
+
+UPDATE read_markers SET message_created_at=messages.created_at FROM messages WHERE messages.id=read_markers.message_id;
+
+ALTER TABLE read_markers ALTER COLUMN message_created_at SET NOT NULL;
+
+ALTER TABLE read_markers DROP COLUMN message_seq;
+
+DROP INDEX messages_thread_root_id_seq_idx;
+CREATE INDEX messages_thread_root_id_created_at_idx ON messages(thread_root_id, created_at) WHERE thread_root_id IS NOT NULL;
+
+DROP INDEX messages_room_id_seq_idx;
+
+ALTER TABLE messages DROP COLUMN seq;
+
+ALTER TABLE rooms DROP COLUMN last_seq;
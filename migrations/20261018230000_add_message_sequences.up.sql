@@ -0,0 +1,27 @@
+ALTER TABLE rooms ADD COLUMN last_seq BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE messages ADD COLUMN seq BIGINT;
+
+UPDATE messages SET seq=numbered.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY created_at, id) AS seq FROM messages
+) AS numbered
+WHERE messages.id=numbered.id;
+
+UPDATE rooms SET last_seq=COALESCE((SELECT MAX(seq) FROM messages WHERE messages.room_id=rooms.id), 0);
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+
+CREATE UNIQUE INDEX messages_room_id_seq_idx ON messages(room_id, seq);
+
+-- Threads are listed by seq as well
+DROP INDEX messages_thread_root_id_created_at_idx;
+CREATE INDEX messages_thread_root_id_seq_idx ON messages(thread_root_id, seq) WHERE thread_root_id IS NOT NULL;
+
+ALTER TABLE read_markers ADD COLUMN message_seq BIGINT;
+
+UPDATE read_markers SET message_seq=messages.seq FROM messages WHERE messages.id=read_markers.message_id;
+
+ALTER TABLE read_markers ALTER COLUMN message_seq SET NOT NULL;
+
+ALTER TABLE read_markers DROP COLUMN message_created_at;
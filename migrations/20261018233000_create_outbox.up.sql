@@ -0,0 +1,14 @@
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    delivery BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_error TEXT,
+    sent_at TIMESTAMP,
+    -- Event which cannot be published is parked here instead of being retried forever
+    dead_at TIMESTAMP
+);
+
+CREATE INDEX outbox_pending_idx ON outbox(next_attempt_at) WHERE sent_at IS NULL AND dead_at IS NULL;
+CREATE INDEX outbox_sent_at_idx ON outbox(sent_at) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX attachments_unsent_idx;
+DROP TRIGGER attachments_queue_blob_deletion ON attachments;
+DROP FUNCTION queue_attachment_blob_deletion();
+DROP TABLE deleted_attachment_blobs;
@@ -0,0 +1,19 @@
+-- Blobs of deleted attachment rows, including rows removed by cascades, are
+-- queued here and removed from blob store by api_service.
+CREATE TABLE deleted_attachment_blobs (
+    blob_key VARCHAR(128) PRIMARY KEY,
+    deleted_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE FUNCTION queue_attachment_blob_deletion() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO deleted_attachment_blobs(blob_key) VALUES(OLD.id::TEXT) ON CONFLICT DO NOTHING;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER attachments_queue_blob_deletion
+    AFTER DELETE ON attachments
+    FOR EACH ROW EXECUTE PROCEDURE queue_attachment_blob_deletion();
+
+CREATE INDEX attachments_unsent_idx ON attachments(uploader_id, created_at) WHERE message_id IS NULL;